@@ -0,0 +1,617 @@
+// Code generated by cmd/schemagen. DO NOT EDIT.
+
+export const PROTOCOL_VERSION = 1;
+
+export interface Packet<T = unknown> {
+	type: string;
+	payload: T;
+	nonce?: number;
+}
+
+export interface AuthAuthenticatePacket {
+	token: string;
+	clientType: string;
+}
+
+export interface AuthAuthenticateResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	userID: number;
+	sessionID: string;
+	expiresAt: number;
+}
+
+export interface AuthChangePasswordPacket {
+	currentPassword: string;
+	newPassword: string;
+}
+
+export interface AuthChangePasswordResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	revokedSessions: number;
+}
+
+export interface AuthCreateResetCodePacket {
+	userID: number;
+	username?: string;
+}
+
+export interface AuthCreateResetCodeResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	userID: number;
+	resetCode?: string;
+	expiresAt?: number;
+}
+
+export interface AuthLogin2FAPacket {
+	challenge: string;
+	code: string;
+}
+
+export interface AuthLoginPacket {
+	username: string;
+	password: string;
+	code?: string;
+}
+
+export interface AuthLoginResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	userDoesNotExist: boolean;
+	wrongPassword: boolean;
+	requires2FA: boolean;
+	requires2FASetup: boolean;
+	challenge?: string;
+	token?: string;
+	refreshToken?: string;
+	expiresAt?: number;
+}
+
+export interface AuthLogoutPacket {
+	refreshToken?: string;
+	allDevices: boolean;
+}
+
+export interface AuthLogoutResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	revokedSessions: number;
+}
+
+export interface AuthRefreshPacket {
+	refreshToken: string;
+}
+
+export interface AuthRefreshResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	token?: string;
+	refreshToken?: string;
+	expiresAt?: number;
+}
+
+export interface AuthRegisterPacket {
+	username: string;
+	displayName: string;
+	password: string;
+}
+
+export interface AuthRegisterResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	userAlreadyExists: boolean;
+	token?: string;
+	refreshToken?: string;
+	expiresAt?: number;
+}
+
+export interface AuthResetPasswordPacket {
+	username: string;
+	code: string;
+	newPassword: string;
+}
+
+export interface AuthResetPasswordResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+}
+
+export interface AuthRevokedPacket {
+	sessionID: string;
+}
+
+export interface BetModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	RoundID: string;
+	UserID: number;
+	WalletID: number;
+	GameInstance: string;
+	AmountCents: number;
+	PayoutCents: number;
+	Status: string;
+	SettledAt: string | null;
+}
+
+export interface DatabaseOperationPacket {
+	operation: string;
+	table: string;
+	op_id: unknown;
+	op_data: unknown;
+}
+
+export interface DatabaseOperationResponsePacket {
+	op: DatabaseOperationPacket;
+	result: unknown;
+	err: ErrorInfo | null;
+	exec_time_us: number;
+}
+
+export interface DatabaseSubUpdatePacket {
+	tableID: string;
+	resourceID: unknown;
+	op: string;
+	data: unknown;
+}
+
+export interface DatabaseSubscribePacket {
+	operation: string;
+	tableID: string;
+	resourceID: number;
+}
+
+export interface DatabaseSubscribeResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	operation: string;
+	tableID: string;
+	resourceID: number;
+}
+
+export interface DoesUserExistPacket {
+	username: string;
+}
+
+export interface DoesUserExistResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	userExists: boolean;
+}
+
+export type ErrorCode = string;
+
+export interface ErrorInfo {
+	code: ErrorCode;
+	message: string;
+}
+
+export interface GameClosePacket {
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameCloseResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameClosedPacket {
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameCreatePacket {
+	providerID: string;
+	config: GameInstanceConfig;
+}
+
+export interface GameCreateResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameFinishedLoadingPacket {
+	sessionID: number;
+}
+
+export interface GameInstanceConfig {
+	id: string;
+	settings: Record<string, unknown> | null;
+}
+
+export interface GameInstanceInfo {
+	id: string;
+	users: number;
+	clients: number;
+}
+
+export interface GameInstancePacket {
+	providerID: string;
+	instanceID: string;
+	packet: GamePacket;
+}
+
+export interface GameJoinPacket {
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameJoinResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameLeavePacket {
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameLeaveResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	providerID: string;
+	instanceID: string;
+}
+
+export interface GameListPacket {
+	providerID: string;
+}
+
+export interface GameListResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	providers: GameProviderInfo[] | null;
+}
+
+export interface GamePacket {
+	type: string;
+	payload: unknown;
+	nonce?: number;
+}
+
+export interface GameProviderInfo {
+	id: string;
+	name: string;
+	instances: GameInstanceInfo[] | null;
+}
+
+export interface HelloPacket {
+	version: number;
+	clientType: string;
+	capabilities: string[] | null;
+}
+
+export interface HelloResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	version: number;
+	minVersion: number;
+	clientID: string;
+	capabilities: string[] | null;
+	packets: string[] | null;
+	encoding: string;
+	serverTime: number;
+}
+
+export interface PasswordResetModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	UserID: number;
+	CreatedBy: number;
+	ExpiresAt: string;
+	UsedAt: string | null;
+}
+
+export interface PingPacket {}
+
+export interface RateLimitedResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	retryAfterMs: number;
+}
+
+export interface RecoveryCodeModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	UserID: number;
+	UsedAt: string | null;
+}
+
+export interface ResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+}
+
+export interface RoleModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	Name: string;
+	DisplayName: string;
+	Permissions: string[] | null;
+}
+
+export interface SafeUserModel {
+	ID: number;
+	Username: string;
+	DisplayName: string;
+	JoinedAt: string;
+	IsAdmin: boolean;
+	Roles: string[] | null;
+	Wallet: WalletModel;
+}
+
+export interface ServerShutdownPacket {
+	reason: string;
+	closesAt?: number;
+}
+
+export interface SessionModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	SessionID: string;
+	UserID: number;
+	Addr: string;
+	LastUsedAt: string;
+	ExpiresAt: string;
+	RevokedAt: string | null;
+}
+
+export interface SetSessionPacket {
+	sessionID: number;
+}
+
+export interface SigningKeyModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	KeyID: string;
+	Algorithm: string;
+	PublicKey: string;
+	RetiredAt: string | null;
+	ExpiresAt: string | null;
+}
+
+export interface TransactionModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	TransferID: string;
+	Account: string;
+	WalletID: number;
+	AmountCents: number;
+	BalanceCents: number;
+	Reason: string;
+	Counterparty: string;
+	GameInstance: string;
+	Reference: string;
+}
+
+export interface TwoFactorDisablePacket {
+	password: string;
+	code: string;
+}
+
+export interface TwoFactorDisableResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+}
+
+export interface TwoFactorEnablePacket {
+	challenge?: string;
+	code: string;
+}
+
+export interface TwoFactorEnableResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	recoveryCodes?: string[];
+	token?: string;
+	refreshToken?: string;
+	expiresAt?: number;
+}
+
+export interface TwoFactorModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	UserID: number;
+	Enabled: boolean;
+	EnabledAt: string | null;
+}
+
+export interface TwoFactorRecoveryCodesPacket {
+	code: string;
+}
+
+export interface TwoFactorRecoveryCodesResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	recoveryCodes?: string[];
+}
+
+export interface TwoFactorSetupPacket {
+	challenge?: string;
+}
+
+export interface TwoFactorSetupResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	secret?: string;
+	uri?: string;
+}
+
+export interface UserRoleModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	UserID: number;
+	RoleID: number;
+	Role: RoleModel;
+}
+
+export interface WalletAdjustPacket {
+	walletID: number;
+	amountCents: number;
+	reason: string;
+}
+
+export interface WalletAdjustResponsePacket {
+	success: boolean;
+	status: string;
+	code?: ErrorCode;
+	message: string;
+	walletID: number;
+	balanceCents: number;
+}
+
+export interface WalletModel {
+	ID: number;
+	CreatedAt: string;
+	UpdatedAt: string;
+	DeletedAt: string | null;
+	UserID: number;
+	ReceivedStartingBonus: boolean;
+	NetworthCents: number;
+}
+
+/** Packets clients send, with the payload of their response */
+export interface ClientPackets {
+	/** Requires authentication */
+	"auth/2fa/disable": { request: TwoFactorDisablePacket; response: TwoFactorDisableResponsePacket; responseType: "auth/2fa/disable:res" };
+	"auth/2fa/enable": { request: TwoFactorEnablePacket; response: TwoFactorEnableResponsePacket; responseType: "auth/2fa/enable:res" };
+	/** Requires authentication */
+	"auth/2fa/recovery_codes": { request: TwoFactorRecoveryCodesPacket; response: TwoFactorRecoveryCodesResponsePacket; responseType: "auth/2fa/recovery_codes:res" };
+	"auth/2fa/setup": { request: TwoFactorSetupPacket; response: TwoFactorSetupResponsePacket; responseType: "auth/2fa/setup:res" };
+	"auth/authenticate": { request: AuthAuthenticatePacket; response: AuthAuthenticateResponsePacket; responseType: "auth/authenticate:res" };
+	/** Requires authentication */
+	"auth/change_password": { request: AuthChangePasswordPacket; response: AuthChangePasswordResponsePacket; responseType: "auth/change_password:res" };
+	/** Requires the 'users.reset' permission */
+	"auth/create_reset_code": { request: AuthCreateResetCodePacket; response: AuthCreateResetCodeResponsePacket; responseType: "auth/create_reset_code:res" };
+	"auth/does_user_exist": { request: DoesUserExistPacket; response: DoesUserExistResponsePacket; responseType: "auth/does_user_exist:res" };
+	"auth/login": { request: AuthLoginPacket; response: AuthLoginResponsePacket; responseType: "auth/login:res" };
+	"auth/login_2fa": { request: AuthLogin2FAPacket; response: AuthLoginResponsePacket; responseType: "auth/login_2fa:res" };
+	"auth/logout": { request: AuthLogoutPacket; response: AuthLogoutResponsePacket; responseType: "auth/logout:res" };
+	"auth/refresh": { request: AuthRefreshPacket; response: AuthRefreshResponsePacket; responseType: "auth/refresh:res" };
+	"auth/register": { request: AuthRegisterPacket; response: AuthRegisterResponsePacket; responseType: "auth/register:res" };
+	"auth/reset_password": { request: AuthResetPasswordPacket; response: AuthResetPasswordResponsePacket; responseType: "auth/reset_password:res" };
+	"client/set_session": { request: SetSessionPacket; response: ResponsePacket; responseType: "client/set_session:res" };
+	/** Requires authentication */
+	"db/op": { request: DatabaseOperationPacket; response: DatabaseOperationResponsePacket; responseType: "db/op:res" };
+	/** Requires authentication */
+	"db/sub": { request: DatabaseSubscribePacket; response: DatabaseSubscribeResponsePacket; responseType: "db/sub:res" };
+	/** Requires the 'games.manage' permission */
+	"game/close": { request: GameClosePacket; response: GameCloseResponsePacket; responseType: "game/close:res" };
+	/** Requires the 'games.manage' permission */
+	"game/create": { request: GameCreatePacket; response: GameCreateResponsePacket; responseType: "game/create:res" };
+	"game/finished_loading": { request: GameFinishedLoadingPacket; response: ResponsePacket; responseType: "game/finished_loading:res" };
+	/** Requires authentication */
+	"game/join": { request: GameJoinPacket; response: GameJoinResponsePacket; responseType: "game/join:res" };
+	/** Requires authentication */
+	"game/leave": { request: GameLeavePacket; response: GameLeaveResponsePacket; responseType: "game/leave:res" };
+	/** Requires authentication */
+	"game/list": { request: GameListPacket; response: GameListResponsePacket; responseType: "game/list:res" };
+	/** Requires authentication */
+	"game/packet": { request: GameInstancePacket; response: ResponsePacket; responseType: "game/packet:res" };
+	"hello": { request: HelloPacket; response: HelloResponsePacket; responseType: "hello:res" };
+	"ping": { request: PingPacket; response: PingPacket; responseType: "pong" };
+	/** Requires the 'wallets.adjust' permission */
+	"wallet/adjust": { request: WalletAdjustPacket; response: WalletAdjustResponsePacket; responseType: "wallet/adjust:res" };
+}
+
+export type ClientPacketType = keyof ClientPackets;
+
+/** Packets the server sends on its own */
+export interface ServerPackets {
+	"auth/revoked": AuthRevokedPacket;
+	"db/sub:update": DatabaseSubUpdatePacket;
+	"game/closed": GameClosedPacket;
+	"game/finished_loading": GameFinishedLoadingPacket;
+	"game/packet": GameInstancePacket;
+	"game/request": GameInstancePacket;
+	"server/shutdown": ServerShutdownPacket;
+}
+
+export type ServerPacketType = keyof ServerPackets;
+
+/** Records of the tables, as returned by db/op */
+export interface Tables {
+	bets: BetModel;
+	password_resets: PasswordResetModel;
+	recovery_codes: RecoveryCodeModel;
+	roles: RoleModel;
+	sessions: SessionModel;
+	signing_keys: SigningKeyModel;
+	transactions: TransactionModel;
+	two_factor: TwoFactorModel;
+	user_roles: UserRoleModel;
+	users: SafeUserModel;
+	wallets: WalletModel;
+}
+
+export type TableID = keyof Tables;
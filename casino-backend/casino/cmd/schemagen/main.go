@@ -0,0 +1,211 @@
+// Command schemagen writes a JSON Schema and TypeScript definitions of all packets
+// and table records, so clients can use the same types as the server.
+//
+//	go run ./cmd/schemagen -out ../../casino-app/src/lib/protocol
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data"
+	"jhgambling/backend/core/schema"
+	"jhgambling/backend/core/server"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const generatedBy = "Code generated by cmd/schemagen. DO NOT EDIT."
+
+// packetDescription is written to the schema for every packet clients can send
+type packetDescription struct {
+	Request      *schema.Schema `json:"request"`
+	Response     *schema.Schema `json:"response"`
+	ResponseType string         `json:"responseType"`
+	RequireAuth  bool           `json:"requireAuth,omitempty"`
+	Permission   string         `json:"permission,omitempty"`
+}
+
+type document struct {
+	Schema        string                       `json:"$schema"`
+	Title         string                       `json:"title"`
+	Description   string                       `json:"description"`
+	Version       int                          `json:"x-protocolVersion"`
+	Packets       map[string]packetDescription `json:"x-packets"`
+	ServerPackets map[string]*schema.Schema    `json:"x-serverPackets"`
+	Tables        map[string]*schema.Schema    `json:"x-tables"`
+	Definitions   map[string]*schema.Schema    `json:"$defs"`
+
+	generator *schema.Generator
+}
+
+func main() {
+	out := flag.String("out", "../../casino-app/src/lib/protocol", "directory the schema and the TypeScript definitions are written to")
+	flag.Parse()
+
+	doc := describe()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		utils.Log("fatal", "casino::schema", "failed to create the output directory: ", err)
+		os.Exit(1)
+	}
+
+	schemaJSON, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		utils.Log("fatal", "casino::schema", "failed to encode the schema: ", err)
+		os.Exit(1)
+	}
+	files := map[string][]byte{
+		"schema.json": append(schemaJSON, '\n'),
+		"index.ts":    []byte(typeScript(doc)),
+	}
+	for name, content := range files {
+		path := filepath.Join(*out, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			utils.Log("fatal", "casino::schema", "failed to write ", path, ": ", err)
+			os.Exit(1)
+		}
+		utils.Log("ok", "casino::schema", "wrote ", path)
+	}
+}
+
+// describe collects the packets of the gateway and the records of the default tables
+func describe() *document {
+	g := schema.NewGenerator()
+	doc := &document{
+		Schema:        "https://json-schema.org/draft/2020-12/schema",
+		Title:         "JHGambling protocol",
+		Description:   generatedBy,
+		Version:       server.ProtocolVersion,
+		Packets:       map[string]packetDescription{},
+		ServerPackets: map[string]*schema.Schema{},
+		Tables:        map[string]*schema.Schema{},
+	}
+
+	// Every response can be a plain failure, and any packet can be rate limited
+	g.Schema(server.ResponsePacket{})
+	g.Schema(server.RateLimitedResponsePacket{})
+
+	packets := server.NewPacketRegistry()
+	server.RegisterDefaultPackets(packets)
+	for _, def := range packets.Definitions() {
+		response := def.Response
+		if response == nil {
+			response = server.ResponsePacket{}
+		}
+		responseType := def.Type + ":res"
+		if def.Type == "ping" {
+			responseType = "pong"
+		}
+
+		doc.Packets[def.Type] = packetDescription{
+			Request:      g.SchemaOf(reflect.TypeOf(def.New()).Elem()),
+			Response:     g.Schema(response),
+			ResponseType: responseType,
+			RequireAuth:  def.RequireAuth || def.Permission != "",
+			Permission:   def.Permission,
+		}
+	}
+
+	for _, packet := range server.ServerPackets {
+		doc.ServerPackets[packet.Type] = g.Schema(packet.Payload)
+	}
+
+	db := data.NewDatabase(config.Default("development"))
+	db.RegisterDefaultTables()
+	for _, table := range db.Tables() {
+		doc.Tables[table.GetID()] = tableRecord(g, table)
+	}
+
+	doc.Definitions = g.Definitions
+	doc.generator = g
+	return doc
+}
+
+// tableRecord describes the records AsUser operations return. Fields nobody but the
+// server may read are left out, fields only some users may read are optional.
+func tableRecord(g *schema.Generator, table protocol.Table) *schema.Schema {
+	if view, ok := table.(protocol.ViewTable); ok {
+		return g.SchemaOf(indirect(reflect.TypeOf(view.GetViewType())))
+	}
+
+	modelType := indirect(reflect.TypeOf(table.GetModelType()))
+	ref := g.SchemaOf(modelType)
+	fields := table.GetPolicy().Fields
+	if len(fields) == 0 {
+		return ref
+	}
+
+	model := g.Definitions[schema.DefinitionName(ref.Ref)]
+	hidden, optional := []string{}, []string{}
+	for _, name := range model.PropertyNames() {
+		if !fields.CanRead(name, protocol.AccessAdmin) {
+			hidden = append(hidden, name)
+		} else if !fields.CanRead(name, protocol.AccessPublic) {
+			optional = append(optional, name)
+		}
+	}
+	if len(hidden) == 0 && len(optional) == 0 {
+		return ref
+	}
+	return g.Define("Safe"+modelType.Name(), model.Without(hidden...).Optional(optional...))
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// typeScript writes the definitions, plus maps from packet types and table IDs to their types
+func typeScript(doc *document) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s\n\n", generatedBy)
+	fmt.Fprintf(&b, "export const PROTOCOL_VERSION = %d;\n\n", doc.Version)
+
+	b.WriteString("export interface Packet<T = unknown> {\n\ttype: string;\n\tpayload: T;\n\tnonce?: number;\n}\n\n")
+
+	b.WriteString(doc.generator.TypeScript())
+
+	b.WriteString("/** Packets clients send, with the payload of their response */\n")
+	b.WriteString("export interface ClientPackets {\n")
+	for _, packetType := range slices.Sorted(maps.Keys(doc.Packets)) {
+		packet := doc.Packets[packetType]
+		switch {
+		case packet.Permission != "":
+			fmt.Fprintf(&b, "\t/** Requires the '%s' permission */\n", packet.Permission)
+		case packet.RequireAuth:
+			b.WriteString("\t/** Requires authentication */\n")
+		}
+		fmt.Fprintf(&b, "\t%q: { request: %s; response: %s; responseType: %q };\n",
+			packetType, schema.TSType(packet.Request), schema.TSType(packet.Response), packet.ResponseType)
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("export type ClientPacketType = keyof ClientPackets;\n\n")
+
+	b.WriteString("/** Packets the server sends on its own */\n")
+	b.WriteString("export interface ServerPackets {\n")
+	for _, packetType := range slices.Sorted(maps.Keys(doc.ServerPackets)) {
+		fmt.Fprintf(&b, "\t%q: %s;\n", packetType, schema.TSType(doc.ServerPackets[packetType]))
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("export type ServerPacketType = keyof ServerPackets;\n\n")
+
+	b.WriteString("/** Records of the tables, as returned by db/op */\n")
+	b.WriteString("export interface Tables {\n")
+	for _, tableID := range slices.Sorted(maps.Keys(doc.Tables)) {
+		fmt.Fprintf(&b, "\t%s: %s;\n", tableID, schema.TSType(doc.Tables[tableID]))
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("export type TableID = keyof Tables;\n")
+
+	return b.String()
+}
@@ -1,6 +1,10 @@
 package core
 
-import "jhgambling/protocol"
+import (
+	"errors"
+	"jhgambling/protocol"
+	"time"
+)
 
 type CasinoPluginAdapter struct {
 	core *CasinoCore
@@ -15,3 +19,48 @@ func NewCasinoPluginAdapter(core *CasinoCore) *CasinoPluginAdapter {
 func (a *CasinoPluginAdapter) Table(id string) (protocol.Table, error) {
 	return a.core.Database.GetTable(id)
 }
+
+// Wallets returns the service a game instance uses to place and settle bets
+func (a *CasinoPluginAdapter) Wallets(source protocol.GameInstance) protocol.WalletService {
+	return NewCasinoWalletService(a.core, source.GetProviderID()+"/"+source.GetID())
+}
+
+// SendToClient sends a game packet to a single client of the game instance
+func (a *CasinoPluginAdapter) SendToClient(source protocol.GameInstance, client protocol.GameClient, packet protocol.GamePacket) error {
+	c := a.core.Gateway.GetClient(client.ID)
+	if c == nil {
+		return errors.New("client not found")
+	}
+
+	return c.SendGamePacket(source.GetProviderID(), source.GetID(), packet)
+}
+
+// RequestFromClient sends a game packet to a client and waits for its answer
+func (a *CasinoPluginAdapter) RequestFromClient(source protocol.GameInstance, client protocol.GameClient, packet protocol.GamePacket, timeout time.Duration) (protocol.GamePacket, error) {
+	c := a.core.Gateway.GetClient(client.ID)
+	if c == nil {
+		return protocol.GamePacket{}, errors.New("client not found")
+	}
+
+	return c.RequestGamePacket(source.GetProviderID(), source.GetID(), packet, timeout)
+}
+
+// BroadcastToInstance sends a game packet to all clients that joined the game instance
+func (a *CasinoPluginAdapter) BroadcastToInstance(source protocol.GameInstance, packet protocol.GamePacket) error {
+	for _, c := range a.core.Gateway.GetClientsInGame(source.GetProviderID(), source.GetID()) {
+		if err := c.SendGamePacket(source.GetProviderID(), source.GetID(), packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendToUser sends a game packet to every connected client of a user
+func (a *CasinoPluginAdapter) SendToUser(source protocol.GameInstance, userID uint, packet protocol.GamePacket) error {
+	for _, c := range a.core.Gateway.GetClientsOfUser(userID) {
+		if err := c.SendGamePacket(source.GetProviderID(), source.GetID(), packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,76 +1,310 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data"
+	"jhgambling/backend/core/data/tables"
 	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidToken        = protocol.NewError(protocol.ErrorAuthInvalidToken, "invalid token")
+	ErrInvalidRefreshToken = protocol.NewError(protocol.ErrorAuthInvalidRefreshToken, "invalid refresh token")
+	ErrNotConnected        = errors.New("auth manager is not connected to the database yet")
 )
 
 type AuthManager struct {
-	secretKey []byte
+	secret               []byte
+	keys                 *Keyring
+	hasher               *PasswordHasher
+	tokenLifetime        time.Duration
+	refreshTokenLifetime time.Duration
+
+	passwordPolicy    config.PasswordPolicyConfig
+	resetCodeLifetime time.Duration
+	twoFactorConfig   config.TwoFactorConfig
+
+	sessions      *tables.SessionTable
+	resets        *tables.PasswordResetTable
+	twoFactor     *tables.TwoFactorTable
+	recoveryCodes *tables.RecoveryCodeTable
+}
+
+// TokenPair is handed to a client when a session is started or refreshed
+type TokenPair struct {
+	UserID       uint
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time // Expiry of the access token
+}
+
+// AccessClaims are the verified contents of an access token
+type AccessClaims struct {
+	UserID    uint
+	SessionID string
+	ExpiresAt time.Time
 }
 
-func NewAuthManager() *AuthManager {
+func NewAuthManager(cfg config.AuthConfig) *AuthManager {
 	return &AuthManager{
-		secretKey: []byte("jhgambling-key-1"),
+		secret:               []byte(cfg.Secret),
+		keys:                 NewKeyring(cfg),
+		hasher:               NewPasswordHasher(cfg.Hashing),
+		tokenLifetime:        cfg.TokenLifetime.Duration,
+		refreshTokenLifetime: cfg.RefreshTokenLifetime.Duration,
+		passwordPolicy:       cfg.PasswordPolicy,
+		resetCodeLifetime:    cfg.ResetCodeLifetime.Duration,
+		twoFactorConfig:      cfg.TwoFactor,
 	}
 }
 
-func (auth *AuthManager) CreateTokenForUser(userID uint) (string, error) {
-	claims := jwt.MapClaims{
-		"subjectID": userID,
-		"exp":       time.Now().Add(time.Hour * 96).Unix(),
+// Connect loads the signing keys and sessions from the database, this has to happen before any token is issued
+func (auth *AuthManager) Connect(db *data.Database) error {
+	if err := auth.keys.Load(db.GetSigningKeyTable()); err != nil {
+		return err
+	}
+	auth.keys.StartRotation()
+
+	auth.sessions = db.GetSessionTable()
+	auth.resets = db.GetPasswordResetTable()
+	auth.twoFactor = db.GetTwoFactorTable()
+	auth.recoveryCodes = db.GetRecoveryCodeTable()
+	return nil
+}
+
+// Close stops the key rotation and the password hashing workers, after the queued
+// logins are done. It has to happen before the database is closed.
+func (auth *AuthManager) Close() {
+	auth.keys.StopRotation()
+	auth.hasher.Close()
+}
+
+// Keys returns the keyring used to sign and verify access tokens
+func (auth *AuthManager) Keys() *Keyring {
+	return auth.keys
+}
+
+// CreateSession starts a new session for a user and issues the first token pair
+func (auth *AuthManager) CreateSession(userID uint, addr string) (*TokenPair, error) {
+	if auth.sessions == nil {
+		return nil, ErrNotConnected
+	}
+
+	secret, err := generateRefreshSecret()
+	if err != nil {
+		return nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	session, err := auth.sessions.CreateSession(userID, hashToken(secret), addr, time.Now().Add(auth.refreshTokenLifetime))
+	if err != nil {
+		return nil, err
+	}
 
-	return token.SignedString(auth.secretKey)
+	return auth.issueTokenPair(userID, session.SessionID, secret)
 }
 
-func (auth *AuthManager) VerifyToken(tokenString string) (bool, uint, time.Time) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return auth.secretKey, nil
-	})
+// RefreshSession exchanges a refresh token for a new token pair.
+// The refresh token is rotated, so the old one can't be used again.
+func (auth *AuthManager) RefreshSession(refreshToken string) (*TokenPair, error) {
+	if auth.sessions == nil {
+		return nil, ErrNotConnected
+	}
+
+	sessionID, secret, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newSecret, err := generateRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
 
+	session, err := auth.sessions.Rotate(sessionID, hashToken(secret), hashToken(newSecret), time.Now().Add(auth.refreshTokenLifetime))
 	if err != nil {
-		return false, 0, time.UnixMicro(0)
+		return nil, err
 	}
 
-	if !token.Valid {
-		return false, 0, time.UnixMicro(0)
+	return auth.issueTokenPair(session.UserID, session.SessionID, newSecret)
+}
+
+// SessionOf returns the session a refresh token belongs to without using the token up
+func (auth *AuthManager) SessionOf(refreshToken string) (*models.SessionModel, error) {
+	if auth.sessions == nil {
+		return nil, ErrNotConnected
+	}
+
+	sessionID, secret, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := auth.sessions.FindBySessionID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(hashToken(secret))) != 1 {
+		return nil, ErrInvalidRefreshToken
+	}
+	return session, nil
+}
+
+// RevokeSession ends a single session
+func (auth *AuthManager) RevokeSession(sessionID string) error {
+	if auth.sessions == nil {
+		return ErrNotConnected
+	}
+	return auth.sessions.Revoke(sessionID)
+}
+
+// RevokeAllSessions ends every session of a user and returns the IDs of the revoked sessions
+func (auth *AuthManager) RevokeAllSessions(userID uint) ([]string, error) {
+	return auth.RevokeOtherSessions(userID, "")
+}
+
+// RevokeOtherSessions ends every session of a user except the one given
+func (auth *AuthManager) RevokeOtherSessions(userID uint, keep string) ([]string, error) {
+	if auth.sessions == nil {
+		return nil, ErrNotConnected
+	}
+	return auth.sessions.RevokeAllOfUser(userID, keep)
+}
+
+// CreateAccessToken issues a short-lived token for a session
+func (auth *AuthManager) CreateAccessToken(userID uint, sessionID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(auth.tokenLifetime)
+	claims := jwt.MapClaims{
+		"subjectID": userID,
+		"sessionID": sessionID,
+		"typ":       "access",
+		"exp":       expiresAt.Unix(),
+	}
+
+	signed, err := auth.keys.Sign(claims)
+	return signed, expiresAt, err
+}
+
+// VerifyToken checks the signature and expiry of an access token and that its session is still active
+func (auth *AuthManager) VerifyToken(tokenString string) (*AccessClaims, error) {
+	token, err := jwt.Parse(tokenString, auth.keys.Keyfunc, jwt.WithValidMethods(auth.keys.Algorithms()))
+
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		utils.Log("warn", "casino::auth", "could not extract claims")
-		return false, 0, time.UnixMicro(0)
+		return nil, ErrInvalidToken
+	}
+
+	// Challenge tokens are signed with the same keys, but must never work as access tokens
+	if typ, ok := claims["typ"]; ok && typ != "access" {
+		utils.Log("warn", "casino::auth", "token of type '", typ, "' used as access token")
+		return nil, ErrInvalidToken
 	}
 
 	exp, ok := claims["exp"].(float64) // JWT stores exp as a float64
 	if !ok || time.Now().Unix() > int64(exp) {
 		utils.Log("warn", "casino::auth", "expired token")
-		return false, 0, time.UnixMicro(0)
+		return nil, ErrInvalidToken
 	}
 
 	subjectID, ok := claims["subjectID"].(float64) // JWT stores numbers as float64
 	if !ok {
 		utils.Log("warn", "casino::auth", "invalid subjectID")
-		return false, 0, time.UnixMicro(0)
+		return nil, ErrInvalidToken
 	}
 
-	return true, uint(subjectID), time.Unix(int64(exp), 0)
+	// Tokens issued before sessions existed don't carry a session and are rejected
+	sessionID, ok := claims["sessionID"].(string)
+	if !ok || sessionID == "" {
+		utils.Log("warn", "casino::auth", "token without session")
+		return nil, ErrInvalidToken
+	}
+
+	if auth.sessions == nil || !auth.sessions.IsActive(sessionID) {
+		utils.Log("warn", "casino::auth", "token of revoked or expired session ", sessionID)
+		return nil, ErrInvalidToken
+	}
+
+	return &AccessClaims{
+		UserID:    uint(subjectID),
+		SessionID: sessionID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+func (auth *AuthManager) issueTokenPair(userID uint, sessionID string, secret string) (*TokenPair, error) {
+	accessToken, expiresAt, err := auth.CreateAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		UserID:       userID,
+		SessionID:    sessionID,
+		AccessToken:  accessToken,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresAt:    expiresAt,
+	}, nil
 }
 
-// Reference: https://gowebexamples.com/password-hashing/
+// RefreshTokenSessionID returns the session ID a refresh token claims to belong to, without verifying it
+func RefreshTokenSessionID(refreshToken string) string {
+	sessionID, _, _ := parseRefreshToken(refreshToken)
+	return sessionID
+}
 
+// Refresh tokens are "<sessionID>.<secret>", only a hash of the secret is stored
+func parseRefreshToken(refreshToken string) (string, string, bool) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", false
+	}
+	return sessionID, secret, true
+}
+
+func generateRefreshSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken hashes refresh secrets and reset codes for storage, both are random enough to not need a salt
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPassword hashes a password on the hashing workers, it fails with ErrHasherBusy if too many are queued
 func (auth *AuthManager) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return auth.hasher.Hash(password)
+}
+
+// CheckPasswordHash checks a password against a stored hash. If the hash is outdated,
+// a new one is returned which should replace the stored hash.
+func (auth *AuthManager) CheckPasswordHash(password, hash string) (bool, string, error) {
+	return auth.hasher.Verify(password, hash)
 }
 
-func (auth *AuthManager) CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// CheckDummyPassword takes as long as checking a real password, so failed logins
+// for unknown usernames can't be told apart by their response time
+func (auth *AuthManager) CheckDummyPassword(password string) {
+	auth.hasher.VerifyDummy(password)
 }
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// sealWithSecret encrypts data with a key derived from the auth secret.
+// The purpose separates the keys of different kinds of data.
+func sealWithSecret(secret []byte, purpose string, plaintext []byte) ([]byte, error) {
+	gcm, err := secretCipher(secret, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openWithSecret decrypts data sealed with sealWithSecret
+func openWithSecret(secret []byte, purpose string, sealed []byte) ([]byte, error) {
+	gcm, err := secretCipher(secret, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func secretCipher(secret []byte, purpose string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
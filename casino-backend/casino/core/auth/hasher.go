@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"jhgambling/backend/core/config"
+	"jhgambling/protocol"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2SaltLength = 16
+const argon2KeyLength = 32
+
+var (
+	ErrHasherBusy      = protocol.NewError(protocol.ErrorRateLimited, "too many logins at once, try again in a moment")
+	ErrHasherClosed    = protocol.NewError(protocol.ErrorShuttingDown, "the server is shutting down")
+	ErrUnknownHashType = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes and checks passwords on a fixed number of workers.
+// Hashing is slow on purpose, so a burst of logins waits in a bounded queue
+// instead of starting one expensive computation per client.
+type PasswordHasher struct {
+	cfg  config.HashingConfig
+	jobs chan func()
+
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+
+	dummyHash string // Checked against when a user doesn't exist, so the login takes as long
+}
+
+func NewPasswordHasher(cfg config.HashingConfig) *PasswordHasher {
+	hasher := &PasswordHasher{
+		cfg:  cfg,
+		jobs: make(chan func(), cfg.QueueSize),
+	}
+
+	// Hashed once at startup, instead of on the first login of an unknown user
+	hasher.dummyHash, _ = hasher.hash("dummy-password")
+
+	for i := 0; i < max(cfg.Workers, 1); i++ {
+		hasher.workers.Add(1)
+		go hasher.work()
+	}
+	return hasher
+}
+
+func (h *PasswordHasher) work() {
+	defer h.workers.Done()
+	for job := range h.jobs {
+		job()
+	}
+}
+
+// run executes fn on a worker and waits for it, it fails right away if the queue is full
+func (h *PasswordHasher) run(fn func()) error {
+	done := make(chan struct{})
+
+	h.mu.RLock()
+	if h.closed {
+		h.mu.RUnlock()
+		return ErrHasherClosed
+	}
+	select {
+	case h.jobs <- func() { fn(); close(done) }:
+	default:
+		h.mu.RUnlock()
+		return ErrHasherBusy
+	}
+	h.mu.RUnlock()
+
+	<-done
+	return nil
+}
+
+// Close finishes the queued jobs and stops the workers, later calls fail with ErrHasherClosed
+func (h *PasswordHasher) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.jobs)
+	h.mu.Unlock()
+
+	h.workers.Wait()
+}
+
+// Hash hashes a password with the configured algorithm
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	var hash string
+	var err error
+	if runErr := h.run(func() { hash, err = h.hash(password) }); runErr != nil {
+		return "", runErr
+	}
+	return hash, err
+}
+
+// Verify checks a password against a hash. If the password matches but the hash
+// was made with outdated settings, it also returns a new hash to store.
+func (h *PasswordHasher) Verify(password string, hash string) (bool, string, error) {
+	var ok bool
+	var upgraded string
+	var err error
+	runErr := h.run(func() {
+		ok, err = verifyHash(password, hash)
+		if ok && err == nil && h.NeedsRehash(hash) {
+			upgraded, err = h.hash(password)
+		}
+	})
+	if runErr != nil {
+		return false, "", runErr
+	}
+	if !ok {
+		return false, "", err
+	}
+	// A failed upgrade doesn't fail the login, the old hash is still valid
+	if err != nil {
+		upgraded = ""
+	}
+	return true, upgraded, nil
+}
+
+// VerifyDummy takes as long as checking a real password
+func (h *PasswordHasher) VerifyDummy(password string) error {
+	return h.run(func() { verifyHash(password, h.dummyHash) })
+}
+
+// NeedsRehash checks whether a hash was made with another algorithm or weaker settings than the
+// configured ones. Stronger hashes are kept, lowering the settings only affects new passwords.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	switch h.cfg.Algorithm {
+	case "bcrypt":
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.cfg.BcryptCost
+	case "argon2id":
+		params, _, _, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return true
+		}
+		want := h.cfg.Argon2
+		return params.MemoryKiB < want.MemoryKiB || params.Iterations < want.Iterations || params.Parallelism < want.Parallelism
+	}
+	return false
+}
+
+// MaxPasswordBytes returns the longest password the algorithm can hash, zero if there is no limit
+func (h *PasswordHasher) MaxPasswordBytes() int {
+	if h.cfg.Algorithm == "bcrypt" {
+		return 72 // bcrypt rejects longer passwords
+	}
+	return 0
+}
+
+func (h *PasswordHasher) hash(password string) (string, error) {
+	switch h.cfg.Algorithm {
+	case "bcrypt":
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		return string(bytes), err
+	case "argon2id":
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		return encodeArgon2Hash(h.cfg.Argon2, salt, argon2Key(password, salt, h.cfg.Argon2, argon2KeyLength)), nil
+	}
+	return "", fmt.Errorf("unsupported hashing algorithm '%s'", h.cfg.Algorithm)
+}
+
+// verifyHash checks a password against a hash of any supported algorithm
+func verifyHash(password string, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(key, argon2Key(password, salt, params, uint32(len(key)))) == 1, nil
+	}
+
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	return false, ErrUnknownHashType
+}
+
+func argon2Key(password string, salt []byte, params config.Argon2Config, length uint32) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.MemoryKiB, params.Parallelism, length)
+}
+
+// encodeArgon2Hash writes the hash in the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func encodeArgon2Hash(params config.Argon2Config, salt []byte, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.MemoryKiB, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2Hash(hash string) (config.Argon2Config, []byte, []byte, error) {
+	var params config.Argon2Config
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashType
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHashType
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHashType
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashType
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHashType
+	}
+
+	return params, salt, key, nil
+}
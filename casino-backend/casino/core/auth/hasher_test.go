@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"jhgambling/backend/core/config"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNeedsRehash(t *testing.T) {
+	argon := config.Argon2Config{MemoryKiB: 64, Iterations: 2, Parallelism: 2}
+	argonHash := func(params config.Argon2Config) string {
+		salt := make([]byte, argon2SaltLength)
+		return encodeArgon2Hash(params, salt, argon2Key("password", salt, params, argon2KeyLength))
+	}
+	bcryptHash := func(cost int) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte("password"), cost)
+		if err != nil {
+			t.Fatalf("hashing with cost %d: %v", cost, err)
+		}
+		return string(hash)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		hash      string
+		want      bool
+	}{
+		{"bcrypt with the configured cost", "bcrypt", bcryptHash(5), false},
+		{"bcrypt with a higher cost", "bcrypt", bcryptHash(6), false},
+		{"bcrypt with a lower cost", "bcrypt", bcryptHash(4), true},
+		{"argon2id while bcrypt is configured", "bcrypt", argonHash(argon), true},
+		{"argon2id with the configured parameters", "argon2id", argonHash(argon), false},
+		{"argon2id with more memory", "argon2id", argonHash(config.Argon2Config{MemoryKiB: 128, Iterations: 2, Parallelism: 2}), false},
+		{"argon2id with more iterations", "argon2id", argonHash(config.Argon2Config{MemoryKiB: 64, Iterations: 3, Parallelism: 2}), false},
+		{"argon2id with less memory", "argon2id", argonHash(config.Argon2Config{MemoryKiB: 32, Iterations: 2, Parallelism: 2}), true},
+		{"argon2id with fewer iterations", "argon2id", argonHash(config.Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 2}), true},
+		{"argon2id with fewer threads", "argon2id", argonHash(config.Argon2Config{MemoryKiB: 64, Iterations: 2, Parallelism: 1}), true},
+		{"bcrypt while argon2id is configured", "argon2id", bcryptHash(5), true},
+		{"garbage", "argon2id", "$argon2id$garbage", true},
+	}
+
+	for _, test := range tests {
+		hasher := NewPasswordHasher(config.HashingConfig{
+			Algorithm:  test.algorithm,
+			BcryptCost: 5,
+			Argon2:     argon,
+			Workers:    1,
+		})
+		if got := hasher.NeedsRehash(test.hash); got != test.want {
+			t.Errorf("%s: NeedsRehash() = %v, want %v", test.name, got, test.want)
+		}
+		hasher.Close()
+	}
+}
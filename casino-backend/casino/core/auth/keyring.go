@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data/tables"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol/models"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrKeyringNotLoaded = errors.New("keyring has not been loaded yet")
+	ErrUnknownKey       = errors.New("token was signed with an unknown key")
+)
+
+const (
+	// How often the keyring checks whether the signing key is due for rotation
+	rotationCheckInterval = time.Minute
+	keyEncryptionPurpose  = "jwt-key-encryption"
+)
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	createdAt time.Time
+	expiresAt *time.Time
+}
+
+// Keyring holds the key used to sign new tokens and all keys that still verify older ones.
+// HMAC keys are derived from the auth secret, private keys of asymmetric algorithms are
+// stored encrypted with it, so the database alone is not enough to forge tokens.
+type Keyring struct {
+	mu sync.RWMutex
+
+	secret           []byte
+	algorithm        string
+	rotationInterval time.Duration
+	tokenLifetime    time.Duration // Retired keys have to verify tokens until they expired
+
+	keys    map[string]*signingKey
+	current *signingKey
+	store   *tables.SigningKeyTable
+
+	stop chan struct{}
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is a set of public keys, services that only need to verify tokens can fetch it
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeyring(cfg config.AuthConfig) *Keyring {
+	return &Keyring{
+		secret:           []byte(cfg.Secret),
+		algorithm:        cfg.SigningAlgorithm,
+		rotationInterval: cfg.KeyRotationInterval.Duration,
+		tokenLifetime:    cfg.TokenLifetime.Duration,
+		keys:             map[string]*signingKey{},
+	}
+}
+
+// Load reads all usable keys from the store and creates a signing key if none is current
+func (k *Keyring) Load(store *tables.SigningKeyTable) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.store = store
+	k.keys = map[string]*signingKey{}
+	k.current = nil
+
+	records, err := store.FindUsable()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		key, err := k.decodeKey(record)
+		if err != nil {
+			// Happens when the auth secret changed, tokens signed with this key can't be verified anymore
+			utils.Log("warn", "casino::auth", "[Keyring] skipping key ", record.KeyID, ": ", err)
+			continue
+		}
+
+		k.keys[key.id] = key
+		if record.RetiredAt == nil {
+			k.current = key
+		}
+	}
+
+	if k.current == nil || k.current.method.Alg() != k.algorithm || k.isDue(time.Now()) {
+		if err := k.rotate(); err != nil {
+			return err
+		}
+	}
+
+	utils.Log("ok", "casino::auth", "[Keyring] loaded ", len(k.keys), " key(s), signing with ", k.current.id, " (", k.algorithm, ")")
+	return nil
+}
+
+// Sign signs the claims with the current key and sets the "kid" header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.current
+	k.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrKeyringNotLoaded
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc looks up the verification key of a token by its "kid" header
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	keyID, ok := token.Header["kid"].(string)
+	if !ok || keyID == "" {
+		return nil, ErrUnknownKey
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+
+	if !ok || (key.expiresAt != nil && time.Now().After(*key.expiresAt)) {
+		return nil, ErrUnknownKey
+	}
+
+	// A token can't choose how it is verified, otherwise a public key could be used as an HMAC secret
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("token uses %s but key %s is %s", token.Method.Alg(), keyID, key.method.Alg())
+	}
+
+	return key.verifyKey, nil
+}
+
+// Algorithms returns the algorithms of all keys that currently verify tokens
+func (k *Keyring) Algorithms() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	seen := map[string]bool{}
+	algorithms := []string{}
+	for _, key := range k.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// PublicKeys returns all asymmetric verification keys, HMAC keys are never published
+func (k *Keyring) PublicKeys() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.id,
+				Algorithm: key.method.Alg(),
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.id,
+				Algorithm: key.method.Alg(),
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return jwks
+}
+
+// Rotate replaces the signing key. The old key keeps verifying tokens until they expired.
+func (k *Keyring) Rotate() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.store == nil {
+		return ErrKeyringNotLoaded
+	}
+	return k.rotate()
+}
+
+// StartRotation rotates the signing key in the background whenever it is due
+func (k *Keyring) StartRotation() {
+	if k.rotationInterval == 0 || k.stop != nil {
+		return
+	}
+
+	k.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(rotationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				k.mu.Lock()
+				select {
+				case <-stop:
+					// Stopped while waiting for the lock, the database may be gone already
+					k.mu.Unlock()
+					return
+				default:
+				}
+				if k.isDue(now) {
+					if err := k.rotate(); err != nil {
+						utils.Log("error", "casino::auth", "[Keyring] scheduled rotation failed: ", err)
+					}
+				}
+				k.pruneExpired(now)
+				k.mu.Unlock()
+			}
+		}
+	}(k.stop)
+}
+
+// StopRotation stops the background rotation. A rotation that is already running
+// is finished before it returns.
+func (k *Keyring) StopRotation() {
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+	k.mu.Lock()
+	k.mu.Unlock()
+}
+
+func (k *Keyring) isDue(now time.Time) bool {
+	return k.rotationInterval > 0 && k.current != nil && now.Sub(k.current.createdAt) >= k.rotationInterval
+}
+
+// rotate has to be called with the lock held
+func (k *Keyring) rotate() error {
+	now := time.Now()
+
+	record, key, err := k.generateKey()
+	if err != nil {
+		return err
+	}
+	if err := k.store.AddKey(record); err != nil {
+		return err
+	}
+	key.createdAt = record.CreatedAt
+
+	if previous := k.current; previous != nil {
+		expiresAt := now.Add(k.tokenLifetime)
+		if err := k.store.Retire(previous.id, now, expiresAt); err != nil {
+			return err
+		}
+		previous.expiresAt = &expiresAt
+	}
+
+	k.keys[key.id] = key
+	k.current = key
+
+	utils.Log("info", "casino::auth", "[Keyring] rotated signing key, now signing with ", key.id, " (", k.algorithm, ")")
+	return nil
+}
+
+// pruneExpired has to be called with the lock held
+func (k *Keyring) pruneExpired(now time.Time) {
+	for id, key := range k.keys {
+		if key.expiresAt != nil && now.After(*key.expiresAt) {
+			delete(k.keys, id)
+		}
+	}
+
+	if _, err := k.store.DeleteExpired(); err != nil {
+		utils.Log("warn", "casino::auth", "[Keyring] failed to remove expired keys: ", err)
+	}
+}
+
+func (k *Keyring) generateKey() (*models.SigningKeyModel, *signingKey, error) {
+	record := &models.SigningKeyModel{
+		KeyID:     utils.GenerateID(),
+		Algorithm: k.algorithm,
+	}
+
+	switch k.algorithm {
+	case "HS256":
+		// Nothing to store, the key is derived from the secret
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := k.encodeKeyPair(record, private, private.Public()); err != nil {
+			return nil, nil, err
+		}
+	case "RS256":
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := k.encodeKeyPair(record, private, private.Public()); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported signing algorithm '%s'", k.algorithm)
+	}
+
+	key, err := k.decodeKey(*record)
+	return record, key, err
+}
+
+func (k *Keyring) encodeKeyPair(record *models.SigningKeyModel, private interface{}, public interface{}) error {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealWithSecret(k.secret, keyEncryptionPurpose, privateDER)
+	if err != nil {
+		return err
+	}
+
+	record.PrivateKey = sealed
+	record.PublicKey = publicDER
+	return nil
+}
+
+func (k *Keyring) decodeKey(record models.SigningKeyModel) (*signingKey, error) {
+	key := &signingKey{
+		id:        record.KeyID,
+		method:    jwt.GetSigningMethod(record.Algorithm),
+		createdAt: record.CreatedAt,
+		expiresAt: record.ExpiresAt,
+	}
+	if key.method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm '%s'", record.Algorithm)
+	}
+
+	if record.Algorithm == "HS256" {
+		secret := k.deriveHMACKey(record.KeyID)
+		key.signKey = secret
+		key.verifyKey = secret
+		return key, nil
+	}
+
+	privateDER, err := openWithSecret(k.secret, keyEncryptionPurpose, record.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key, the auth secret may have changed: %w", err)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(record.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key.signKey = private
+	key.verifyKey = public
+	return key, nil
+}
+
+func (k *Keyring) deriveHMACKey(keyID string) []byte {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte("jwt-signing-key:" + keyID))
+	return mac.Sum(nil)
+}
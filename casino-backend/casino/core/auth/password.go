@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"jhgambling/protocol"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reset and recovery codes use an alphabet without look-alike characters, e.g. "7KQM-2XHD-9PWT"
+const (
+	codeAlphabet    = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	resetCodeLength = 12
+	resetCodeGroup  = 4
+)
+
+// ValidatePassword checks a new password against the password policy
+func (auth *AuthManager) ValidatePassword(password string, username string) error {
+	policy := auth.passwordPolicy
+	problems := []string{}
+
+	// The policy counts characters, only the hashing algorithm may limit the bytes
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", policy.MinLength))
+	}
+	if length > policy.MaxLength {
+		problems = append(problems, fmt.Sprintf("be at most %d characters long", policy.MaxLength))
+	} else if limit := auth.hasher.MaxPasswordBytes(); limit > 0 && len(password) > limit {
+		problems = append(problems, fmt.Sprintf("be at most %d bytes long, where characters outside of ASCII count as several", limit))
+	}
+
+	var hasLetter, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireLetter && !hasLetter {
+		problems = append(problems, "contain a letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		problems = append(problems, "contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		problems = append(problems, "contain a symbol")
+	}
+	if policy.DisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problems = append(problems, "leave out the username")
+	}
+
+	if len(problems) > 0 {
+		return protocol.NewError(protocol.ErrorAuthPasswordPolicy, "password has to "+strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// CreateResetCode issues a one-time password reset code for a user, earlier codes become invalid
+func (auth *AuthManager) CreateResetCode(userID uint, createdBy uint) (string, time.Time, error) {
+	if auth.resets == nil {
+		return "", time.Time{}, ErrNotConnected
+	}
+
+	code, err := generateCode(resetCodeLength, resetCodeGroup)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(auth.resetCodeLifetime)
+	if _, err := auth.resets.Issue(userID, createdBy, hashToken(normalizeCode(code)), expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expiresAt, nil
+}
+
+// ResetPassword uses up a reset code and replaces the password hash of the user at once.
+// It fails if the code doesn't belong to the user or has expired.
+func (auth *AuthManager) ResetPassword(userID uint, code string, passwordHash string) error {
+	if auth.resets == nil {
+		return ErrNotConnected
+	}
+	return auth.resets.Redeem(userID, hashToken(normalizeCode(code)), passwordHash)
+}
+
+// generateCode creates a random code that is easy to type, split into groups by dashes
+func generateCode(length int, group int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, b := range buf {
+		if i > 0 && i%group == 0 {
+			code.WriteByte('-')
+		}
+		// 256 is a multiple of the alphabet size, so every character is equally likely
+		code.WriteByte(codeAlphabet[int(b)%len(codeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normalizeCode makes codes match regardless of case and separators
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+}
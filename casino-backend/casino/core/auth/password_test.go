@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"jhgambling/backend/core/config"
+	"strings"
+	"testing"
+)
+
+func TestValidatePasswordLength(t *testing.T) {
+	policy := config.PasswordPolicyConfig{MinLength: 8, MaxLength: 20}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		password  string
+		want      string // Part of the error, empty if the password is valid
+	}{
+		{"long enough", "bcrypt", "abcdefgh", ""},
+		{"too short", "bcrypt", "abcdefg", "at least 8 characters"},
+		{"accents count as one character", "bcrypt", "äöüäöüäö", ""},
+		{"emoji count as one character", "bcrypt", "🎲🎲🎲🎲🎲🎲🎲", "at least 8 characters"},
+		{"at most", "bcrypt", strings.Repeat("a", 20), ""},
+		{"too long", "bcrypt", strings.Repeat("a", 21), "at most 20 characters"},
+		{"too many bytes for bcrypt", "bcrypt", strings.Repeat("🎲", 19), "at most 72 bytes"},
+		{"argon2id has no byte limit", "argon2id", strings.Repeat("🎲", 19), ""},
+	}
+
+	for _, test := range tests {
+		hasher := NewPasswordHasher(config.HashingConfig{
+			Algorithm:  test.algorithm,
+			BcryptCost: 4,
+			Argon2:     config.Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 1},
+			Workers:    1,
+		})
+		auth := &AuthManager{passwordPolicy: policy, hasher: hasher}
+
+		err := auth.ValidatePassword(test.password, "")
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+		hasher.Close()
+	}
+}
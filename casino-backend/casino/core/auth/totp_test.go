@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// Test vectors of RFC 6238 appendix B for SHA-1, with the last 6 of their 8 digits
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		if got := totpCode(secret, totpStep(time.Unix(test.unix, 0))); got != test.want {
+			t.Errorf("totpCode() at %d = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", totpCode(secret, current), current, true},
+		{"previous step", totpCode(secret, current-1), current - 1, true},
+		{"next step", totpCode(secret, current+1), current + 1, true},
+		{"two steps ago", totpCode(secret, current-2), 0, false},
+		{"two steps ahead", totpCode(secret, current+2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", totpCode(secret, current)[:5], 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, test := range tests {
+		step, ok := verifyTOTP(secret, test.code, now)
+		if ok != test.wantOK || step != test.wantStep {
+			t.Errorf("%s: verifyTOTP() = %d, %v, want %d, %v", test.name, step, ok, test.wantStep, test.wantOK)
+		}
+	}
+}
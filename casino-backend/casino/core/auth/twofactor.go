@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"jhgambling/backend/core/data/tables"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Purposes of challenge tokens, a challenge can only be used for the step it was issued for
+const (
+	ChallengeLogin  = "2fa_login"  // Password was correct, the second factor is still missing
+	ChallengeEnroll = "2fa_enroll" // Password was correct, but 2FA has to be set up before logging in
+)
+
+const (
+	challengeLifetime     = time.Minute * 5
+	recoveryCodeCount     = 10
+	recoveryCodeLength    = 10
+	recoveryCodeGroup     = 5
+	totpEncryptionPurpose = "totp-secret-encryption"
+)
+
+var (
+	ErrInvalidChallenge    = protocol.NewError(protocol.ErrorAuth2FAInvalidChallenge, "invalid or expired challenge")
+	ErrInvalidSecondFactor = protocol.NewError(protocol.ErrorAuth2FAInvalidCode, "invalid two-factor code")
+)
+
+// TwoFactorSetup is shown to the user once, so they can add the secret to their authenticator
+type TwoFactorSetup struct {
+	Secret string // Base32, for manual entry
+	URI    string // otpauth:// URI, for QR codes
+}
+
+// TwoFactorEnabled checks whether the user has to provide a second factor on login
+func (auth *AuthManager) TwoFactorEnabled(userID uint) bool {
+	return auth.twoFactor != nil && auth.twoFactor.IsEnabled(userID)
+}
+
+// TwoFactorRequired checks whether the roles of the user make 2FA mandatory
+func (auth *AuthManager) TwoFactorRequired(user *models.UserModel) bool {
+	for _, role := range auth.twoFactorConfig.RequiredRoles {
+		if user.HasRole(role) || (role == "admin" && user.IsAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginTwoFactorSetup creates a new secret for the user, it is only used once confirmed with EnableTwoFactor
+func (auth *AuthManager) BeginTwoFactorSetup(user *models.UserModel) (*TwoFactorSetup, error) {
+	if auth.twoFactor == nil {
+		return nil, ErrNotConnected
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWithSecret(auth.secret, totpEncryptionPurpose, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.twoFactor.SavePending(user.ID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(auth.twoFactorConfig.Issuer, user.Username, secret),
+	}, nil
+}
+
+// EnableTwoFactor confirms the pending secret with a code from the authenticator and returns fresh recovery codes
+func (auth *AuthManager) EnableTwoFactor(userID uint, code string) ([]string, error) {
+	if auth.twoFactor == nil {
+		return nil, ErrNotConnected
+	}
+
+	entry, err := auth.twoFactor.FindByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Enabled {
+		return nil, tables.ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := openWithSecret(auth.secret, totpEncryptionPurpose, entry.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := verifyTOTP(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidSecondFactor
+	}
+
+	if err := auth.twoFactor.Enable(userID, step); err != nil {
+		return nil, err
+	}
+	return auth.RegenerateRecoveryCodes(userID)
+}
+
+// DisableTwoFactor removes the secret and recovery codes of a user
+func (auth *AuthManager) DisableTwoFactor(userID uint) error {
+	if auth.twoFactor == nil {
+		return ErrNotConnected
+	}
+	return auth.twoFactor.Disable(userID)
+}
+
+// VerifySecondFactor checks a TOTP code or uses up a recovery code
+func (auth *AuthManager) VerifySecondFactor(userID uint, code string) error {
+	if auth.twoFactor == nil {
+		return ErrNotConnected
+	}
+
+	entry, err := auth.twoFactor.FindByUser(userID)
+	if err != nil || !entry.Enabled {
+		return ErrInvalidSecondFactor
+	}
+
+	code = normalizeCode(code)
+	if len(code) != totpDigits {
+		if err := auth.recoveryCodes.Consume(userID, hashToken(code)); err != nil {
+			return ErrInvalidSecondFactor
+		}
+		return nil
+	}
+
+	secret, err := openWithSecret(auth.secret, totpEncryptionPurpose, entry.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := verifyTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidSecondFactor
+	}
+
+	// Each code works only once, even within its time step
+	if err := auth.twoFactor.UseStep(userID, step); err != nil {
+		return ErrInvalidSecondFactor
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, the plain codes are only returned here
+func (auth *AuthManager) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	if auth.recoveryCodes == nil {
+		return nil, ErrNotConnected
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateCode(recoveryCodeLength, recoveryCodeGroup)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeCode(code))
+	}
+
+	if err := auth.recoveryCodes.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CreateChallenge issues a short-lived token that lets the user continue a login that needs another step
+func (auth *AuthManager) CreateChallenge(userID uint, purpose string) (string, error) {
+	return auth.keys.Sign(jwt.MapClaims{
+		"subjectID": userID,
+		"typ":       purpose,
+		"exp":       time.Now().Add(challengeLifetime).Unix(),
+	})
+}
+
+// VerifyChallenge checks a challenge token and returns the user it was issued for
+func (auth *AuthManager) VerifyChallenge(tokenString string, purpose string) (uint, error) {
+	token, err := jwt.Parse(tokenString, auth.keys.Keyfunc,
+		jwt.WithValidMethods(auth.keys.Algorithms()),
+		jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidChallenge
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != purpose {
+		return 0, ErrInvalidChallenge
+	}
+
+	subjectID, ok := claims["subjectID"].(float64)
+	if !ok {
+		return 0, ErrInvalidChallenge
+	}
+	return uint(subjectID), nil
+}
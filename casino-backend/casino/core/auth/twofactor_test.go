@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data"
+	"jhgambling/protocol/models"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySecondFactorReplay(t *testing.T) {
+	cfg := config.Default("development")
+	cfg.Auth.Hashing.BcryptCost = 4
+
+	db := data.NewDatabase(cfg)
+	db.Connect(filepath.Join(t.TempDir(), "casino.db"))
+	db.Migrate()
+	t.Cleanup(func() { db.Close() })
+
+	auth := NewAuthManager(cfg.Auth)
+	if err := auth.Connect(db); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	t.Cleanup(auth.Close)
+
+	user := &models.UserModel{Username: "alice"}
+	if err := db.GetUserTable().Create(user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	setup, err := auth.BeginTwoFactorSetup(user)
+	if err != nil {
+		t.Fatalf("setting up 2FA: %v", err)
+	}
+	secret, err := totpEncoding.DecodeString(setup.Secret)
+	if err != nil {
+		t.Fatalf("decoding the secret: %v", err)
+	}
+
+	// Enabling uses up the code of the current step
+	enabled := totpStep(time.Now())
+	recoveryCodes, err := auth.EnableTwoFactor(user.ID, totpCode(secret, enabled))
+	if err != nil {
+		t.Fatalf("enabling 2FA: %v", err)
+	}
+
+	// In order, every code is checked after the ones before it were used
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{"code used to enable", totpCode(secret, enabled), false},
+		{"code of the next step", totpCode(secret, enabled+1), true},
+		{"same code again", totpCode(secret, enabled+1), false},
+		{"code of an earlier step", totpCode(secret, enabled), false},
+		{"wrong code", "000000", false},
+		{"recovery code", recoveryCodes[0], true},
+		{"same recovery code again", recoveryCodes[0], false},
+		{"another recovery code, lower case without dash", " " + strings.ToLower(normalizeCode(recoveryCodes[1])) + " ", true},
+	}
+
+	for _, test := range tests {
+		err := auth.VerifySecondFactor(user.ID, test.code)
+		if test.wantOK && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.wantOK && !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Errorf("%s: got %v, want %v", test.name, err, ErrInvalidSecondFactor)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR, see RFC 8949
+type cborCodec struct{}
+
+var (
+	cborEncMode cbor.EncMode
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	var err error
+	cborEncMode, err = cbor.EncOptions{
+		Time:                    cbor.TimeRFC3339Nano,            // Zero times become null
+		OmitEmpty:               cbor.OmitEmptyGoValue,           // Like encoding/json, which doesn't omit empty structs
+		ByteSliceLaterFormat:    cbor.ByteSliceLaterFormatBase64, // Tagged to become base64 in JSON, like encoding/json writes them
+		JSONMarshalerTranscoder: transcoderFunc(jsonToCBOR),
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	// Values JSON can't carry are rejected, the packets have to decode the same from every codec
+	cborDecMode, err = cbor.DecOptions{
+		DefaultMapType:            reflect.TypeOf(map[string]interface{}(nil)),
+		TimeTagToAny:              cbor.TimeTagToRFC3339Nano,
+		UnrecognizedTagToAny:      cbor.UnrecognizedTagContentToAny,
+		NaN:                       cbor.NaNDecodeForbidden,
+		Inf:                       cbor.InfDecodeForbidden,
+		JSONUnmarshalerTranscoder: transcoderFunc(cborToJSON),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (cborCodec) Name() string { return "cbor" }
+func (cborCodec) Binary() bool { return true }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := cborDecMode.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid cbor message: %w", err)
+	}
+	return nil
+}
+
+// transcoderFunc lets the CBOR library encode values with their own JSON encoding, like encoding/json does
+type transcoderFunc func(w io.Writer, r io.Reader) error
+
+func (f transcoderFunc) Transcode(w io.Writer, r io.Reader) error {
+	return f(w, r)
+}
+
+func jsonToCBOR(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	value, err := jsonValue(data)
+	if err != nil {
+		return err
+	}
+	return cborEncMode.NewEncoder(w).Encode(value)
+}
+
+func cborToJSON(w io.Writer, r io.Reader) error {
+	var value interface{}
+	if err := cborDecMode.NewDecoder(r).Decode(&value); err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (m RawMessage) MarshalCBOR() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte{0xf6}, nil // null
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalCBOR(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
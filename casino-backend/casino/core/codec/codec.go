@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Codec turns packets into websocket messages and back. Every codec encodes the same
+// structs, field names and omitempty rules are taken from their json tags.
+type Codec interface {
+	Name() string
+	Binary() bool // Messages are sent as binary websocket frames
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = map[string]Codec{
+	"json":    jsonCodec{},
+	"msgpack": msgpackCodec{},
+	"cbor":    cborCodec{},
+}
+
+// JSON is the default codec, used when a client doesn't ask for another one
+var JSON Codec = codecs["json"]
+
+// Get returns the codec with the given name, an empty name selects JSON
+func Get(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+	if c, ok := codecs[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown encoding '%s'", name)
+}
+
+// Names returns the names of all codecs, sorted
+func Names() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToJSON converts a message of the codec to JSON, for values that are handed on as JSON, e.g. to plugins
+func ToJSON(c Codec, data []byte) (json.RawMessage, error) {
+	if !c.Binary() {
+		return json.RawMessage(data), nil
+	}
+	var value interface{}
+	if err := c.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// RawMessage is an encoded part of a message that is decoded later, like the payload of a
+// packet once its type is known. It stays in the encoding of the message it was part of.
+type RawMessage []byte
+
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalJSON(data []byte) error {
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+// jsonValue decodes JSON into the generic values the binary codecs encode. Numbers become
+// integers where they are integers, so they keep their precision.
+func jsonValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return convertNumbers(value), nil
+}
+
+func convertNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = convertNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = convertNumbers(v[key])
+		}
+	}
+	return value
+}
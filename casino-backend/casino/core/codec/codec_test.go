@@ -0,0 +1,330 @@
+package codec_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"jhgambling/backend/core/codec"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data"
+	"jhgambling/backend/core/server"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var binaryCodecs = []string{"msgpack", "cbor"}
+
+// testValues returns every packet, response and table record, once filled and once empty
+func testValues(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	// Received packets keep their payload encoded, TestBuiltPacket decodes them
+	types := map[string]reflect.Type{}
+
+	packets := server.NewPacketRegistry()
+	server.RegisterDefaultPackets(packets)
+	for _, def := range packets.Definitions() {
+		types[def.Type] = reflect.TypeOf(def.New()).Elem()
+		if def.Response != nil {
+			types[def.Type+":res"] = reflect.TypeOf(def.Response)
+		}
+	}
+	for _, packet := range server.ServerPackets {
+		types[packet.Type] = reflect.TypeOf(packet.Payload)
+	}
+
+	db := data.NewDatabase(config.Default("development"))
+	db.RegisterDefaultTables()
+	for _, table := range db.Tables() {
+		types["table "+table.GetID()] = reflect.TypeOf(table.GetModelType()).Elem()
+	}
+
+	values := map[string]interface{}{}
+	for name, typ := range types {
+		filled := reflect.New(typ)
+		fill(filled.Elem(), 0)
+		values[name] = filled.Interface()
+		values[name+" (empty)"] = reflect.New(typ).Interface()
+	}
+	return values
+}
+
+var testTime = time.Date(2026, time.March, 14, 15, 9, 26, 535897000, time.UTC)
+
+// Numbers are taken in turn, so every size of the formats is written by some field
+var (
+	testUints   = []uint64{7, 200, 60000, 4000000000, math.MaxUint64}
+	testInts    = []int64{-7, -100, -30000, -2000000000, math.MinInt64}
+	testNumbers = 0
+)
+
+func nextNumber() int {
+	testNumbers++
+	return testNumbers
+}
+
+// fill sets every field to a value that exercises the wider encodings of the formats
+func fill(v reflect.Value, depth int) {
+	if !v.CanSet() || depth > 6 {
+		return
+	}
+
+	switch v.Type() {
+	case reflect.TypeOf(time.Time{}):
+		v.Set(reflect.ValueOf(testTime))
+		return
+	case reflect.TypeOf(json.RawMessage{}):
+		v.SetBytes([]byte(`{"raw":[1,-2,3.5,"four",null,{"five":true}]}`))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := testInts[nextNumber()%len(testInts)]
+		if v.OverflowInt(i) {
+			i = math.MinInt64 >> (64 - v.Type().Bits())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := testUints[nextNumber()%len(testUints)]
+		if v.OverflowUint(u) {
+			u = math.MaxUint64 >> (64 - v.Type().Bits())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(-1234.5)
+	case reflect.String:
+		v.SetString("héllo ✓ " + strings.Repeat("x", 300))
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(map[string]interface{}{
+				"nested": []interface{}{"a", 1.5, true, nil},
+				"id":     float64(42),
+			}))
+		}
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), depth+1)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), depth+1)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		fill(key, depth+1)
+		value := reflect.New(v.Type().Elem()).Elem()
+		fill(value, depth+1)
+		v.SetMapIndex(key, value)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fill(v.Field(i), depth+1)
+		}
+	}
+}
+
+// TestRoundTrip checks that every binary codec carries the same data as encoding/json
+func TestRoundTrip(t *testing.T) {
+	for name, value := range testValues(t) {
+		want, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("%s: encoding JSON: %v", name, err)
+		}
+		var wantGeneric interface{}
+		if err := json.Unmarshal(want, &wantGeneric); err != nil {
+			t.Fatalf("%s: decoding JSON: %v", name, err)
+		}
+
+		for _, codecName := range binaryCodecs {
+			c, _ := codec.Get(codecName)
+
+			encoded, err := c.Marshal(value)
+			if err != nil {
+				t.Errorf("%s/%s: encoding: %v", codecName, name, err)
+				continue
+			}
+
+			// Decoded without a type, the message has to contain the same as the JSON
+			var generic interface{}
+			if err := c.Unmarshal(encoded, &generic); err != nil {
+				t.Errorf("%s/%s: decoding: %v", codecName, name, err)
+				continue
+			}
+			wantCodec := wantGeneric
+			if codecName == "cbor" {
+				wantCodec = zeroTimesAsNull(asJSON(t, value))
+			}
+			if !reflect.DeepEqual(asJSON(t, generic), wantCodec) {
+				t.Errorf("%s/%s: decoded\n%v\nwant\n%v", codecName, name, generic, wantCodec)
+			}
+
+			// Decoded into the struct, nothing may be lost
+			decoded := reflect.New(reflect.TypeOf(value).Elem()).Interface()
+			if err := c.Unmarshal(encoded, decoded); err != nil {
+				t.Errorf("%s/%s: decoding into %T: %v", codecName, name, decoded, err)
+				continue
+			}
+			got, err := json.Marshal(decoded)
+			if err != nil {
+				t.Fatalf("%s/%s: encoding JSON: %v", codecName, name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s/%s: decoded struct is\n%s\nwant\n%s", codecName, name, got, want)
+			}
+		}
+	}
+}
+
+// asJSON converts a generic value to what encoding/json decodes, e.g. numbers to float64
+func asJSON(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("encoding JSON: %v", err)
+	}
+	var converted interface{}
+	if err := json.Unmarshal(data, &converted); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	return converted
+}
+
+// zeroTimesAsNull replaces zero times, which CBOR encodes as null. They still decode to zero times.
+func zeroTimesAsNull(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == (time.Time{}).Format(time.RFC3339Nano) {
+			return nil
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = zeroTimesAsNull(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = zeroTimesAsNull(v[key])
+		}
+	}
+	return value
+}
+
+// TestBuiltPacket checks the packets the server sends, whose payload is already encoded once per codec
+func TestBuiltPacket(t *testing.T) {
+	payload := server.AuthRevokedPacket{SessionID: "session"}
+	packet, err := server.BuildPacket("auth/revoked", payload, 1<<52)
+	if err != nil {
+		t.Fatalf("building packet: %v", err)
+	}
+
+	for _, codecName := range append([]string{"json"}, binaryCodecs...) {
+		c, _ := codec.Get(codecName)
+		encoded, err := c.Marshal(packet)
+		if err != nil {
+			t.Fatalf("%s: encoding: %v", codecName, err)
+		}
+
+		// Received like a packet of a client, whose payload is decoded once its type is known
+		var received server.WebsocketPacket
+		if err := c.Unmarshal(encoded, &received); err != nil {
+			t.Fatalf("%s: decoding: %v", codecName, err)
+		}
+		var decoded server.AuthRevokedPacket
+		if err := c.Unmarshal(received.Payload, &decoded); err != nil {
+			t.Fatalf("%s: decoding the payload: %v", codecName, err)
+		}
+		if received.Type != "auth/revoked" || decoded != payload || received.Nonce != 1<<52 {
+			t.Errorf("%s: decoded %+v with payload %+v", codecName, received, decoded)
+		}
+
+		// Forwarding the packet keeps the encoded payload as it is
+		forwarded, err := c.Marshal(received)
+		if err != nil {
+			t.Fatalf("%s: encoding the received packet: %v", codecName, err)
+		}
+		if !bytes.Equal(forwarded, encoded) {
+			t.Errorf("%s: forwarded % x, want % x", codecName, forwarded, encoded)
+		}
+	}
+}
+
+// TestJSONUnmarshaler checks that values which decode themselves, like the payloads of plugin packets, get JSON
+func TestJSONUnmarshaler(t *testing.T) {
+	value := map[string]interface{}{"id": 42, "name": "héllo", "tags": []string{"a", "b"}}
+	for _, codecName := range binaryCodecs {
+		c, _ := codec.Get(codecName)
+		encoded, err := c.Marshal(value)
+		if err != nil {
+			t.Fatalf("%s: encoding: %v", codecName, err)
+		}
+
+		var raw json.RawMessage
+		if err := c.Unmarshal(encoded, &raw); err != nil {
+			t.Fatalf("%s: decoding: %v", codecName, err)
+		}
+		if want := `{"id":42,"name":"héllo","tags":["a","b"]}`; string(raw) != want {
+			t.Errorf("%s: decoded %s, want %s", codecName, raw, want)
+		}
+	}
+}
+
+// TestForeignEncodings decodes messages other encoders may produce, but the codecs never write
+func TestForeignEncodings(t *testing.T) {
+	tests := []struct {
+		codec string
+		data  []byte
+		want  string
+	}{
+		{"msgpack", []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, `1.5`},                        // float32
+		{"msgpack", []byte{0xd0, 0x80}, `-128`},                                         // int8
+		{"msgpack", []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, `-2`}, // int64
+		{"msgpack", []byte{0xdc, 0x00, 0x01, 0xc3}, `[true]`},                           // array16
+		{"msgpack", []byte{0xc4, 0x02, 'h', 'i'}, `"hi"`},                               // Binary, as a string
+		{"cbor", []byte{0xf9, 0x3e, 0x00}, `1.5`},                                       // float16
+		{"cbor", []byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, `1.5`},                           // float32
+		{"cbor", []byte{0x38, 0x63}, `-100`},                                            // Negative integer
+		{"cbor", []byte{0x7f, 0x61, 'a', 0x62, 'b', 'c', 0xff}, `"abc"`},                // Indefinite length text
+		{"cbor", []byte{0x9f, 0x01, 0x9f, 0xff, 0xff}, `[1,[]]`},                        // Indefinite length arrays
+		{"cbor", []byte{0xbf, 0x61, 'k', 0xf6, 0xff}, `{"k":null}`},                     // Indefinite length map
+		{"cbor", []byte{0xd8, 0x64, 0x1a, 0x00, 0x01, 0x00, 0x00}, `65536`},             // Unknown tag
+		{"cbor", []byte{0xc1, 0x1a, 0x00, 0x01, 0x00, 0x00}, `"1970-01-01T18:12:16Z"`},  // Epoch time
+		{"cbor", []byte{0xa1, 0x61, 'k', 0xf7}, `{"k":null}`},                           // Undefined
+	}
+
+	for _, test := range tests {
+		c, _ := codec.Get(test.codec)
+		var value interface{}
+		if err := c.Unmarshal(test.data, &value); err != nil {
+			t.Errorf("%s % x: %v", test.codec, test.data, err)
+			continue
+		}
+		got, _ := json.Marshal(value)
+		if string(got) != test.want {
+			t.Errorf("%s % x: decoded %s, want %s", test.codec, test.data, got, test.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	for name, want := range map[string]string{"": "json", "json": "json", "msgpack": "msgpack", "cbor": "cbor"} {
+		c, err := codec.Get(name)
+		if err != nil || c.Name() != want {
+			t.Errorf("Get(%q) = %v, %v, want %s", name, c, err, want)
+		}
+	}
+	if _, err := codec.Get("xml"); err == nil {
+		t.Error("Get(\"xml\") did not fail")
+	}
+	if got := fmt.Sprint(codec.Names()); got != "[cbor json msgpack]" {
+		t.Errorf("Names() = %s", got)
+	}
+}
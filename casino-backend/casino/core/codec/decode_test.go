@@ -0,0 +1,116 @@
+package codec_test
+
+import (
+	"bytes"
+	"jhgambling/backend/core/codec"
+	"jhgambling/backend/core/server"
+	"math/rand"
+	"testing"
+)
+
+// TestTruncated cuts every encoded packet at every position, none of the parts may decode
+func TestTruncated(t *testing.T) {
+	for name, value := range testValues(t) {
+		for _, codecName := range binaryCodecs {
+			c, _ := codec.Get(codecName)
+			encoded, err := c.Marshal(value)
+			if err != nil {
+				t.Fatalf("%s/%s: encoding: %v", codecName, name, err)
+			}
+
+			for i := 0; i < len(encoded); i++ {
+				var decoded interface{}
+				if err := c.Unmarshal(encoded[:i], &decoded); err == nil {
+					t.Errorf("%s/%s: the first %d of %d bytes decoded to %v", codecName, name, i, len(encoded), decoded)
+					break
+				}
+			}
+
+			var decoded interface{}
+			if err := c.Unmarshal(append(encoded, 0x00), &decoded); err == nil {
+				t.Errorf("%s/%s: trailing data was accepted", codecName, name)
+			}
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	huge := []byte{0xff, 0xff, 0xff, 0xff}
+
+	tests := []struct {
+		name  string
+		codec string
+		data  []byte
+	}{
+		{"empty", "msgpack", []byte{}},
+		{"never used type", "msgpack", []byte{0xc1}},
+		{"extension type", "msgpack", []byte{0xd4, 0x01, 0x00}},
+		{"huge string", "msgpack", append([]byte{0xdb}, huge...)},
+		{"huge binary", "msgpack", append([]byte{0xc6}, huge...)},
+		{"huge array", "msgpack", append([]byte{0xdd}, huge...)},
+		{"huge map", "msgpack", append([]byte{0xdf}, huge...)},
+		{"boolean map key", "msgpack", []byte{0x81, 0xc3, 0x01}},
+		{"array map key", "msgpack", []byte{0x81, 0x90, 0x01}},
+		{"integer map key", "msgpack", []byte{0x81, 0x07, 0xa1, 'x'}},
+
+		{"empty", "cbor", []byte{}},
+		{"reserved additional information", "cbor", []byte{0x1c}},
+		{"indefinite integer", "cbor", []byte{0x1f}},
+		{"indefinite tag", "cbor", []byte{0xdf, 0x01}},
+		{"break outside of an item", "cbor", []byte{0xff}},
+		{"huge text", "cbor", append([]byte{0x7b}, append(huge, huge...)...)},
+		{"huge bytes", "cbor", append([]byte{0x5a}, huge...)},
+		{"huge array", "cbor", append([]byte{0x9b}, append(huge, huge...)...)},
+		{"huge map", "cbor", append([]byte{0xbb}, append(huge, huge...)...)},
+		{"invalid UTF-8", "cbor", []byte{0x62, 0xff, 0xfe}},
+		{"boolean map key", "cbor", []byte{0xa1, 0xf5, 0x01}},
+		{"integer map key", "cbor", []byte{0xa1, 0x20, 0xf6}},
+		{"unterminated indefinite array", "cbor", []byte{0x9f, 0x01}},
+		{"unterminated indefinite text", "cbor", []byte{0x7f, 0x61, 'a'}},
+		{"bytes inside indefinite text", "cbor", []byte{0x7f, 0x41, 'a', 0xff}},
+		{"nested indefinite text", "cbor", []byte{0x7f, 0x7f, 0xff, 0xff}},
+		{"NaN, which JSON can't carry", "cbor", []byte{0xf9, 0x7e, 0x00}},
+		{"too deep", "cbor", append(bytes.Repeat([]byte{0x81}, 2000), 0xf6)},
+		{"too many tags", "cbor", append(bytes.Repeat([]byte{0xc1}, 2000), 0x01)},
+	}
+
+	for _, test := range tests {
+		c, _ := codec.Get(test.codec)
+		var decoded interface{}
+		if err := c.Unmarshal(test.data, &decoded); err == nil {
+			t.Errorf("%s: %s was decoded to %v", test.codec, test.name, decoded)
+		}
+	}
+}
+
+// TestWrongShape decodes valid messages whose values don't fit the packet
+func TestWrongShape(t *testing.T) {
+	messages := map[string][]byte{
+		"msgpack": {0x81, 0xa4, 't', 'y', 'p', 'e', 0x01},       // {"type": 1}
+		"cbor":    {0xa1, 0x64, 't', 'y', 'p', 'e', 0x82, 1, 2}, // {"type": [1, 2]}
+	}
+	for codecName, data := range messages {
+		c, _ := codec.Get(codecName)
+		var packet server.WebsocketPacket
+		if err := c.Unmarshal(data, &packet); err == nil {
+			t.Errorf("%s: decoded %+v", codecName, packet)
+		}
+	}
+}
+
+// TestRandomInput only checks that nothing panics, most of the input is invalid
+func TestRandomInput(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, codecName := range binaryCodecs {
+		c, _ := codec.Get(codecName)
+		for i := 0; i < 20000; i++ {
+			data := make([]byte, random.Intn(48))
+			random.Read(data)
+
+			var generic interface{}
+			c.Unmarshal(data, &generic)
+			var packet server.WebsocketPacket
+			c.Unmarshal(data, &packet)
+		}
+	}
+}
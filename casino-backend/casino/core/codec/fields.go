@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// field is a struct field as encoding/json sees it
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+// Field is a struct field as it appears in encoded packets
+type Field struct {
+	Name      string
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// Fields lists the encoded fields of a struct type, in the order encoding/json writes them.
+// The binary codecs take names and omitempty from the same json tags, so schemas of the
+// packets can be derived from it.
+func Fields(t reflect.Type) []Field {
+	fields := []Field{}
+	for _, f := range typeFields(t) {
+		fields = append(fields, Field{
+			Name:      f.name,
+			Type:      t.FieldByIndex(f.index).Type,
+			OmitEmpty: f.omitEmpty,
+		})
+	}
+	return fields
+}
+
+// typeFields lists the fields encoding/json would encode, including the ones of embedded structs.
+// If several fields have the same name, the least nested one wins, then the tagged one.
+// Fields that are still ambiguous are left out.
+func typeFields(t reflect.Type) []field {
+	type candidate struct {
+		field
+		depth int
+	}
+	candidates := []candidate{}
+
+	var walk func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		// Embedding a type into itself through pointers would never end
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+
+			fieldIndex := append(append([]int{}, index...), i)
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				walk(ft, fieldIndex, visited)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+
+			candidates = append(candidates, candidate{
+				field: field{
+					name:      sf.Name,
+					index:     fieldIndex,
+					omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+					tagged:    name != "",
+				},
+				depth: len(fieldIndex),
+			})
+			if name != "" {
+				candidates[len(candidates)-1].name = name
+			}
+		}
+	}
+	walk(t, nil, map[reflect.Type]bool{})
+
+	byName := map[string][]candidate{}
+	order := []string{}
+	for _, c := range candidates {
+		if _, ok := byName[c.name]; !ok {
+			order = append(order, c.name)
+		}
+		byName[c.name] = append(byName[c.name], c)
+	}
+
+	fields := []field{}
+	for _, name := range order {
+		group := byName[name]
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].depth != group[j].depth {
+				return group[i].depth < group[j].depth
+			}
+			return group[i].tagged && !group[j].tagged
+		})
+		if len(group) > 1 && group[0].depth == group[1].depth && group[0].tagged == group[1].tagged {
+			continue
+		}
+		fields = append(fields, group[0].field)
+	}
+
+	// Keep the order of the struct definition, like encoding/json
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
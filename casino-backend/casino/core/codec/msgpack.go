@@ -0,0 +1,128 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gorm.io/gorm"
+)
+
+// MessagePack, see https://github.com/msgpack/msgpack/blob/master/spec.md
+type msgpackCodec struct{}
+
+func init() {
+	// Types with their own JSON encoding are sent as their JSON value, like encoding/json does.
+	// The library doesn't know json.Marshaler, so they have to be registered here. Byte slices
+	// are base64 strings too, the loose decoding would turn binary data into strings.
+	registerMsgpackAsJSON(time.Time{}, gorm.DeletedAt{}, json.RawMessage{}, []byte{})
+}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := checkMsgpack(data); err != nil {
+		return fmt.Errorf("invalid msgpack message: %w", err)
+	}
+
+	// Values that decode themselves from JSON, like the payloads of plugin packets, get JSON
+	if u, ok := v.(json.Unmarshaler); ok && !decodesMsgpack(v) {
+		converted, err := ToJSON(msgpackCodec{}, data)
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalJSON(converted)
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid msgpack message: %w", err)
+	}
+	return nil
+}
+
+// checkMsgpack makes sure the message is complete before it is decoded. The decoder allocates
+// arrays and maps with the length they claim, so a few bytes could ask for gigabytes. Skipping
+// doesn't allocate, and in a complete message every element takes at least a byte.
+func checkMsgpack(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := msgpack.NewDecoder(r).Skip(); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+	return nil
+}
+
+func decodesMsgpack(v interface{}) bool {
+	switch v.(type) {
+	case msgpack.CustomDecoder, msgpack.Unmarshaler:
+		return true
+	}
+	return false
+}
+
+func registerMsgpackAsJSON(values ...interface{}) {
+	for _, value := range values {
+		msgpack.Register(value, encodeMsgpackAsJSON, decodeMsgpackAsJSON)
+	}
+}
+
+func encodeMsgpackAsJSON(enc *msgpack.Encoder, v reflect.Value) error {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	value, err := jsonValue(data)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(value)
+}
+
+func decodeMsgpackAsJSON(dec *msgpack.Decoder, v reflect.Value) error {
+	value, err := dec.DecodeInterfaceLoose()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+func (m RawMessage) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if len(m) == 0 {
+		return enc.EncodeNil()
+	}
+	return enc.Encode(msgpack.RawMessage(m))
+}
+
+func (m *RawMessage) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	*m = append((*m)[0:0], raw...)
+	return nil
+}
@@ -0,0 +1,503 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jhgambling/backend/core/utils"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The secret shipped with the code, it must never be used in production
+const DefaultAuthSecret = "jhgambling-key-1"
+
+// Config holds all settings of the casino backend.
+// Values are read from defaults, then the config file, then environment variables.
+type Config struct {
+	Environment string `json:"-"` // Taken from ENV, everything except "production" is treated as development
+
+	Server   ServerConfig   `json:"server"`
+	Database DatabaseConfig `json:"database"`
+	Auth     AuthConfig     `json:"auth"`
+	Plugins  PluginsConfig  `json:"plugins"`
+	Wallet   WalletConfig   `json:"wallet"`
+}
+
+type ServerConfig struct {
+	Addr           string   `json:"addr"`
+	AllowedOrigins []string `json:"allowedOrigins"` // Allows all origins if empty
+	RequireHello   bool     `json:"requireHello"`   // Disconnects clients that send other packets before hello
+
+	ShutdownTimeout Duration `json:"shutdownTimeout"` // How long a shutdown may wait for clients and games before it gives up
+
+	RateLimit PacketRateLimitConfig `json:"rateLimit"`
+	Requests  RequestConfig         `json:"requests"`
+}
+
+// RequestConfig controls how requests are correlated by their nonce
+type RequestConfig struct {
+	Timeout         Duration `json:"timeout"`         // Default time clients get to answer requests of the server
+	ReplayWindow    Duration `json:"replayWindow"`    // How long responses are kept for retries with the same nonce
+	ReplayCacheSize int      `json:"replayCacheSize"` // Responses kept per client, disabled if zero
+}
+
+// PacketRateLimitConfig limits how many packets a single client may send
+type PacketRateLimitConfig struct {
+	Default PacketLimit            `json:"default"` // Applies to all packets of a client together
+	Packets map[string]PacketLimit `json:"packets"` // Additional limits for single packet types
+
+	MaxViolations   int      `json:"maxViolations"`   // Rejected packets within the window before the client is disconnected, disabled if zero
+	ViolationWindow Duration `json:"violationWindow"` // Window in which violations are counted
+	MaxMessageBytes int64    `json:"maxMessageBytes"` // Larger messages close the connection
+}
+
+// PacketLimit is a token bucket, it refills with Rate tokens per second up to Burst tokens.
+// A rate of zero disables the limit.
+type PacketLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type DatabaseConfig struct {
+	Path string `json:"path"`
+}
+
+type AuthConfig struct {
+	Secret               string   `json:"secret"`
+	TokenLifetime        Duration `json:"tokenLifetime"`        // Lifetime of access tokens
+	RefreshTokenLifetime Duration `json:"refreshTokenLifetime"` // Lifetime of a session without being refreshed
+	SigningAlgorithm     string   `json:"signingAlgorithm"`     // "HS256", "EdDSA" or "RS256"
+	KeyRotationInterval  Duration `json:"keyRotationInterval"`  // Disables rotation if zero
+
+	RateLimit RateLimitConfig `json:"rateLimit"`
+
+	PasswordPolicy    PasswordPolicyConfig `json:"passwordPolicy"`
+	ResetCodeLifetime Duration             `json:"resetCodeLifetime"` // How long admin-issued reset codes stay valid
+
+	TwoFactor TwoFactorConfig `json:"twoFactor"`
+
+	Hashing HashingConfig `json:"hashing"`
+}
+
+// HashingConfig controls how passwords are hashed. Hashes made with other
+// settings are replaced the next time their user logs in.
+type HashingConfig struct {
+	Algorithm  string       `json:"algorithm"` // "bcrypt" or "argon2id"
+	BcryptCost int          `json:"bcryptCost"`
+	Argon2     Argon2Config `json:"argon2"`
+	Workers    int          `json:"workers"`   // Passwords hashed at the same time
+	QueueSize  int          `json:"queueSize"` // Requests waiting for a worker, logins beyond that are turned away
+}
+
+type Argon2Config struct {
+	MemoryKiB   uint32 `json:"memoryKiB"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+}
+
+// Algorithms that can be used to hash passwords
+var HashingAlgorithms = []string{"bcrypt", "argon2id"}
+
+// TwoFactorConfig configures TOTP two-factor authentication
+type TwoFactorConfig struct {
+	Issuer        string   `json:"issuer"`        // Shown in authenticator apps
+	RequiredRoles []string `json:"requiredRoles"` // Users with one of these roles can't log in without 2FA, "admin" includes IsAdmin users
+}
+
+// PasswordPolicyConfig is enforced whenever a password is set
+type PasswordPolicyConfig struct {
+	MinLength        int  `json:"minLength"`
+	MaxLength        int  `json:"maxLength"` // In characters, at most 72 with bcrypt, which also limits passwords to 72 bytes
+	RequireLetter    bool `json:"requireLetter"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+	DisallowUsername bool `json:"disallowUsername"` // Rejects passwords containing the username
+}
+
+// RateLimitConfig limits how often login, registration and username checks can be attempted
+type RateLimitConfig struct {
+	AttemptsPerMinute  int      `json:"attemptsPerMinute"`  // Auth packets per IP and minute, disabled if zero
+	FreeFailures       int      `json:"freeFailures"`       // Failed logins before the backoff starts
+	BaseBackoff        Duration `json:"baseBackoff"`        // Delay after the first failure past the free ones, doubles every time
+	MaxBackoff         Duration `json:"maxBackoff"`         // Upper limit for the backoff
+	LockoutThreshold   int      `json:"lockoutThreshold"`   // Consecutive failures that lock a username, disabled if zero
+	LockoutDuration    Duration `json:"lockoutDuration"`    // How long a locked username stays locked
+	UniformLoginErrors bool     `json:"uniformLoginErrors"` // Don't reveal whether a username exists
+}
+
+// Algorithms that can be used to sign access tokens
+var SigningAlgorithms = []string{"HS256", "EdDSA", "RS256"}
+
+type PluginsConfig struct {
+	Directory string `json:"directory"`
+}
+
+type WalletConfig struct {
+	StartingBonusCents uint `json:"startingBonusCents"`
+}
+
+// Duration can be written as a string like "96h" or "15m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s: expected a string like \"15m\"", data)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Default returns the configuration used when nothing else is set
+func Default(environment string) *Config {
+	cfg := &Config{
+		Environment: environment,
+		Server: ServerConfig{
+			Addr:            ":9000",
+			AllowedOrigins:  []string{},
+			ShutdownTimeout: Duration{time.Second * 30},
+			RateLimit: PacketRateLimitConfig{
+				Default: PacketLimit{Rate: 20, Burst: 40},
+				Packets: map[string]PacketLimit{
+					"db/op":       {Rate: 10, Burst: 20},
+					"db/sub":      {Rate: 5, Burst: 20},
+					"game/packet": {Rate: 30, Burst: 60},
+					"ping":        {Rate: 1, Burst: 5},
+				},
+				MaxViolations:   50,
+				ViolationWindow: Duration{time.Second * 10},
+				MaxMessageBytes: 64 * 1024,
+			},
+			Requests: RequestConfig{
+				Timeout:         Duration{time.Second * 30},
+				ReplayWindow:    Duration{time.Minute * 5},
+				ReplayCacheSize: 64,
+			},
+		},
+		Database: DatabaseConfig{
+			Path: "../casino.db",
+		},
+		Auth: AuthConfig{
+			Secret:               DefaultAuthSecret,
+			TokenLifetime:        Duration{time.Minute * 15},
+			RefreshTokenLifetime: Duration{time.Hour * 24 * 30},
+			SigningAlgorithm:     "HS256",
+			KeyRotationInterval:  Duration{time.Hour * 24 * 30},
+			RateLimit: RateLimitConfig{
+				AttemptsPerMinute: 30,
+				FreeFailures:      3,
+				BaseBackoff:       Duration{time.Second},
+				MaxBackoff:        Duration{time.Minute * 5},
+				LockoutThreshold:  10,
+				LockoutDuration:   Duration{time.Minute * 30},
+			},
+			PasswordPolicy: PasswordPolicyConfig{
+				MinLength:        8,
+				MaxLength:        72,
+				RequireLetter:    true,
+				RequireDigit:     true,
+				DisallowUsername: true,
+			},
+			ResetCodeLifetime: Duration{time.Hour},
+			TwoFactor: TwoFactorConfig{
+				Issuer:        "JHGambling",
+				RequiredRoles: []string{},
+			},
+			Hashing: HashingConfig{
+				Algorithm:  "bcrypt",
+				BcryptCost: 14,
+				Argon2: Argon2Config{
+					MemoryKiB:   64 * 1024,
+					Iterations:  3,
+					Parallelism: 2,
+				},
+				Workers:   runtime.NumCPU(),
+				QueueSize: 64,
+			},
+		},
+		Plugins: PluginsConfig{
+			Directory: "../games/",
+		},
+		Wallet: WalletConfig{
+			StartingBonusCents: 100000, // $1000 in cents
+		},
+	}
+
+	if cfg.IsProduction() {
+		cfg.Database.Path = "/data/casino.db"
+		cfg.Auth.RateLimit.UniformLoginErrors = true
+		cfg.Auth.TwoFactor.RequiredRoles = []string{"admin"}
+	}
+
+	return cfg
+}
+
+// Environments that ENV can select, others get the development defaults with a warning
+var Environments = []string{"development", "production"}
+
+// Load reads the configuration from the file in CASINO_CONFIG (default: casino.json,
+// which may be missing) and applies environment overrides on top
+func Load() (*Config, error) {
+	// ENV=Production still selects production, but a typo like ENV=prod would
+	// silently run without the production protections, so it is logged
+	environment := strings.ToLower(strings.TrimSpace(os.Getenv("ENV")))
+	if environment == "" {
+		environment = "development"
+	}
+	if !slices.Contains(Environments, environment) {
+		utils.Log("warn", "casino::config", "unknown ENV '", environment, "', using the development defaults (known: ", strings.Join(Environments, ", "), ")")
+	}
+	cfg := Default(environment)
+
+	path, explicit := os.LookupEnv("CASINO_CONFIG")
+	if !explicit {
+		path = "casino.json"
+	}
+
+	if err := cfg.loadFile(path); err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			// The config file is optional
+		} else {
+			return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
+// applyEnv overrides single settings with environment variables
+func (cfg *Config) applyEnv() error {
+	if v, ok := os.LookupEnv("CASINO_SERVER_ADDR"); ok {
+		cfg.Server.Addr = v
+	}
+	if v, ok := os.LookupEnv("CASINO_ALLOWED_ORIGINS"); ok {
+		cfg.Server.AllowedOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CASINO_REQUIRE_HELLO"); ok {
+		require, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_REQUIRE_HELLO: %w", err)
+		}
+		cfg.Server.RequireHello = require
+	}
+	if v, ok := os.LookupEnv("CASINO_SHUTDOWN_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_SHUTDOWN_TIMEOUT: %w", err)
+		}
+		cfg.Server.ShutdownTimeout = Duration{d}
+	}
+	if v, ok := os.LookupEnv("CASINO_DB_PATH"); ok {
+		cfg.Database.Path = v
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_SECRET"); ok {
+		cfg.Auth.Secret = v
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_TOKEN_LIFETIME"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_TOKEN_LIFETIME: %w", err)
+		}
+		cfg.Auth.TokenLifetime = Duration{d}
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_REFRESH_TOKEN_LIFETIME"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_REFRESH_TOKEN_LIFETIME: %w", err)
+		}
+		cfg.Auth.RefreshTokenLifetime = Duration{d}
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_SIGNING_ALGORITHM"); ok {
+		cfg.Auth.SigningAlgorithm = v
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_KEY_ROTATION_INTERVAL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_KEY_ROTATION_INTERVAL: %w", err)
+		}
+		cfg.Auth.KeyRotationInterval = Duration{d}
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_UNIFORM_LOGIN_ERRORS"); ok {
+		uniform, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_UNIFORM_LOGIN_ERRORS: %w", err)
+		}
+		cfg.Auth.RateLimit.UniformLoginErrors = uniform
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_2FA_REQUIRED_ROLES"); ok {
+		cfg.Auth.TwoFactor.RequiredRoles = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_HASH_ALGORITHM"); ok {
+		cfg.Auth.Hashing.Algorithm = v
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_BCRYPT_COST"); ok {
+		cost, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_BCRYPT_COST: %w", err)
+		}
+		cfg.Auth.Hashing.BcryptCost = cost
+	}
+	if v, ok := os.LookupEnv("CASINO_AUTH_HASH_WORKERS"); ok {
+		workers, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_AUTH_HASH_WORKERS: %w", err)
+		}
+		cfg.Auth.Hashing.Workers = workers
+	}
+	if v, ok := os.LookupEnv("CASINO_PLUGIN_DIR"); ok {
+		cfg.Plugins.Directory = v
+	}
+	if v, ok := os.LookupEnv("CASINO_STARTING_BONUS_CENTS"); ok {
+		cents, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid CASINO_STARTING_BONUS_CENTS: %w", err)
+		}
+		cfg.Wallet.StartingBonusCents = uint(cents)
+	}
+	return nil
+}
+
+// Validate checks the configuration for missing or unsafe values
+func (cfg *Config) Validate() error {
+	errs := []error{}
+
+	if cfg.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr cannot be empty"))
+	}
+	if cfg.Server.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server.shutdownTimeout has to be positive"))
+	}
+	for packetType, limit := range cfg.Server.RateLimit.Packets {
+		if err := limit.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("server.rateLimit.packets[%s]: %w", packetType, err))
+		}
+	}
+	if err := cfg.Server.RateLimit.Default.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("server.rateLimit.default: %w", err))
+	}
+	if cfg.Server.RateLimit.MaxViolations < 0 || cfg.Server.RateLimit.MaxMessageBytes < 0 {
+		errs = append(errs, errors.New("server.rateLimit values cannot be negative"))
+	}
+	if cfg.Server.Requests.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("server.requests.timeout has to be positive"))
+	}
+	if cfg.Server.Requests.ReplayWindow.Duration < 0 || cfg.Server.Requests.ReplayCacheSize < 0 {
+		errs = append(errs, errors.New("server.requests values cannot be negative"))
+	}
+	if cfg.Database.Path == "" {
+		errs = append(errs, errors.New("database.path cannot be empty"))
+	}
+	if cfg.Auth.Secret == "" {
+		errs = append(errs, errors.New("auth.secret cannot be empty"))
+	}
+	if cfg.IsProduction() && cfg.Auth.Secret == DefaultAuthSecret {
+		errs = append(errs, errors.New("auth.secret has to be changed from the default in production (set CASINO_AUTH_SECRET)"))
+	}
+	if cfg.Auth.TokenLifetime.Duration <= 0 {
+		errs = append(errs, errors.New("auth.tokenLifetime has to be positive"))
+	}
+	if cfg.Auth.RefreshTokenLifetime.Duration < cfg.Auth.TokenLifetime.Duration {
+		errs = append(errs, errors.New("auth.refreshTokenLifetime cannot be shorter than auth.tokenLifetime"))
+	}
+	if !slices.Contains(SigningAlgorithms, cfg.Auth.SigningAlgorithm) {
+		errs = append(errs, fmt.Errorf("auth.signingAlgorithm has to be one of %v, got '%s'", SigningAlgorithms, cfg.Auth.SigningAlgorithm))
+	}
+	if cfg.Auth.KeyRotationInterval.Duration < 0 {
+		errs = append(errs, errors.New("auth.keyRotationInterval cannot be negative"))
+	}
+	if limits := cfg.Auth.RateLimit; limits.AttemptsPerMinute < 0 || limits.FreeFailures < 0 || limits.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("auth.rateLimit values cannot be negative"))
+	}
+	if limits := cfg.Auth.RateLimit; limits.BaseBackoff.Duration > limits.MaxBackoff.Duration {
+		errs = append(errs, errors.New("auth.rateLimit.baseBackoff cannot be longer than auth.rateLimit.maxBackoff"))
+	}
+	if policy := cfg.Auth.PasswordPolicy; policy.MinLength < 1 || policy.MinLength > policy.MaxLength {
+		errs = append(errs, errors.New("auth.passwordPolicy needs 1 <= minLength <= maxLength"))
+	}
+	if cfg.Auth.Hashing.Algorithm == "bcrypt" && cfg.Auth.PasswordPolicy.MaxLength > 72 {
+		errs = append(errs, errors.New("auth.passwordPolicy.maxLength cannot be more than 72 with bcrypt, which only hashes 72 bytes"))
+	}
+	if cfg.Auth.ResetCodeLifetime.Duration <= 0 {
+		errs = append(errs, errors.New("auth.resetCodeLifetime has to be positive"))
+	}
+	if cfg.Auth.TwoFactor.Issuer == "" {
+		errs = append(errs, errors.New("auth.twoFactor.issuer cannot be empty"))
+	}
+	if hashing := cfg.Auth.Hashing; !slices.Contains(HashingAlgorithms, hashing.Algorithm) {
+		errs = append(errs, fmt.Errorf("auth.hashing.algorithm has to be one of %v, got '%s'", HashingAlgorithms, hashing.Algorithm))
+	}
+	if cost := cfg.Auth.Hashing.BcryptCost; cost < 4 || cost > 31 {
+		errs = append(errs, errors.New("auth.hashing.bcryptCost has to be between 4 and 31"))
+	}
+	if argon := cfg.Auth.Hashing.Argon2; argon.Iterations < 1 || argon.Parallelism < 1 || argon.MemoryKiB < 8*uint32(argon.Parallelism) {
+		errs = append(errs, errors.New("auth.hashing.argon2 needs at least 1 iteration, 1 thread and 8 KiB of memory per thread"))
+	}
+	if cfg.Auth.Hashing.Workers < 1 || cfg.Auth.Hashing.QueueSize < 0 {
+		errs = append(errs, errors.New("auth.hashing needs at least 1 worker and a queueSize that isn't negative"))
+	}
+	if cfg.Plugins.Directory == "" {
+		errs = append(errs, errors.New("plugins.directory cannot be empty"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (limit PacketLimit) validate() error {
+	if limit.Rate < 0 {
+		return errors.New("rate cannot be negative")
+	}
+	if limit.Rate > 0 && limit.Burst < 1 {
+		return errors.New("burst has to be at least 1")
+	}
+	return nil
+}
+
+func (cfg *Config) IsProduction() bool {
+	return cfg.Environment == "production"
+}
+
+func splitList(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -1,17 +1,27 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"jhgambling/backend/core/auth"
+	"jhgambling/backend/core/config"
 	"jhgambling/backend/core/data"
 	"jhgambling/backend/core/game"
 	"jhgambling/backend/core/plugins"
 	"jhgambling/backend/core/server"
 	"jhgambling/backend/core/utils"
-	"os"
+	"jhgambling/protocol"
+	"net/http"
 	"time"
 )
 
+// Time the last steps of a shutdown get after the timeout, before the casino gives up on them
+const shutdownGrace = time.Second * 5
+
 type CasinoCore struct {
+	Config *config.Config
+
 	Database *data.Database
 	Server   *server.Server
 	Gateway  *server.Gateway
@@ -20,15 +30,19 @@ type CasinoCore struct {
 	Games    *game.GameManager
 
 	Adapter *CasinoPluginAdapter
+
+	stopUpdates chan struct{} // Stops the subscription loop
+	updatesDone chan struct{} // Closed once the subscription loop has returned
 }
 
-func NewCasino() *CasinoCore {
-	db := data.NewDatabase()
-	auth := auth.NewAuthManager()
-	plugins := plugins.NewPluginManager()
+func NewCasino(cfg *config.Config) *CasinoCore {
+	db := data.NewDatabase(cfg)
+	auth := auth.NewAuthManager(cfg.Auth)
+	plugins := plugins.NewPluginManager(cfg.Plugins)
 	games := game.NewGameManager()
 
 	ctx := server.GatewayContext{
+		Config:   cfg,
 		Database: db,
 		Auth:     auth,
 		Games:    games,
@@ -36,9 +50,10 @@ func NewCasino() *CasinoCore {
 	gateway := server.NewGateway(ctx)
 
 	casino := &CasinoCore{
+		Config:   cfg,
 		Database: db,
 		Gateway:  gateway,
-		Server:   server.NewServer(gateway),
+		Server:   server.NewServer(gateway, cfg.Server),
 		Auth:     auth,
 		Plugins:  plugins,
 		Games:    games,
@@ -57,30 +72,142 @@ func (c *CasinoCore) Init() {
 	c.Plugins.LoadPlugins()
 
 	// Database
-	env := os.Getenv("ENV")
-	dbPath := ""
-	if env == "production" {
-		dbPath = "/data/casino.db"
-	} else {
-		dbPath = "../casino.db"
-	}
-	c.Database.Connect(dbPath)
+	c.Database.Connect(c.Config.Database.Path)
 	c.Database.Migrate()
+	if err := c.Auth.Connect(c.Database); err != nil {
+		utils.Log("fatal", "casino::core", "failed to load signing keys: ", err)
+		panic("failed to initialize auth")
+	}
+	c.Database.ReconcileLedger()
 	c.Database.SetSubscriptionChannel(&c.Gateway.Subscriptions.ChangedRecordsChannel)
 
 	// Game integration
 	c.Games.SetAdapter(c.Adapter)
 	c.registerGameProviders()
+	c.registerPluginPackets()
 }
 
-func (c *CasinoCore) Start() {
+// Start opens the server and runs the game and subscription loops in the background.
+// Errors of the server, e.g. an address that is taken, are sent on the returned channel.
+func (c *CasinoCore) Start() <-chan error {
 	utils.Log("info", "casino::core", "starting...")
 
-	go c.Server.Start(":9000")
-	for {
-		c.Gateway.Subscriptions.Update()
-		time.Sleep(time.Millisecond * 10)
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := c.Server.Start(c.Config.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	c.Games.Start()
+
+	c.stopUpdates = make(chan struct{})
+	c.updatesDone = make(chan struct{})
+	go func() {
+		defer close(c.updatesDone)
+		for {
+			select {
+			case <-c.stopUpdates:
+				return
+			default:
+			}
+			c.Gateway.Subscriptions.Flush()
+			time.Sleep(time.Millisecond * 10)
+		}
+	}()
+
+	return serverErr
+}
+
+// Run starts the casino and blocks until ctx ends or the server fails, then shuts the casino down
+func (c *CasinoCore) Run(ctx context.Context) error {
+	serverErr := c.Start()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		utils.Log("info", "casino::core", "shutting down...")
+	case err = <-serverErr:
+		utils.Log("error", "casino::core", "server failed, shutting down: ", err)
+	}
+
+	timeout := c.Config.Server.ShutdownTimeout.Duration
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Shutdown(shutdownCtx)
+	}()
+
+	select {
+	case shutdownErr := <-done:
+		return errors.Join(err, shutdownErr)
+	case <-time.After(timeout + shutdownGrace):
+		return errors.Join(err, fmt.Errorf("shutdown did not finish within %v", timeout+shutdownGrace))
+	}
+}
+
+// Shutdown stops the casino without losing bets or writes. New connections are refused,
+// clients are told and the packets they already sent are handled, games are closed and
+// the bets they left open refunded, and the last changes reach their subscribers before
+// the database is closed. Waiting for clients ends with ctx, the remaining steps still run.
+func (c *CasinoCore) Shutdown(ctx context.Context) error {
+	errs := []error{}
+	step := func(name string, err error) {
+		if err != nil {
+			utils.Log("warn", "casino::core", "[shutdown] ", name, ": ", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
 	}
+
+	step("server", c.Server.Shutdown(ctx))
+	step("gateway", c.Gateway.Shutdown(ctx, "the server is shutting down"))
+
+	c.Games.Stop()
+	c.Gateway.CloseGames()
+	step("bets", c.refundOpenBets())
+
+	// Logins still in the queue may write sessions, which are sent to subscribers below
+	c.Auth.Close()
+
+	if c.stopUpdates != nil {
+		close(c.stopUpdates)
+		<-c.updatesDone
+		c.stopUpdates = nil
+	}
+	c.Gateway.Subscriptions.Flush()
+
+	step("clients", c.Gateway.DisconnectAll(ctx))
+	step("database", c.Database.Close())
+
+	if len(errs) == 0 {
+		utils.Log("ok", "casino::core", "shut down")
+	}
+	return errors.Join(errs...)
+}
+
+// refundOpenBets returns the stakes of bets no game settled before it was closed
+func (c *CasinoCore) refundOpenBets() error {
+	bets := c.Database.GetBetTable()
+	open, err := bets.FindOpenBets()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, bet := range open {
+		if err := bets.RefundBet(bet.RoundID, bet.GameInstance); err != nil {
+			utils.Log("error", "casino::core", "[shutdown] failed to refund bet of round '", bet.RoundID, "': ", err)
+			failed++
+			continue
+		}
+		utils.Log("info", "casino::core", "[shutdown] refunded ", bet.AmountCents, " cents of round '", bet.RoundID, "' to wallet ", bet.WalletID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d open bets could not be refunded", failed, len(open))
+	}
+	return nil
 }
 
 func (c *CasinoCore) registerGameProviders() {
@@ -88,3 +215,14 @@ func (c *CasinoCore) registerGameProviders() {
 		c.Games.RegisterProvider(p)
 	}
 }
+
+// registerPluginPackets lets plugins handle their own packet types
+func (c *CasinoCore) registerPluginPackets() {
+	for _, p := range c.Plugins.GameProviders {
+		if provider, ok := p.(protocol.PacketProvider); ok {
+			if err := c.Gateway.Packets.RegisterPluginPackets(p.GetID(), provider.GetPacketHandlers()); err != nil {
+				utils.Log("error", "casino::core", "failed to register packets of plugin '", p.GetID(), "': ", err)
+			}
+		}
+	}
+}
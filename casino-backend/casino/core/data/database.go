@@ -2,10 +2,12 @@ package data
 
 import (
 	"errors"
+	"jhgambling/backend/core/config"
 	"jhgambling/backend/core/data/tables"
 	"jhgambling/backend/core/utils"
 	"jhgambling/protocol"
 	"jhgambling/protocol/models"
+	"sort"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -15,11 +17,13 @@ import (
 type Database struct {
 	connection *gorm.DB
 	registry   *tables.TableRegistry
+	config     *config.Config
 }
 
-func NewDatabase() *Database {
+func NewDatabase(cfg *config.Config) *Database {
 	return &Database{
 		registry: tables.NewTableRegistry(),
+		config:   cfg,
 	}
 }
 
@@ -38,6 +42,22 @@ func (db *Database) Connect(path string) {
 	db.RegisterDefaultTables()
 }
 
+// Close closes the connection, after all writes are done
+func (db *Database) Close() error {
+	if db.connection == nil {
+		return nil
+	}
+	sqlDB, err := db.connection.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Close(); err != nil {
+		return err
+	}
+	utils.Log("ok", "casino::data", "closed db connection")
+	return nil
+}
+
 func (db *Database) Migrate() {
 	// Get all models from registered tables
 	registeredTables := db.registry.GetAll()
@@ -45,14 +65,17 @@ func (db *Database) Migrate() {
 	if len(registeredTables) == 0 {
 		// If no tables are registered, fall back to direct model migration
 	} else {
-		// Migrate models from registered tables and repair them
+		// Migrate models from registered tables
 		for _, table := range registeredTables {
 			db.connection.AutoMigrate(table.GetModelType())
 
 			// Set the DB connection for each table
 			table.SetDB(db.connection)
+		}
 
-			// Repair the table
+		// Repair the tables once all of them are migrated,
+		// since repairs may touch other tables
+		for _, table := range registeredTables {
 			table.Repair()
 		}
 	}
@@ -63,12 +86,59 @@ func (db *Database) Migrate() {
 func (db *Database) RegisterDefaultTables() {
 	utils.Log("info", "casino::data", "registering default tables...")
 
-	if err := db.RegisterTable(tables.NewWalletTable()); err != nil {
+	wallets := tables.NewWalletTable()
+	if err := db.RegisterTable(wallets); err != nil {
 		utils.Log("error", "casino::data", "error registering wallets table:", err)
 		panic("failed to register default tables")
 	}
 
-	if err := db.RegisterTable(tables.NewUserTable()); err != nil {
+	transactions := tables.NewTransactionTable(wallets)
+	if err := db.RegisterTable(transactions); err != nil {
+		utils.Log("error", "casino::data", "error registering transactions table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewBetTable(transactions)); err != nil {
+		utils.Log("error", "casino::data", "error registering bets table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewRoleTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering roles table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewUserRoleTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering user_roles table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewSessionTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering sessions table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewPasswordResetTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering password_resets table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewTwoFactorTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering two_factor table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewRecoveryCodeTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering recovery_codes table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewSigningKeyTable()); err != nil {
+		utils.Log("error", "casino::data", "error registering signing_keys table:", err)
+		panic("failed to register default tables")
+	}
+
+	if err := db.RegisterTable(tables.NewUserTable(transactions, db.config.Wallet.StartingBonusCents)); err != nil {
 		utils.Log("error", "casino::data", "error registering users table:", err)
 		panic("failed to register default tables")
 	}
@@ -87,6 +157,13 @@ func (db *Database) GetTable(tableID string) (protocol.Table, error) {
 	return db.registry.Get(tableID)
 }
 
+// Tables returns all registered tables, sorted by ID
+func (db *Database) Tables() []protocol.Table {
+	tables := db.registry.GetAll()
+	sort.Slice(tables, func(i, j int) bool { return tables[i].GetID() < tables[j].GetID() })
+	return tables
+}
+
 // GetUserTable returns the user table if registered, or creates a default one
 func (db *Database) GetUserTable() *tables.UserTable {
 	table, err := db.registry.Get("users")
@@ -102,6 +179,132 @@ func (db *Database) GetUserTable() *tables.UserTable {
 	return userTable
 }
 
+// GetTransactionTable returns the ledger
+func (db *Database) GetTransactionTable() *tables.TransactionTable {
+	table, err := db.registry.Get("transactions")
+	if err != nil {
+		panic("transaction table does not exist: " + err.Error())
+	}
+
+	transactionTable, ok := table.(*tables.TransactionTable)
+	if !ok {
+		panic("invalid transaction table")
+	}
+
+	return transactionTable
+}
+
+// GetBetTable returns the bet table
+func (db *Database) GetBetTable() *tables.BetTable {
+	table, err := db.registry.Get("bets")
+	if err != nil {
+		panic("bet table does not exist: " + err.Error())
+	}
+
+	betTable, ok := table.(*tables.BetTable)
+	if !ok {
+		panic("invalid bet table")
+	}
+
+	return betTable
+}
+
+// GetSessionTable returns the session table
+func (db *Database) GetSessionTable() *tables.SessionTable {
+	table, err := db.registry.Get("sessions")
+	if err != nil {
+		panic("session table does not exist: " + err.Error())
+	}
+
+	sessionTable, ok := table.(*tables.SessionTable)
+	if !ok {
+		panic("invalid session table")
+	}
+
+	return sessionTable
+}
+
+// GetSigningKeyTable returns the table holding the token signing keys
+func (db *Database) GetSigningKeyTable() *tables.SigningKeyTable {
+	table, err := db.registry.Get("signing_keys")
+	if err != nil {
+		panic("signing key table does not exist: " + err.Error())
+	}
+
+	keyTable, ok := table.(*tables.SigningKeyTable)
+	if !ok {
+		panic("invalid signing key table")
+	}
+
+	return keyTable
+}
+
+// GetPasswordResetTable returns the table holding password reset codes
+func (db *Database) GetPasswordResetTable() *tables.PasswordResetTable {
+	table, err := db.registry.Get("password_resets")
+	if err != nil {
+		panic("password reset table does not exist: " + err.Error())
+	}
+
+	resetTable, ok := table.(*tables.PasswordResetTable)
+	if !ok {
+		panic("invalid password reset table")
+	}
+
+	return resetTable
+}
+
+// GetTwoFactorTable returns the table holding TOTP secrets
+func (db *Database) GetTwoFactorTable() *tables.TwoFactorTable {
+	table, err := db.registry.Get("two_factor")
+	if err != nil {
+		panic("two-factor table does not exist: " + err.Error())
+	}
+
+	twoFactorTable, ok := table.(*tables.TwoFactorTable)
+	if !ok {
+		panic("invalid two-factor table")
+	}
+
+	return twoFactorTable
+}
+
+// GetRecoveryCodeTable returns the table holding 2FA recovery codes
+func (db *Database) GetRecoveryCodeTable() *tables.RecoveryCodeTable {
+	table, err := db.registry.Get("recovery_codes")
+	if err != nil {
+		panic("recovery code table does not exist: " + err.Error())
+	}
+
+	recoveryTable, ok := table.(*tables.RecoveryCodeTable)
+	if !ok {
+		panic("invalid recovery code table")
+	}
+
+	return recoveryTable
+}
+
+// ReconcileLedger checks that every wallet balance matches its ledger postings and logs the result
+func (db *Database) ReconcileLedger() (tables.ReconciliationReport, error) {
+	report, err := db.GetTransactionTable().Reconcile()
+	if err != nil {
+		utils.Log("error", "casino::data", "[ledger] reconciliation failed:", err)
+		return report, err
+	}
+
+	for _, m := range report.Mismatches {
+		utils.Log("error", "casino::data", "[ledger] wallet ", m.WalletID, " has a balance of ", m.BalanceCents, " but postings sum up to ", m.PostedCents)
+	}
+	if report.ImbalanceCents != 0 {
+		utils.Log("error", "casino::data", "[ledger] postings do not sum up to zero, imbalance: ", report.ImbalanceCents)
+	}
+	if report.OK() {
+		utils.Log("ok", "casino::data", "[ledger] reconciled ", report.WalletsChecked, " wallet(s)")
+	}
+
+	return report, nil
+}
+
 // GetTableAsUser gets a registered table with added type safety for AsUser operations
 func (db *Database) GetTableAsUser(tableID string) (protocol.Table, error) {
 	return db.registry.Get(tableID)
@@ -119,31 +322,47 @@ func (db *Database) PerformOperationAsUser(authenticatedUser models.UserModel, t
 		return nil, err
 	}
 
+	// IDs are passed on to queries, where strings would be taken as SQL conditions
+	if operation == "findByID" || operation == "update" || operation == "delete" {
+		if id, err = protocol.ParseID(id); err != nil {
+			return nil, err
+		}
+	}
+
 	switch operation {
 	case "create":
 		return nil, table.CreateAsUser(authenticatedUser, data)
 	case "findByID":
-		return table.FindByIDAsUser(authenticatedUser, id)
-	case "findAll":
-		limit, ok := id.(int)
-		if !ok {
-			limit = 10
-		}
-		offset, ok := data.(int)
-		if !ok {
-			offset = 0
+		record, err := table.FindByIDAsUser(authenticatedUser, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, protocol.Errorf(protocol.ErrorDBRecordNotFound, "record %v not found", id)
 		}
-		return table.FindAllAsUser(authenticatedUser, limit, offset)
+		return record, err
+	case "findAll":
+		return table.FindAllAsUser(authenticatedUser, intOperand(id, 10), intOperand(data, 0))
 	case "update":
 		return nil, table.UpdateAsUser(authenticatedUser, id, data)
 	case "delete":
 		return nil, table.DeleteAsUser(authenticatedUser, id)
 	default:
 		utils.Log("warn", "casino::data", "[PerformOperationAsUser] unkown operation \""+operation+"\" by user", authenticatedUser.ID)
-		return nil, errors.New("unknown operation")
+		return nil, protocol.Errorf(protocol.ErrorDBUnknownOperation, "unknown operation '%s'", operation)
 	}
 }
 
 func (db *Database) SetSubscriptionChannel(ch *chan protocol.SubChangedRecord) {
 	db.registry.SetSubscriptionChannel(ch)
 }
+
+// intOperand reads a number of an operation, JSON numbers are decoded as float64
+func intOperand(v interface{}, fallback int) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		if n >= 0 && n == float64(int(n)) {
+			return int(n)
+		}
+	}
+	return fallback
+}
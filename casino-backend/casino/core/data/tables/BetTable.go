@@ -0,0 +1,286 @@
+package tables
+
+import (
+	"errors"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Holds the stakes of all bets that have not been settled yet
+const AccountEscrow = "casino:escrow"
+
+var (
+	ErrBetNotFound = protocol.NewError(protocol.ErrorBetNotFound, "bet not found")
+	ErrBetConflict = protocol.NewError(protocol.ErrorBetConflict, "a different bet with this round ID already exists")
+	ErrBetClosed   = protocol.NewError(protocol.ErrorBetClosed, "bet has already been closed")
+)
+
+// BetTable keeps track of bets and moves their stakes through the ledger
+type BetTable struct {
+	protocol.BaseTable
+
+	ledger *TransactionTable
+}
+
+// NewBetTable creates a new bet table
+func NewBetTable(ledger *TransactionTable) *BetTable {
+	return &BetTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "bets",
+			Model: &models.BetModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem, // Bets are placed and settled by games
+				Read:        protocol.AccessOwner,
+				Update:      protocol.AccessSystem,
+				Delete:      protocol.AccessSystem,
+				OwnerColumn: "user_id",
+			},
+		},
+		ledger: ledger,
+	}
+}
+
+// FindByID finds a bet by ID
+func (t *BetTable) FindByID(id interface{}) (interface{}, error) {
+	var bet models.BetModel
+	result := t.DB.First(&bet, id)
+	return &bet, result.Error
+}
+
+// FindByRoundID finds the bet a game instance placed for a round
+func (t *BetTable) FindByRoundID(roundID string, gameInstance string) (*models.BetModel, error) {
+	var bet models.BetModel
+	result := t.DB.Where("game_instance = ? AND round_id = ?", gameInstance, roundID).First(&bet)
+	return &bet, result.Error
+}
+
+// FindAll retrieves the latest bets with pagination
+func (t *BetTable) FindAll(limit, offset int) ([]interface{}, error) {
+	return t.FindAllInQuery(t.DB.Order("id desc"), limit, offset)
+}
+
+// FindAllAsUser retrieves the latest bets the user may read
+func (t *BetTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
+	query, err := t.GetPolicy().ScopeQuery(user, t.DB)
+	if err != nil {
+		return nil, err
+	}
+	return t.FindAllInQuery(query.Order("id desc"), limit, offset)
+}
+
+// PlaceBet takes the stake from the user's wallet and holds it in escrow. The bet and all of
+// its postings record the game instance ("provider/instance"). Placing the same bet twice is a no-op.
+func (t *BetTable) PlaceBet(userID uint, amountCents uint, roundID string, gameInstance string) error {
+	if roundID == "" {
+		return protocol.NewError(protocol.ErrorBetInvalid, "round ID cannot be empty")
+	}
+	if amountCents == 0 {
+		return ErrInvalidAmount
+	}
+
+	var bet models.BetModel
+	created := false
+	err := t.ledger.Atomic(func(ltx *LedgerTx) error {
+		existing, err := findBet(ltx.DB, gameInstance, roundID)
+		if err == nil {
+			bet = *existing
+			if existing.UserID != userID || existing.AmountCents != amountCents {
+				return ErrBetConflict
+			}
+			return nil
+		} else if !errors.Is(err, ErrBetNotFound) {
+			return err
+		}
+
+		var wallet models.WalletModel
+		if err := ltx.DB.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+			return err
+		}
+
+		bet = models.BetModel{
+			RoundID:      roundID,
+			UserID:       userID,
+			WalletID:     wallet.ID,
+			GameInstance: gameInstance,
+			AmountCents:  amountCents,
+			Status:       models.BetStatusPlaced,
+		}
+		if err := ltx.DB.Create(&bet).Error; err != nil {
+			return err
+		}
+
+		created = true
+		return ltx.Transfer(Transfer{
+			From:         WalletAccount(wallet.ID),
+			To:           AccountEscrow,
+			AmountCents:  amountCents,
+			Reason:       "bet",
+			GameInstance: gameInstance,
+			Reference:    roundID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if created {
+		t.PushRecordChange("create", bet.ID, &bet)
+	}
+	return nil
+}
+
+// SettleBet moves the stake to the house and pays out the winnings. Only the game instance
+// that placed the bet can settle it. Settling a bet again with the same payout is a no-op.
+func (t *BetTable) SettleBet(roundID string, payoutCents uint, gameInstance string) error {
+	var bet models.BetModel
+	changed := false
+	err := t.ledger.Atomic(func(ltx *LedgerTx) error {
+		existing, err := findBet(ltx.DB, gameInstance, roundID)
+		if err != nil {
+			return err
+		}
+		bet = *existing
+
+		switch bet.Status {
+		case models.BetStatusSettled:
+			if bet.PayoutCents != payoutCents {
+				return ErrBetClosed
+			}
+			return nil
+		case models.BetStatusRefunded:
+			return ErrBetClosed
+		}
+
+		err = ltx.Transfer(Transfer{
+			From:         AccountEscrow,
+			To:           AccountHouse,
+			AmountCents:  bet.AmountCents,
+			Reason:       "bet_settled",
+			GameInstance: bet.GameInstance,
+			Reference:    roundID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if payoutCents > 0 {
+			err = ltx.Transfer(Transfer{
+				From:         AccountHouse,
+				To:           WalletAccount(bet.WalletID),
+				AmountCents:  payoutCents,
+				Reason:       "payout",
+				GameInstance: bet.GameInstance,
+				Reference:    roundID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		changed = true
+		return closeBet(ltx.DB, &bet, models.BetStatusSettled, payoutCents)
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		t.PushRecordChange("update", bet.ID, &bet)
+	}
+	return nil
+}
+
+// RefundBet returns the stake from escrow to the player. Only the game instance
+// that placed the bet can refund it. Refunding a bet again is a no-op.
+func (t *BetTable) RefundBet(roundID string, gameInstance string) error {
+	var bet models.BetModel
+	changed := false
+	err := t.ledger.Atomic(func(ltx *LedgerTx) error {
+		existing, err := findBet(ltx.DB, gameInstance, roundID)
+		if err != nil {
+			return err
+		}
+		bet = *existing
+
+		switch bet.Status {
+		case models.BetStatusRefunded:
+			return nil
+		case models.BetStatusSettled:
+			return ErrBetClosed
+		}
+
+		err = ltx.Transfer(Transfer{
+			From:         AccountEscrow,
+			To:           WalletAccount(bet.WalletID),
+			AmountCents:  bet.AmountCents,
+			Reason:       "refund",
+			GameInstance: bet.GameInstance,
+			Reference:    roundID,
+		})
+		if err != nil {
+			return err
+		}
+
+		changed = true
+		return closeBet(ltx.DB, &bet, models.BetStatusRefunded, bet.AmountCents)
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		t.PushRecordChange("update", bet.ID, &bet)
+	}
+	return nil
+}
+
+func (t *BetTable) Repair() {
+	t.repair_dropRoundIndex()
+}
+
+// repair_dropRoundIndex removes the index that made round IDs unique across all game instances,
+// they are only unique within an instance now
+func (t *BetTable) repair_dropRoundIndex() {
+	migrator := t.DB.Migrator()
+	if !migrator.HasIndex(&models.BetModel{}, "idx_bet_models_round_id") {
+		return
+	}
+	if err := migrator.DropIndex(&models.BetModel{}, "idx_bet_models_round_id"); err != nil {
+		utils.Log("error", "casino::data", "[BetTable] [Repair] failed to drop the old round index:", err)
+		return
+	}
+	utils.Log("ok", "casino::data", "[BetTable] [Repair] dropped the old round index")
+}
+
+// FindOpenBets returns all bets that are still waiting to be settled
+func (t *BetTable) FindOpenBets() ([]models.BetModel, error) {
+	var bets []models.BetModel
+	err := t.DB.Where("status = ?", models.BetStatusPlaced).Find(&bets).Error
+	return bets, err
+}
+
+// findBet looks up the bet of a round, bets of other game instances are never found
+func findBet(db *gorm.DB, gameInstance string, roundID string) (*models.BetModel, error) {
+	var bet models.BetModel
+	err := db.Where("game_instance = ? AND round_id = ?", gameInstance, roundID).Limit(1).Find(&bet).Error
+	if err != nil {
+		return nil, err
+	}
+	if bet.ID == 0 {
+		return nil, ErrBetNotFound
+	}
+	return &bet, nil
+}
+
+func closeBet(db *gorm.DB, bet *models.BetModel, status string, payoutCents uint) error {
+	now := time.Now()
+	bet.Status = status
+	bet.PayoutCents = payoutCents
+	bet.SettledAt = &now
+
+	return db.Model(bet).Select("Status", "PayoutCents", "SettledAt").Updates(bet).Error
+}
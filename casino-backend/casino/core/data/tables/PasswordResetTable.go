@@ -0,0 +1,116 @@
+package tables
+
+import (
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Used and expired codes are kept for a week, so admins can see who issued them
+const resetCodeRetentionPeriod = time.Hour * 24 * 7
+
+var ErrInvalidResetCode = protocol.NewError(protocol.ErrorAuthInvalidResetCode, "invalid or expired reset code")
+
+// PasswordResetTable stores the password reset codes issued by admins
+type PasswordResetTable struct {
+	protocol.BaseTable
+}
+
+// NewPasswordResetTable creates a new password reset table
+func NewPasswordResetTable() *PasswordResetTable {
+	return &PasswordResetTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "password_resets",
+			Model: &models.PasswordResetModel{},
+			Policy: &protocol.TablePolicy{
+				Create: protocol.AccessSystem, // Codes are issued through auth/create_reset_code
+				Read:   protocol.AccessAdmin,
+				Update: protocol.AccessSystem,
+				Delete: protocol.AccessSystem,
+				Fields: protocol.FieldPolicies{
+					"code_hash": {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+				},
+			},
+		},
+	}
+}
+
+// FindByID finds a reset code by ID
+func (t *PasswordResetTable) FindByID(id interface{}) (interface{}, error) {
+	var reset models.PasswordResetModel
+	result := t.DB.First(&reset, id)
+	return &reset, result.Error
+}
+
+// Issue stores a new code for the user, codes issued before that haven't been used are invalidated
+func (t *PasswordResetTable) Issue(userID uint, createdBy uint, codeHash string, expiresAt time.Time) (*models.PasswordResetModel, error) {
+	reset := &models.PasswordResetModel{
+		UserID:    userID,
+		CreatedBy: createdBy,
+		CodeHash:  codeHash,
+		ExpiresAt: expiresAt,
+	}
+
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.PasswordResetModel{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.PushRecordChange("create", reset.ID, reset)
+	return reset, nil
+}
+
+// Redeem marks the code as used if it belongs to the user and is still valid, and stores the new
+// password hash in the same transaction. Every code can only be redeemed once, even by concurrent
+// requests, and it stays valid if the password can't be stored.
+func (t *PasswordResetTable) Redeem(userID uint, codeHash string, passwordHash string) error {
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.PasswordResetModel{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL AND expires_at > ?", userID, codeHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetCode
+		}
+
+		result = tx.Model(&models.UserModel{}).Where("id = ?", userID).Update("password_hash", passwordHash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (t *PasswordResetTable) Repair() {
+	t.repair_removeOldCodes()
+}
+
+// repair_removeOldCodes deletes codes that can't be used anymore
+func (t *PasswordResetTable) repair_removeOldCodes() {
+	cutoff := time.Now().Add(-resetCodeRetentionPeriod)
+	result := t.DB.Unscoped().Where("expires_at < ?", cutoff).Delete(&models.PasswordResetModel{})
+	if result.Error != nil {
+		utils.Log("error", "casino::data", "[PasswordResetTable] [Repair] failed to remove old codes:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		utils.Log("ok", "casino::data", "[PasswordResetTable] [Repair] removed", result.RowsAffected, "old code(s)")
+	}
+}
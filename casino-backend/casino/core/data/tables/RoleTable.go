@@ -0,0 +1,186 @@
+package tables
+
+import (
+	"errors"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+
+	"gorm.io/gorm"
+)
+
+// Roles that always exist. Their permissions are only set when they are
+// created, so admins can change them afterwards.
+var defaultRoles = []models.RoleModel{
+	{Name: "player", DisplayName: "Player", Permissions: []string{}},
+	{Name: "support", DisplayName: "Support", Permissions: []string{
+		"users.read", "wallets.read", "transactions.read", "bets.read", "user_roles.read",
+	}},
+	{Name: "moderator", DisplayName: "Moderator", Permissions: []string{
+		"users.read", "users.update", "wallets.read", "transactions.read", "bets.read", "user_roles.read",
+	}},
+	{Name: "game-operator", DisplayName: "Game Operator", Permissions: []string{
+		protocol.PermissionGamesManage, "bets.read", "transactions.read",
+	}},
+	{Name: "admin", DisplayName: "Admin", Permissions: []string{protocol.PermissionAll}},
+}
+
+// RoleTable provides table operations for the RoleModel
+type RoleTable struct {
+	protocol.BaseTable
+}
+
+// NewRoleTable creates a new role table
+func NewRoleTable() *RoleTable {
+	return &RoleTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "roles",
+			Model: &models.RoleModel{},
+			Policy: &protocol.TablePolicy{
+				Create: protocol.AccessAdmin,
+				Read:   protocol.AccessPublic,
+				Update: protocol.AccessAdmin,
+				Delete: protocol.AccessAdmin,
+			},
+		},
+	}
+}
+
+// FindByName finds a role by its name
+func (t *RoleTable) FindByName(name string) (*models.RoleModel, error) {
+	var role models.RoleModel
+	result := t.DB.Where("name = ?", name).First(&role)
+	return &role, result.Error
+}
+
+// Update updates a role, the role name can't be changed since it is referenced in code
+func (t *RoleTable) Update(id interface{}, data interface{}) error {
+	if fields, ok := data.(map[string]interface{}); ok {
+		fields = protocol.NormalizeFields(fields)
+		if _, ok := fields["name"]; ok {
+			return protocol.NewError(protocol.ErrorDBOperationForbidden, "the name of a role cannot be changed")
+		}
+		data = fields
+	}
+	return t.BaseTable.Update(id, data)
+}
+
+// UpdateAsUser modifies a role with permission check
+func (t *RoleTable) UpdateAsUser(user models.UserModel, id interface{}, data interface{}) error {
+	existing, err := t.FindByID(id)
+	if err != nil {
+		return protocol.RecordError(err)
+	}
+
+	if err := t.GetPolicy().CheckUpdate(user, existing, data); err != nil {
+		return err
+	}
+	return t.Update(id, data)
+}
+
+func (t *RoleTable) Repair() {
+	t.repair_addDefaultRoles()
+}
+
+// repair_addDefaultRoles creates the built-in roles if they don't exist yet
+func (t *RoleTable) repair_addDefaultRoles() {
+	for _, role := range defaultRoles {
+		_, err := t.FindByName(role.Name)
+		if err == nil {
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.Log("error", "casino::data", "[RoleTable] [Repair] failed to look up role:", role.Name, "error:", err)
+			continue
+		}
+
+		newRole := role
+		if err := t.DB.Create(&newRole).Error; err != nil {
+			utils.Log("error", "casino::data", "[RoleTable] [Repair] failed to create role:", role.Name, "error:", err)
+			continue
+		}
+
+		utils.Log("ok", "casino::data", "[RoleTable] [Repair] created default role:", role.Name)
+	}
+}
+
+// UserRoleTable assigns roles to users
+type UserRoleTable struct {
+	protocol.BaseTable
+}
+
+// NewUserRoleTable creates a new user role table
+func NewUserRoleTable() *UserRoleTable {
+	return &UserRoleTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "user_roles",
+			Model: &models.UserRoleModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessAdmin,
+				Read:        protocol.AccessOwner,
+				Update:      protocol.AccessSystem, // Assignments are created and deleted, never changed
+				Delete:      protocol.AccessAdmin,
+				OwnerColumn: "user_id",
+			},
+		},
+	}
+}
+
+// Create assigns a role to a user, assigning the same role twice is rejected
+func (t *UserRoleTable) Create(data interface{}) error {
+	userRole, ok := data.(*models.UserRoleModel)
+	if !ok {
+		return protocol.NewError(protocol.ErrorDBInvalidData, "invalid data type: expected *models.UserRoleModel")
+	}
+
+	if err := t.DB.First(&models.RoleModel{}, userRole.RoleID).Error; err != nil {
+		return protocol.NewError(protocol.ErrorDBRecordNotFound, "role does not exist")
+	}
+	if err := t.DB.First(&models.UserModel{}, userRole.UserID).Error; err != nil {
+		return protocol.NewError(protocol.ErrorDBRecordNotFound, "user does not exist")
+	}
+
+	var count int64
+	err := t.DB.Model(&models.UserRoleModel{}).Where("user_id = ? AND role_id = ?", userRole.UserID, userRole.RoleID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return protocol.NewError(protocol.ErrorDBConflict, "user already has this role")
+	}
+
+	if err := t.DB.Create(userRole).Error; err != nil {
+		return err
+	}
+
+	t.PushRecordChange("create", userRole.ID, userRole)
+	return nil
+}
+
+// CreateAsUser assigns a role with permission check
+func (t *UserRoleTable) CreateAsUser(user models.UserModel, data interface{}) error {
+	if err := t.GetPolicy().CheckCreate(user, data); err != nil {
+		return err
+	}
+
+	userRole, err := t.DecodeRecord(data)
+	if err != nil {
+		return err
+	}
+	return t.Create(userRole)
+}
+
+// FindByID finds a role assignment including its role
+func (t *UserRoleTable) FindByID(id interface{}) (interface{}, error) {
+	var userRole models.UserRoleModel
+	result := t.DB.Preload("Role").First(&userRole, id)
+	return &userRole, result.Error
+}
+
+// FindAllAsUser retrieves all role assignments the user may read
+func (t *UserRoleTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
+	query, err := t.GetPolicy().ScopeQuery(user, t.DB)
+	if err != nil {
+		return nil, err
+	}
+	return t.FindAllInQuery(query.Preload("Role"), limit, offset)
+}
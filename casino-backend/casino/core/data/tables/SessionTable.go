@@ -0,0 +1,218 @@
+package tables
+
+import (
+	"errors"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Ended sessions are kept around for a week before they are removed
+const sessionRetentionPeriod = time.Hour * 24 * 7
+
+var (
+	ErrSessionNotFound    = protocol.NewError(protocol.ErrorAuthSessionNotFound, "session not found")
+	ErrSessionRevoked     = protocol.NewError(protocol.ErrorAuthSessionRevoked, "session has been revoked")
+	ErrSessionExpired     = protocol.NewError(protocol.ErrorAuthSessionExpired, "session has expired")
+	ErrRefreshTokenReused = protocol.NewError(protocol.ErrorAuthRefreshTokenReused, "refresh token has already been used")
+)
+
+// SessionTable stores the login sessions of users
+type SessionTable struct {
+	protocol.BaseTable
+}
+
+// NewSessionTable creates a new session table
+func NewSessionTable() *SessionTable {
+	return &SessionTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "sessions",
+			Model: &models.SessionModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem, // Sessions are managed through auth/* packets
+				Read:        protocol.AccessOwner,
+				Update:      protocol.AccessSystem,
+				Delete:      protocol.AccessSystem,
+				OwnerColumn: "user_id",
+				Fields: protocol.FieldPolicies{
+					"refresh_token_hash": {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+				},
+			},
+		},
+	}
+}
+
+// FindByID finds a session by ID
+func (t *SessionTable) FindByID(id interface{}) (interface{}, error) {
+	var session models.SessionModel
+	result := t.DB.First(&session, id)
+	return &session, result.Error
+}
+
+// FindBySessionID finds a session by the ID used in tokens
+func (t *SessionTable) FindBySessionID(sessionID string) (*models.SessionModel, error) {
+	var session models.SessionModel
+	result := t.DB.Where("session_id = ?", sessionID).First(&session)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	return &session, result.Error
+}
+
+// FindActiveByUser returns all sessions of a user that can still be used
+func (t *SessionTable) FindActiveByUser(userID uint) ([]models.SessionModel, error) {
+	var sessions []models.SessionModel
+	result := t.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions)
+	return sessions, result.Error
+}
+
+// CreateSession starts a new session for a user
+func (t *SessionTable) CreateSession(userID uint, refreshTokenHash string, addr string, expiresAt time.Time) (*models.SessionModel, error) {
+	session := &models.SessionModel{
+		SessionID:        utils.GenerateID(),
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		Addr:             addr,
+		LastUsedAt:       time.Now(),
+		ExpiresAt:        expiresAt,
+	}
+
+	if err := t.DB.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	t.PushRecordChange("create", session.ID, session)
+	return session, nil
+}
+
+// Rotate replaces the refresh token of a session and extends it.
+// Presenting a refresh token that has already been replaced revokes the session,
+// since either the client or an attacker holds a stolen copy.
+func (t *SessionTable) Rotate(sessionID string, oldHash string, newHash string, expiresAt time.Time) (*models.SessionModel, error) {
+	var session models.SessionModel
+	reused := false
+
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+
+		if session.RevokedAt != nil {
+			return ErrSessionRevoked
+		}
+		if time.Now().After(session.ExpiresAt) {
+			return ErrSessionExpired
+		}
+
+		// Only swap the hash if nobody else did in the meantime
+		now := time.Now()
+		result := tx.Model(&models.SessionModel{}).
+			Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, oldHash).
+			Updates(map[string]interface{}{
+				"refresh_token_hash": newHash,
+				"last_used_at":       now,
+				"expires_at":         expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+
+		session.RefreshTokenHash = newHash
+		session.LastUsedAt = now
+		session.ExpiresAt = expiresAt
+		return nil
+	})
+
+	if reused {
+		utils.Log("warn", "casino::data", "[SessionTable] refresh token of session ", sessionID, " was reused, revoking session")
+		if revokeErr := t.Revoke(sessionID); revokeErr != nil {
+			utils.Log("error", "casino::data", "[SessionTable] failed to revoke session ", sessionID, ": ", revokeErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.PushRecordChange("update", session.ID, &session)
+	return &session, nil
+}
+
+// Revoke ends a session, access tokens of the session are rejected from now on
+func (t *SessionTable) Revoke(sessionID string) error {
+	session, err := t.FindBySessionID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := t.DB.Model(session).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	session.RevokedAt = &now
+
+	t.PushRecordChange("update", session.ID, session)
+	return nil
+}
+
+// RevokeAllOfUser ends all active sessions of a user except the one given and returns their IDs
+func (t *SessionTable) RevokeAllOfUser(userID uint, except string) ([]string, error) {
+	sessions, err := t.FindActiveByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []string{}
+	for _, session := range sessions {
+		if session.SessionID == except {
+			continue
+		}
+		if err := t.Revoke(session.SessionID); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, session.SessionID)
+	}
+	return revoked, nil
+}
+
+// IsActive checks whether a session exists and has neither expired nor been revoked
+func (t *SessionTable) IsActive(sessionID string) bool {
+	session, err := t.FindBySessionID(sessionID)
+	if err != nil {
+		return false
+	}
+	return session.IsActive()
+}
+
+func (t *SessionTable) Repair() {
+	t.repair_removeEndedSessions()
+}
+
+// repair_removeEndedSessions deletes sessions that ended a while ago
+func (t *SessionTable) repair_removeEndedSessions() {
+	cutoff := time.Now().Add(-sessionRetentionPeriod)
+	result := t.DB.Unscoped().
+		Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).
+		Delete(&models.SessionModel{})
+	if result.Error != nil {
+		utils.Log("error", "casino::data", "[SessionTable] [Repair] failed to remove ended sessions:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		utils.Log("ok", "casino::data", "[SessionTable] [Repair] removed", result.RowsAffected, "ended session(s)")
+	}
+}
@@ -0,0 +1,60 @@
+package tables
+
+import (
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+)
+
+// SigningKeyTable stores the keys of the token keyring
+type SigningKeyTable struct {
+	protocol.BaseTable
+}
+
+// NewSigningKeyTable creates a new signing key table
+func NewSigningKeyTable() *SigningKeyTable {
+	return &SigningKeyTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "signing_keys",
+			Model: &models.SigningKeyModel{},
+			Policy: &protocol.TablePolicy{
+				Create: protocol.AccessSystem, // Keys never leave the auth manager
+				Read:   protocol.AccessSystem,
+				Update: protocol.AccessSystem,
+				Delete: protocol.AccessSystem,
+			},
+		},
+	}
+}
+
+// FindUsable returns all keys that still verify tokens, oldest first
+func (t *SigningKeyTable) FindUsable() ([]models.SigningKeyModel, error) {
+	var keys []models.SigningKeyModel
+	result := t.DB.Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("id asc").
+		Find(&keys)
+	return keys, result.Error
+}
+
+// AddKey stores a new key
+func (t *SigningKeyTable) AddKey(key *models.SigningKeyModel) error {
+	return t.DB.Create(key).Error
+}
+
+// Retire stops a key from being used for signing, it keeps verifying tokens until expiresAt
+func (t *SigningKeyTable) Retire(keyID string, retiredAt time.Time, expiresAt time.Time) error {
+	return t.DB.Model(&models.SigningKeyModel{}).
+		Where("key_id = ?", keyID).
+		Updates(map[string]interface{}{
+			"retired_at": retiredAt,
+			"expires_at": expiresAt,
+		}).Error
+}
+
+// DeleteExpired removes keys that don't verify any tokens anymore
+func (t *SigningKeyTable) DeleteExpired() (int64, error) {
+	result := t.DB.Unscoped().
+		Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).
+		Delete(&models.SigningKeyModel{})
+	return result.RowsAffected, result.Error
+}
@@ -46,7 +46,7 @@ func (r *TableRegistry) Get(tableID string) (protocol.Table, error) {
 
 	table, exists := r.tables[tableID]
 	if !exists {
-		return nil, fmt.Errorf("table with ID '%s' not found", tableID)
+		return nil, protocol.Errorf(protocol.ErrorDBTableNotFound, "table with ID '%s' not found", tableID)
 	}
 
 	return table, nil
@@ -71,7 +71,7 @@ func (r *TableRegistry) Remove(tableID string) error {
 	defer r.mutex.Unlock()
 
 	if _, exists := r.tables[tableID]; !exists {
-		return fmt.Errorf("table with ID '%s' not found", tableID)
+		return protocol.Errorf(protocol.ErrorDBTableNotFound, "table with ID '%s' not found", tableID)
 	}
 
 	delete(r.tables, tableID)
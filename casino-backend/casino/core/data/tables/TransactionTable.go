@@ -0,0 +1,82 @@
+package tables
+
+import (
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"sync"
+)
+
+// TransactionTable provides read access to the ledger and posts transfers
+// between wallets and casino accounts
+type TransactionTable struct {
+	protocol.BaseTable
+
+	wallets *WalletTable
+	mu      sync.Mutex // Serializes ledger postings
+}
+
+// NewTransactionTable creates a new transaction table
+func NewTransactionTable(wallets *WalletTable) *TransactionTable {
+	return &TransactionTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "transactions",
+			Model: &models.TransactionModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem, // Postings are only created by transfers
+				Read:        protocol.AccessOwner,
+				Update:      protocol.AccessSystem,
+				Delete:      protocol.AccessSystem,
+				OwnerColumn: "wallet_id",
+				OwnerKey: func(user models.UserModel) uint {
+					return user.Wallet.ID
+				},
+			},
+		},
+		wallets: wallets,
+	}
+}
+
+// Create is not supported, postings can only be created through Transfer
+func (t *TransactionTable) Create(data interface{}) error {
+	return protocol.NewError(protocol.ErrorDBOperationForbidden, "transactions can only be created through a transfer")
+}
+
+// Update is not supported, the ledger is append-only
+func (t *TransactionTable) Update(id interface{}, data interface{}) error {
+	return protocol.NewError(protocol.ErrorDBOperationForbidden, "transactions cannot be modified")
+}
+
+// Delete is not supported, the ledger is append-only
+func (t *TransactionTable) Delete(id interface{}) error {
+	return protocol.NewError(protocol.ErrorDBOperationForbidden, "transactions cannot be deleted")
+}
+
+// FindByID finds a posting by ID
+func (t *TransactionTable) FindByID(id interface{}) (interface{}, error) {
+	var transaction models.TransactionModel
+	result := t.DB.First(&transaction, id)
+	return &transaction, result.Error
+}
+
+// FindAll retrieves the latest postings with pagination
+func (t *TransactionTable) FindAll(limit, offset int) ([]interface{}, error) {
+	return t.FindAllInQuery(t.DB.Order("id desc"), limit, offset)
+}
+
+// FindByWallet retrieves the latest postings of a wallet with pagination
+func (t *TransactionTable) FindByWallet(walletID uint, limit, offset int) ([]interface{}, error) {
+	return t.FindAllInQuery(t.DB.Where("wallet_id = ?", walletID).Order("id desc"), limit, offset)
+}
+
+// FindAllAsUser retrieves the latest postings the user may read
+func (t *TransactionTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
+	query, err := t.GetPolicy().ScopeQuery(user, t.DB)
+	if err != nil {
+		return nil, err
+	}
+	return t.FindAllInQuery(query.Order("id desc"), limit, offset)
+}
+
+func (t *TransactionTable) Repair() {
+	t.repair_addOpeningBalances()
+}
@@ -0,0 +1,215 @@
+package tables
+
+import (
+	"errors"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTwoFactorNotFound       = protocol.NewError(protocol.ErrorAuth2FANotSetUp, "two-factor authentication is not set up")
+	ErrTwoFactorAlreadyEnabled = protocol.NewError(protocol.ErrorAuth2FAAlreadyEnabled, "two-factor authentication is already enabled")
+	ErrCodeAlreadyUsed         = protocol.NewError(protocol.ErrorAuth2FAInvalidCode, "code has already been used")
+	ErrInvalidRecoveryCode     = protocol.NewError(protocol.ErrorAuth2FAInvalidCode, "invalid recovery code")
+)
+
+// TwoFactorTable stores the TOTP secrets of users
+type TwoFactorTable struct {
+	protocol.BaseTable
+}
+
+// NewTwoFactorTable creates a new two-factor table
+func NewTwoFactorTable() *TwoFactorTable {
+	return &TwoFactorTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "two_factor",
+			Model: &models.TwoFactorModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem, // Managed through auth/2fa/* packets
+				Read:        protocol.AccessOwner,
+				Update:      protocol.AccessSystem,
+				Delete:      protocol.AccessSystem,
+				OwnerColumn: "user_id",
+				Fields: protocol.FieldPolicies{
+					"secret":         {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+					"last_used_step": {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+				},
+			},
+		},
+	}
+}
+
+// FindByID finds a two-factor entry by ID
+func (t *TwoFactorTable) FindByID(id interface{}) (interface{}, error) {
+	var entry models.TwoFactorModel
+	result := t.DB.First(&entry, id)
+	return &entry, result.Error
+}
+
+// FindByUser finds the two-factor entry of a user
+func (t *TwoFactorTable) FindByUser(userID uint) (*models.TwoFactorModel, error) {
+	var entry models.TwoFactorModel
+	result := t.DB.Where("user_id = ?", userID).First(&entry)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrTwoFactorNotFound
+	}
+	return &entry, result.Error
+}
+
+// IsEnabled checks whether the user has confirmed two-factor authentication
+func (t *TwoFactorTable) IsEnabled(userID uint) bool {
+	entry, err := t.FindByUser(userID)
+	return err == nil && entry.Enabled
+}
+
+// SavePending stores a new secret that still has to be confirmed, replacing an earlier pending one
+func (t *TwoFactorTable) SavePending(userID uint, sealedSecret []byte) error {
+	entry, err := t.FindByUser(userID)
+	if errors.Is(err, ErrTwoFactorNotFound) {
+		entry = &models.TwoFactorModel{UserID: userID, Secret: sealedSecret}
+		if err := t.DB.Create(entry).Error; err != nil {
+			return err
+		}
+		t.PushRecordChange("create", entry.ID, entry)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if entry.Enabled {
+		return ErrTwoFactorAlreadyEnabled
+	}
+
+	entry.Secret = sealedSecret
+	entry.LastUsedStep = 0
+	if err := t.DB.Save(entry).Error; err != nil {
+		return err
+	}
+	t.PushRecordChange("update", entry.ID, entry)
+	return nil
+}
+
+// Enable confirms the pending secret of a user
+func (t *TwoFactorTable) Enable(userID uint, step int64) error {
+	now := time.Now()
+	result := t.DB.Model(&models.TwoFactorModel{}).
+		Where("user_id = ? AND enabled = ?", userID, false).
+		Updates(map[string]interface{}{"enabled": true, "enabled_at": now, "last_used_step": step})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorAlreadyEnabled
+	}
+
+	if entry, err := t.FindByUser(userID); err == nil {
+		t.PushRecordChange("update", entry.ID, entry)
+	}
+	return nil
+}
+
+// UseStep records that the code of a time step has been used, so it can't be replayed
+func (t *TwoFactorTable) UseStep(userID uint, step int64) error {
+	result := t.DB.Model(&models.TwoFactorModel{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCodeAlreadyUsed
+	}
+	return nil
+}
+
+// Disable removes two-factor authentication and all recovery codes of a user
+func (t *TwoFactorTable) Disable(userID uint) error {
+	entry, err := t.FindByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	err = t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(entry).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCodeModel{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	t.PushRecordChange("delete", entry.ID, entry)
+	return nil
+}
+
+// RecoveryCodeTable stores the hashed recovery codes of users
+type RecoveryCodeTable struct {
+	protocol.BaseTable
+}
+
+// NewRecoveryCodeTable creates a new recovery code table
+func NewRecoveryCodeTable() *RecoveryCodeTable {
+	return &RecoveryCodeTable{
+		BaseTable: protocol.BaseTable{
+			ID:    "recovery_codes",
+			Model: &models.RecoveryCodeModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem,
+				Read:        protocol.AccessOwner, // Users can see how many codes they have left
+				Update:      protocol.AccessSystem,
+				Delete:      protocol.AccessSystem,
+				OwnerColumn: "user_id",
+				Fields: protocol.FieldPolicies{
+					"code_hash": {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+				},
+			},
+		},
+	}
+}
+
+// FindByID finds a recovery code by ID
+func (t *RecoveryCodeTable) FindByID(id interface{}) (interface{}, error) {
+	var code models.RecoveryCodeModel
+	result := t.DB.First(&code, id)
+	return &code, result.Error
+}
+
+// Replace swaps all recovery codes of a user for new ones
+func (t *RecoveryCodeTable) Replace(userID uint, codeHashes []string) error {
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCodeModel{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]models.RecoveryCodeModel, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.RecoveryCodeModel{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Consume uses up a recovery code of the user
+func (t *RecoveryCodeTable) Consume(userID uint, codeHash string) error {
+	result := t.DB.Model(&models.RecoveryCodeModel{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+// CountUnused returns how many recovery codes the user has left
+func (t *RecoveryCodeTable) CountUnused(userID uint) (int64, error) {
+	var count int64
+	result := t.DB.Model(&models.RecoveryCodeModel{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count, result.Error
+}
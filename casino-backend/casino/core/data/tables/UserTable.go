@@ -9,6 +9,8 @@ import (
 	"gorm.io/gorm"
 )
 
+var ErrUsernameTaken = protocol.NewError(protocol.ErrorAuthUserExists, "username already exists")
+
 // SafeUserModel represents a user model with sensitive information removed
 type SafeUserModel struct {
 	ID          uint
@@ -16,21 +18,41 @@ type SafeUserModel struct {
 	DisplayName string
 	JoinedAt    string
 	IsAdmin     bool
+	Roles       []string
 	Wallet      models.WalletModel
 }
 
 // UserTable provides table operations for the UserModel
 type UserTable struct {
 	protocol.BaseTable
+
+	ledger             *TransactionTable
+	startingBonusCents uint
 }
 
 // NewUserTable creates a new user table
-func NewUserTable() *UserTable {
+func NewUserTable(ledger *TransactionTable, startingBonusCents uint) *UserTable {
 	return &UserTable{
 		BaseTable: protocol.BaseTable{
 			ID:    "users",
 			Model: &models.UserModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessAdmin, // Players sign up through auth/register
+				Read:        protocol.AccessPublic,
+				Update:      protocol.AccessOwner,
+				Delete:      protocol.AccessAdmin,
+				OwnerColumn: "id",
+				Fields: protocol.FieldPolicies{
+					"username":      {Read: protocol.AccessPublic, Write: protocol.AccessOwner},
+					"display_name":  {Read: protocol.AccessPublic, Write: protocol.AccessOwner},
+					"is_admin":      {Read: protocol.AccessPublic, Write: protocol.AccessSystem}, // Use roles instead
+					"joined_at":     {Read: protocol.AccessPublic, Write: protocol.AccessSystem},
+					"password_hash": {Read: protocol.AccessSystem, Write: protocol.AccessSystem},
+				},
+			},
 		},
+		ledger:             ledger,
+		startingBonusCents: startingBonusCents,
 	}
 }
 
@@ -38,47 +60,60 @@ func NewUserTable() *UserTable {
 func (t *UserTable) Create(data interface{}) error {
 	user, ok := data.(*models.UserModel)
 	if !ok {
-		return errors.New("invalid data type: expected *models.UserModel")
+		return protocol.NewError(protocol.ErrorDBInvalidData, "invalid data type: expected *models.UserModel")
 	}
 
 	// Check if username already exists
 	_, err := t.FindByUsername(user.Username)
 	if err == nil {
-		return errors.New("username already exists")
+		return ErrUsernameTaken
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return err
 	}
 
 	err = t.DB.Create(user).Error
-	if err == nil {
-		t.PushRecordChange("create", user.ID, toSafeUser(user))
+	if err != nil {
+		return err
+	}
+
+	// gorm skips empty associations, so make sure every user gets a wallet
+	if user.Wallet.ID == 0 {
+		user.Wallet = models.WalletModel{UserID: user.ID}
+		if err := t.DB.Create(&user.Wallet).Error; err != nil {
+			return err
+		}
 	}
 
-	return err
+	t.PushRecordChange("create", user.ID, toSafeUser(user))
+	return nil
 }
 
 // FindByID finds a user by ID
 func (t *UserTable) FindByID(id interface{}) (interface{}, error) {
 	var user models.UserModel
-	result := t.DB.Preload("Wallet").First(&user, id)
+	result := t.DB.Preload("Wallet").Preload("Roles.Role").First(&user, id)
 	return &user, result.Error
 }
 
 // FindByUsername finds a user by username
 func (t *UserTable) FindByUsername(username string) (*models.UserModel, error) {
 	var user models.UserModel
-	result := t.DB.Where("username = ?", username).Preload("Wallet").First(&user)
+	result := t.DB.Where("username = ?", username).Preload("Wallet").Preload("Roles.Role").First(&user)
 	return &user, result.Error
 }
 
 // FindAll retrieves all users with pagination
 func (t *UserTable) FindAll(limit, offset int) ([]interface{}, error) {
+	return t.findAll(t.DB, limit, offset)
+}
+
+func (t *UserTable) findAll(query *gorm.DB, limit, offset int) ([]interface{}, error) {
 	if limit <= 0 {
 		limit = 10
 	}
 
 	var users []models.UserModel
-	result := t.DB.Limit(limit).Offset(offset).Preload("Wallet").Find(&users)
+	result := query.Limit(limit).Offset(offset).Preload("Wallet").Preload("Roles.Role").Find(&users)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -95,7 +130,15 @@ func (t *UserTable) FindAll(limit, offset int) ([]interface{}, error) {
 
 // CreateAsUser implements user-based permission check for creating a user
 func (t *UserTable) CreateAsUser(user models.UserModel, data interface{}) error {
-	return t.Create(data)
+	if err := t.GetPolicy().CheckCreate(user, data); err != nil {
+		return err
+	}
+
+	newUser, err := t.DecodeRecord(data)
+	if err != nil {
+		return err
+	}
+	return t.Create(newUser)
 }
 
 // toSafeUser converts a UserModel to a SafeUserModel by removing sensitive information
@@ -106,15 +149,21 @@ func toSafeUser(user *models.UserModel) *SafeUserModel {
 		DisplayName: user.DisplayName,
 		JoinedAt:    user.JoinedAt.Format("2006-01-02 15:04:05"),
 		IsAdmin:     user.IsAdmin,
+		Roles:       user.RoleNames(),
 		Wallet:      user.Wallet,
 	}
 }
 
+// GetViewType returns the type users are returned as by AsUser operations
+func (t *UserTable) GetViewType() interface{} {
+	return &SafeUserModel{}
+}
+
 // FindByIDAsUser retrieves a user by ID with permission check and removes sensitive data
 func (t *UserTable) FindByIDAsUser(user models.UserModel, id interface{}) (interface{}, error) {
 	foundUser, err := t.FindByID(id)
 	if err != nil {
-		return nil, err
+		return nil, protocol.RecordError(err)
 	}
 
 	userModel, ok := foundUser.(*models.UserModel)
@@ -122,12 +171,21 @@ func (t *UserTable) FindByIDAsUser(user models.UserModel, id interface{}) (inter
 		return nil, errors.New("invalid user model type")
 	}
 
+	if err := t.GetPolicy().CheckRead(user, userModel); err != nil {
+		return nil, err
+	}
+
 	return toSafeUser(userModel), nil
 }
 
 // FindAllAsUser retrieves all users with permission check and removes sensitive data
 func (t *UserTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
-	users, err := t.FindAll(limit, offset)
+	query, err := t.GetPolicy().ScopeQuery(user, t.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := t.findAll(query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -145,81 +203,102 @@ func (t *UserTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]i
 	return safeUsers, nil
 }
 
-// UpdateAsUser modifies a user with permission check
+// UpdateAsUser modifies a user with permission check.
+// Users can update their own data, but only the fields the field policies allow.
 func (t *UserTable) UpdateAsUser(user models.UserModel, id interface{}, data interface{}) error {
-	// Users can update their own data
-	userID, ok := id.(uint)
-	if !ok {
-		return errors.New("invalid ID format")
-	}
-
-	if user.ID != userID && !user.IsAdmin {
-		return errors.New("permission denied: you can only update your own user data")
+	userID, err := protocol.ParseID(id)
+	if err != nil {
+		return err
 	}
 
-	// If updating the user's password, ensure we have a new hash
-	userData, ok := data.(*models.UserModel)
-	if !ok {
-		return errors.New("invalid data type: expected *models.UserModel")
+	existing, err := t.FindByID(userID)
+	if err != nil {
+		return protocol.RecordError(err)
 	}
 
-	// If regular user is trying to change their username, apply special validation
-	if userData.Username != "" && userData.Username != user.Username {
-		// Check if new username already exists
-		_, err := t.FindByUsername(userData.Username)
-		if err == nil {
-			return errors.New("username already exists")
-		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return err
-		}
+	if err := t.GetPolicy().CheckUpdate(user, existing, data); err != nil {
+		return err
 	}
 
-	return t.Update(id, userData)
+	return t.Update(userID, data)
 }
 
 // DeleteAsUser removes a user with permission check
 func (t *UserTable) DeleteAsUser(user models.UserModel, id interface{}) error {
-	// Only admins can delete users
-	if !user.IsAdmin {
-		return errors.New("permission denied: only admins can delete users")
+	userID, err := protocol.ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	existing, err := t.FindByID(userID)
+	if err != nil {
+		return protocol.RecordError(err)
+	}
+
+	if err := t.GetPolicy().CheckDelete(user, existing); err != nil {
+		return err
 	}
 
-	return t.Delete(id)
+	return t.Delete(userID)
 }
 
-// Update updates a user
+// Update updates a user, data can either be a *models.UserModel or a partial field map
 func (t *UserTable) Update(id interface{}, data interface{}) error {
-	userData, ok := data.(*models.UserModel)
-	if !ok {
-		return errors.New("invalid data type: expected *models.UserModel")
+	userID, err := protocol.ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	var username string
+	switch userData := data.(type) {
+	case *models.UserModel:
+		username = userData.Username
+	case map[string]interface{}:
+		userData = protocol.NormalizeFields(userData)
+		username, _ = userData["username"].(string)
+		data = userData
+	default:
+		return protocol.NewError(protocol.ErrorDBInvalidData, "invalid data type: expected *models.UserModel or map[string]interface{}")
 	}
 
 	// Don't allow changing username to one that already exists
-	if userData.Username != "" {
-		existing, err := t.FindByUsername(userData.Username)
-		if err == nil && existing.ID != id {
-			return errors.New("username already exists")
-		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+	if username != "" {
+		existing, err := t.FindByUsername(username)
+		if err == nil && existing.ID != userID {
+			return ErrUsernameTaken
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
 	}
 
 	// Perform the update
-	err := t.DB.Model(&models.UserModel{}).Where("id = ?", id).Updates(userData).Error
+	err = t.DB.Model(&models.UserModel{}).Where("id = ?", userID).Updates(data).Error
 	if err != nil {
 		return err
 	}
 
 	// Fetch the updated row
 	var updatedUser models.UserModel
-	err = t.DB.First(&updatedUser, "id = ?", id).Error
+	err = t.DB.Preload("Wallet").Preload("Roles.Role").First(&updatedUser, "id = ?", userID).Error
 	if err != nil {
 		return err
 	}
 
-	// Push the change with the updated record
-	t.PushRecordChange("update", id, &updatedUser)
+	// Push the change with the updated record, without sensitive information
+	t.PushRecordChange("update", userID, toSafeUser(&updatedUser))
+
+	return nil
+}
 
+// SetPasswordHash replaces the password of a user, it is never part of a regular update
+func (t *UserTable) SetPasswordHash(userID uint, hash string) error {
+	result := t.DB.Model(&models.UserModel{}).Where("id = ?", userID).Update("password_hash", hash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
@@ -262,7 +341,35 @@ func (t *UserTable) repair_addWallets() {
 	}
 }
 
-// repair_addStartingBonus gives a starting bonus of $1000 to users who haven't received it yet
+// GrantStartingBonus credits the starting bonus to a wallet that has not received it yet
+func (t *UserTable) GrantStartingBonus(walletID uint, amountCents uint) error {
+	return t.ledger.Atomic(func(ltx *LedgerTx) error {
+		// Mark the bonus as received first, so it can never be paid twice
+		result := ltx.DB.Model(&models.WalletModel{}).
+			Where("id = ? AND received_starting_bonus = ?", walletID, false).
+			Update("received_starting_bonus", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return protocol.NewError(protocol.ErrorWalletBonusReceived, "starting bonus has already been received")
+		}
+
+		if amountCents == 0 {
+			// Bonuses are disabled, but the wallet still counts as handled
+			return nil
+		}
+
+		return ltx.Transfer(Transfer{
+			From:        AccountBonus,
+			To:          WalletAccount(walletID),
+			AmountCents: amountCents,
+			Reason:      "starting_bonus",
+		})
+	})
+}
+
+// repair_addStartingBonus gives the starting bonus to users who haven't received it yet
 func (t *UserTable) repair_addStartingBonus() {
 	utils.Log("info", "casino::data", "[UserTable] [Repair] checking for users who need starting bonus...")
 
@@ -276,18 +383,13 @@ func (t *UserTable) repair_addStartingBonus() {
 
 	// Process each wallet
 	for _, wallet := range wallets {
-		// Add $1000 in cents (100000 cents)
-		wallet.NetworthCents += 100000
-		wallet.ReceivedStartingBonus = true
-
-		// Update the wallet
-		err := t.DB.Save(&wallet).Error
+		err := t.GrantStartingBonus(wallet.ID, t.startingBonusCents)
 		if err != nil {
 			utils.Log("error", "casino::data", "[UserTable] [Repair] failed to update wallet for user:", wallet.UserID, "error:", err)
 			continue
 		}
 
-		utils.Log("ok", "casino::data", "[UserTable] [Repair] added $1000 starting bonus to user:", wallet.UserID)
+		utils.Log("ok", "casino::data", "[UserTable] [Repair] added starting bonus of", t.startingBonusCents, "cents to user:", wallet.UserID)
 	}
 
 	utils.Log("info", "casino::data", "[UserTable] [Repair] finished checking starting bonuses. Added to", len(wallets), "users.")
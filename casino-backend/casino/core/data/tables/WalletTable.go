@@ -1,8 +1,6 @@
 package tables
 
 import (
-	"errors"
-	"fmt"
 	"jhgambling/protocol"
 	"jhgambling/protocol/models"
 )
@@ -18,6 +16,19 @@ func NewWalletTable() *WalletTable {
 		BaseTable: protocol.BaseTable{
 			ID:    "wallets",
 			Model: &models.WalletModel{},
+			Policy: &protocol.TablePolicy{
+				Create:      protocol.AccessSystem, // Wallets are created together with their user
+				Read:        protocol.AccessPublic,
+				Update:      protocol.AccessOwner,
+				Delete:      protocol.AccessAdmin,
+				OwnerColumn: "user_id",
+				Fields: protocol.FieldPolicies{
+					"user_id":                 {Read: protocol.AccessPublic, Write: protocol.AccessSystem},
+					"received_starting_bonus": {Read: protocol.AccessPublic, Write: protocol.AccessSystem},
+					// Balances only change through ledger postings
+					"networth_cents": {Read: protocol.AccessPublic, Write: protocol.AccessSystem},
+				},
+			},
 		},
 	}
 }
@@ -26,7 +37,7 @@ func NewWalletTable() *WalletTable {
 func (t *WalletTable) Create(data interface{}) error {
 	wallet, ok := data.(*models.WalletModel)
 	if !ok {
-		return errors.New("invalid data type: expected *models.WalletModel")
+		return protocol.NewError(protocol.ErrorDBInvalidData, "invalid data type: expected *models.WalletModel")
 	}
 
 	t.PushRecordChange("create", nil, wallet)
@@ -63,100 +74,87 @@ func (t *WalletTable) FindAll(limit, offset int) ([]interface{}, error) {
 	return items, nil
 }
 
-// CreateAsUser implements user-based permission check for creating a user
+// CreateAsUser checks the table policy before creating a wallet
 func (t *WalletTable) CreateAsUser(user models.UserModel, data interface{}) error {
-	return errors.New("you cant create a new wallet")
+	if err := t.GetPolicy().CheckCreate(user, data); err != nil {
+		return err
+	}
+
+	wallet, err := t.DecodeRecord(data)
+	if err != nil {
+		return err
+	}
+	return t.Create(wallet)
 }
 
-// FindByIDAsUser retrieves a wallet by ID
+// FindByIDAsUser retrieves a wallet by ID with permission check
 func (t *WalletTable) FindByIDAsUser(user models.UserModel, id interface{}) (interface{}, error) {
 	foundWallet, err := t.FindByID(id)
 	if err != nil {
-		return nil, err
+		return nil, protocol.RecordError(err)
 	}
 
-	return foundWallet, nil
+	policy := t.GetPolicy()
+	if err := policy.CheckRead(user, foundWallet); err != nil {
+		return nil, err
+	}
+	return policy.Mask(user, foundWallet)
 }
 
-// FindAllAsUser retrieves all wallets
+// FindAllAsUser retrieves all wallets the user may read
 func (t *WalletTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
-	wallets, err := t.FindAll(limit, offset)
+	policy := t.GetPolicy()
+	query, err := policy.ScopeQuery(user, t.DB)
 	if err != nil {
 		return nil, err
 	}
 
-	return wallets, nil
+	wallets, err := t.FindAllInQuery(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.MaskAll(policy, user, wallets)
 }
 
-// UpdateAsUser modifies a wallet with permission check
+// UpdateAsUser modifies a wallet with permission check.
+// Only fields the user is allowed to write according to the field policies can be changed.
 func (t *WalletTable) UpdateAsUser(user models.UserModel, id interface{}, data interface{}) error {
-	// Users can update their own wallet
-	idFloat, ok := id.(float64)
-	if !ok {
-		fmt.Println("well still no")
-		return errors.New("invalid ID format: expected float64")
+	walletID, err := protocol.ParseID(id)
+	if err != nil {
+		return err
 	}
-	walletID := uint(idFloat)
 
-	if user.Wallet.ID != walletID && !user.IsAdmin {
-		fmt.Println("permission error")
-		return errors.New("permission denied: you can only update your own wallet data")
+	existing, err := t.FindByID(walletID)
+	if err != nil {
+		return protocol.RecordError(err)
 	}
 
-	// Find existing wallet first
-	var existingWallet models.WalletModel
-	if err := t.DB.First(&existingWallet, walletID).Error; err != nil {
+	if err := t.GetPolicy().CheckUpdate(user, existing, data); err != nil {
 		return err
 	}
-
-	// Handle different possible input types
-	switch actualData := data.(type) {
-	case *models.WalletModel:
-		// Complete wallet model provided
-		// Preserve starting bonus status if it's true
-		if existingWallet.ReceivedStartingBonus {
-			actualData.ReceivedStartingBonus = true
-		}
-		return t.Update(walletID, actualData)
-
-	case map[string]interface{}:
-		// Handle partial updates with map
-		// Make sure we don't override the starting bonus if it's already received
-		if existingWallet.ReceivedStartingBonus {
-			actualData["received_starting_bonus"] = true
-		}
-
-		// Apply the updates directly
-		if err := t.DB.Model(&models.WalletModel{}).Where("id = ?", walletID).Updates(actualData).Error; err != nil {
-			return err
-		}
-
-		// Fetch the updated wallet to push changes
-		var updatedWallet models.WalletModel
-		if err := t.DB.First(&updatedWallet, walletID).Error; err != nil {
-			return err
-		}
-
-		// Push the change notification
-		t.PushRecordChange("update", walletID, &updatedWallet)
-		return nil
-
-	default:
-		return errors.New("invalid data type: expected *models.WalletModel or map[string]interface{}")
-	}
+	return t.Update(walletID, data)
 }
 
 // DeleteAsUser removes a wallet with permission check
 func (t *WalletTable) DeleteAsUser(user models.UserModel, id interface{}) error {
-	// Only admins can delete wallets
-	if !user.IsAdmin {
-		return errors.New("permission denied: only admins can delete wallets")
+	walletID, err := protocol.ParseID(id)
+	if err != nil {
+		return err
 	}
 
-	return t.Delete(id)
+	existing, err := t.FindByID(walletID)
+	if err != nil {
+		return protocol.RecordError(err)
+	}
+
+	if err := t.GetPolicy().CheckDelete(user, existing); err != nil {
+		return err
+	}
+	return t.Delete(walletID)
 }
 
-// Update updates a wallet and triggers notifications
+// Update updates a wallet and triggers notifications.
+// The balance is never changed here, it is maintained by the ledger (see TransactionTable.Transfer).
 func (t *WalletTable) Update(id interface{}, data interface{}) error {
 	var err error
 
@@ -164,12 +162,12 @@ func (t *WalletTable) Update(id interface{}, data interface{}) error {
 	switch actualData := data.(type) {
 	case *models.WalletModel:
 		// Update the wallet with complete model
-		err = t.DB.Model(&models.WalletModel{}).Where("id = ?", id).Updates(actualData).Error
+		err = t.DB.Model(&models.WalletModel{}).Where("id = ?", id).Omit("NetworthCents").Updates(actualData).Error
 	case map[string]interface{}:
 		// Update with partial data
-		err = t.DB.Model(&models.WalletModel{}).Where("id = ?", id).Updates(actualData).Error
+		err = t.DB.Model(&models.WalletModel{}).Where("id = ?", id).Omit("NetworthCents").Updates(protocol.NormalizeFields(actualData)).Error
 	default:
-		return errors.New("invalid data type: expected *models.WalletModel or map[string]interface{}")
+		return protocol.NewError(protocol.ErrorDBInvalidData, "invalid data type: expected *models.WalletModel or map[string]interface{}")
 	}
 
 	if err != nil {
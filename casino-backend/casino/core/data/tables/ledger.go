@@ -0,0 +1,351 @@
+package tables
+
+import (
+	"fmt"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Casino accounts are the counterparties of wallets in the ledger. Their
+// balances may go negative, e.g. the bonus account after paying out bonuses.
+const (
+	AccountHouse      = "casino:house"      // Wins and losses of the casino
+	AccountBonus      = "casino:bonus"      // Starting bonuses and promotions
+	AccountOpening    = "casino:opening"    // Balances that existed before the ledger
+	AccountAdjustment = "casino:adjustment" // Manual corrections made by admins
+)
+
+var (
+	ErrInsufficientFunds = protocol.NewError(protocol.ErrorWalletInsufficientFunds, "insufficient funds")
+	ErrInvalidAccount    = protocol.NewError(protocol.ErrorWalletInvalidAccount, "invalid ledger account")
+	ErrInvalidAmount     = protocol.NewError(protocol.ErrorWalletInvalidAmount, "amount has to be greater than zero")
+)
+
+// WalletAccount returns the ledger account of a wallet
+func WalletAccount(walletID uint) string {
+	return fmt.Sprintf("wallet:%d", walletID)
+}
+
+// parseAccount validates a ledger account and returns the wallet ID for wallet accounts
+func parseAccount(account string) (uint, error) {
+	if strings.HasPrefix(account, "casino:") && len(account) > len("casino:") {
+		return 0, nil
+	}
+
+	if id, ok := strings.CutPrefix(account, "wallet:"); ok {
+		walletID, err := strconv.ParseUint(id, 10, 64)
+		if err == nil && walletID != 0 {
+			return uint(walletID), nil
+		}
+	}
+
+	return 0, ErrInvalidAccount
+}
+
+// Transfer describes a movement of money from one ledger account to another
+type Transfer struct {
+	From         string
+	To           string
+	AmountCents  uint
+	Reason       string
+	GameInstance string
+	Reference    string
+}
+
+// LedgerTx is handed to functions running inside a ledger transaction.
+// DB has to be used for all other queries that should be part of the transaction.
+type LedgerTx struct {
+	DB *gorm.DB
+
+	postings []models.TransactionModel
+}
+
+// Atomic runs fn inside a single database transaction. All transfers made
+// through the LedgerTx are rolled back if fn returns an error, otherwise
+// subscription updates are pushed after the commit.
+func (t *TransactionTable) Atomic(fn func(ltx *LedgerTx) error) error {
+	ltx := &LedgerTx{}
+	if err := t.transaction(ltx, fn); err != nil {
+		return err
+	}
+
+	// Pushed once the ledger is unlocked, so a full subscription channel doesn't hold up other transfers
+	t.pushPostings(ltx.postings)
+	return nil
+}
+
+// transaction runs fn in a database transaction while no other transfer can be posted
+func (t *TransactionTable) transaction(ltx *LedgerTx, fn func(ltx *LedgerTx) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		ltx.DB = tx
+		return fn(ltx)
+	})
+}
+
+// Transfer posts a single transfer in its own database transaction
+func (t *TransactionTable) Transfer(transfer Transfer) error {
+	return t.Atomic(func(ltx *LedgerTx) error {
+		return ltx.Transfer(transfer)
+	})
+}
+
+// Transfer debits the source account and credits the target account. Wallet
+// balances are updated alongside and may never drop below zero.
+func (ltx *LedgerTx) Transfer(transfer Transfer) error {
+	if transfer.AmountCents == 0 {
+		return ErrInvalidAmount
+	}
+	if transfer.From == transfer.To {
+		return protocol.NewError(protocol.ErrorWalletInvalidAccount, "cannot transfer to the same account")
+	}
+
+	transferID := utils.GenerateID()
+	amount := int64(transfer.AmountCents)
+
+	if err := ltx.post(transfer.From, -amount, transferID, transfer.To, transfer); err != nil {
+		return err
+	}
+	return ltx.post(transfer.To, amount, transferID, transfer.From, transfer)
+}
+
+// Balance returns the current balance of any ledger account
+func (ltx *LedgerTx) Balance(account string) (int64, error) {
+	walletID, err := parseAccount(account)
+	if err != nil {
+		return 0, err
+	}
+
+	if walletID != 0 {
+		var wallet models.WalletModel
+		if err := ltx.DB.First(&wallet, walletID).Error; err != nil {
+			return 0, err
+		}
+		return int64(wallet.NetworthCents), nil
+	}
+
+	return ltx.lastBalance(account)
+}
+
+func (ltx *LedgerTx) post(account string, amount int64, transferID string, counterparty string, transfer Transfer) error {
+	walletID, err := parseAccount(account)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, account)
+	}
+
+	var balance int64
+	if walletID != 0 {
+		var wallet models.WalletModel
+		if err := ltx.DB.First(&wallet, walletID).Error; err != nil {
+			return err
+		}
+		if err := ltx.ensureOpeningBalance(&wallet); err != nil {
+			return err
+		}
+
+		balance = int64(wallet.NetworthCents) + amount
+		if balance < 0 {
+			return ErrInsufficientFunds
+		}
+
+		err := ltx.DB.Model(&models.WalletModel{}).Where("id = ?", walletID).Update("networth_cents", uint(balance)).Error
+		if err != nil {
+			return err
+		}
+	} else {
+		last, err := ltx.lastBalance(account)
+		if err != nil {
+			return err
+		}
+		balance = last + amount
+	}
+
+	return ltx.appendPosting(models.TransactionModel{
+		TransferID:   transferID,
+		Account:      account,
+		WalletID:     walletID,
+		AmountCents:  amount,
+		BalanceCents: balance,
+		Reason:       transfer.Reason,
+		Counterparty: counterparty,
+		GameInstance: transfer.GameInstance,
+		Reference:    transfer.Reference,
+	})
+}
+
+// ensureOpeningBalance records the balance of wallets that existed before
+// the ledger, so the postings of every wallet add up to its balance
+func (ltx *LedgerTx) ensureOpeningBalance(wallet *models.WalletModel) error {
+	if wallet.NetworthCents == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := ltx.DB.Model(&models.TransactionModel{}).Where("wallet_id = ?", wallet.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	openingBalance, err := ltx.lastBalance(AccountOpening)
+	if err != nil {
+		return err
+	}
+
+	transferID := utils.GenerateID()
+	amount := int64(wallet.NetworthCents)
+	walletAccount := WalletAccount(wallet.ID)
+
+	err = ltx.appendPosting(models.TransactionModel{
+		TransferID:   transferID,
+		Account:      AccountOpening,
+		AmountCents:  -amount,
+		BalanceCents: openingBalance - amount,
+		Reason:       "opening_balance",
+		Counterparty: walletAccount,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ltx.appendPosting(models.TransactionModel{
+		TransferID:   transferID,
+		Account:      walletAccount,
+		WalletID:     wallet.ID,
+		AmountCents:  amount,
+		BalanceCents: amount,
+		Reason:       "opening_balance",
+		Counterparty: AccountOpening,
+	})
+}
+
+func (ltx *LedgerTx) lastBalance(account string) (int64, error) {
+	var last models.TransactionModel
+	err := ltx.DB.Where("account = ?", account).Order("id desc").Limit(1).Find(&last).Error
+	return last.BalanceCents, err
+}
+
+func (ltx *LedgerTx) appendPosting(posting models.TransactionModel) error {
+	if err := ltx.DB.Create(&posting).Error; err != nil {
+		return err
+	}
+	ltx.postings = append(ltx.postings, posting)
+	return nil
+}
+
+// pushPostings notifies subscribers about new postings and the wallets they changed
+func (t *TransactionTable) pushPostings(postings []models.TransactionModel) {
+	changedWallets := map[uint]bool{}
+	for i := range postings {
+		t.PushRecordChange("create", postings[i].ID, &postings[i])
+		if postings[i].WalletID != 0 {
+			changedWallets[postings[i].WalletID] = true
+		}
+	}
+
+	for walletID := range changedWallets {
+		var wallet models.WalletModel
+		if err := t.DB.First(&wallet, walletID).Error; err != nil {
+			utils.Log("warn", "casino::data", "[ledger] failed to load changed wallet ", walletID, ": ", err)
+			continue
+		}
+		t.wallets.PushRecordChange("update", walletID, &wallet)
+	}
+}
+
+// LedgerMismatch describes a wallet whose balance differs from the sum of its postings
+type LedgerMismatch struct {
+	WalletID     uint
+	BalanceCents uint
+	PostedCents  int64
+}
+
+// ReconciliationReport is the result of checking the ledger against the wallets
+type ReconciliationReport struct {
+	WalletsChecked int
+	Mismatches     []LedgerMismatch
+	ImbalanceCents int64 // Sum of all postings, has to be zero
+}
+
+// OK returns whether the ledger is consistent
+func (r ReconciliationReport) OK() bool {
+	return len(r.Mismatches) == 0 && r.ImbalanceCents == 0
+}
+
+// Reconcile verifies that the postings of every wallet sum up to its balance
+// and that all postings in the ledger sum up to zero
+func (t *TransactionTable) Reconcile() (ReconciliationReport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := ReconciliationReport{Mismatches: []LedgerMismatch{}}
+
+	var totals []struct {
+		WalletID uint
+		Total    int64
+	}
+	err := t.DB.Model(&models.TransactionModel{}).
+		Select("wallet_id, SUM(amount_cents) AS total").
+		Where("wallet_id <> 0").
+		Group("wallet_id").
+		Scan(&totals).Error
+	if err != nil {
+		return report, err
+	}
+
+	posted := make(map[uint]int64, len(totals))
+	for _, row := range totals {
+		posted[row.WalletID] = row.Total
+	}
+
+	var wallets []models.WalletModel
+	if err := t.DB.Find(&wallets).Error; err != nil {
+		return report, err
+	}
+
+	for _, wallet := range wallets {
+		report.WalletsChecked++
+		if posted[wallet.ID] != int64(wallet.NetworthCents) {
+			report.Mismatches = append(report.Mismatches, LedgerMismatch{
+				WalletID:     wallet.ID,
+				BalanceCents: wallet.NetworthCents,
+				PostedCents:  posted[wallet.ID],
+			})
+		}
+	}
+
+	var imbalance struct{ Total int64 }
+	err = t.DB.Model(&models.TransactionModel{}).Select("COALESCE(SUM(amount_cents), 0) AS total").Scan(&imbalance).Error
+	if err != nil {
+		return report, err
+	}
+	report.ImbalanceCents = imbalance.Total
+
+	return report, nil
+}
+
+// repair_addOpeningBalances records opening balances for wallets that were funded before the ledger existed
+func (t *TransactionTable) repair_addOpeningBalances() {
+	var wallets []models.WalletModel
+	if err := t.DB.Where("networth_cents > 0").Find(&wallets).Error; err != nil {
+		utils.Log("error", "casino::data", "[TransactionTable] [Repair] failed to get wallets:", err)
+		return
+	}
+
+	for i := range wallets {
+		err := t.Atomic(func(ltx *LedgerTx) error {
+			return ltx.ensureOpeningBalance(&wallets[i])
+		})
+		if err != nil {
+			utils.Log("error", "casino::data", "[TransactionTable] [Repair] failed to record opening balance of wallet:", wallets[i].ID, "error:", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package tables_test
+
+import (
+	"errors"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/data"
+	"jhgambling/backend/core/data/tables"
+	"jhgambling/protocol/models"
+	"path/filepath"
+	"testing"
+)
+
+const testGameInstance = "dice/table-1"
+
+func newTestDatabase(t *testing.T) *data.Database {
+	t.Helper()
+
+	db := data.NewDatabase(config.Default("development"))
+	db.Connect(filepath.Join(t.TempDir(), "casino.db"))
+	db.Migrate()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newFundedUser creates a user whose wallet received the amount from the bonus account
+func newFundedUser(t *testing.T, db *data.Database, username string, amountCents uint) *models.UserModel {
+	t.Helper()
+
+	user := &models.UserModel{Username: username}
+	if err := db.GetUserTable().Create(user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	err := db.GetTransactionTable().Atomic(func(ltx *tables.LedgerTx) error {
+		return ltx.Transfer(tables.Transfer{
+			From:        tables.AccountBonus,
+			To:          tables.WalletAccount(user.Wallet.ID),
+			AmountCents: amountCents,
+			Reason:      "bonus",
+		})
+	})
+	if err != nil {
+		t.Fatalf("funding wallet: %v", err)
+	}
+	return user
+}
+
+func TestBetPostingsReconcile(t *testing.T) {
+	db := newTestDatabase(t)
+	user := newFundedUser(t, db, "alice", 1000)
+	bets := db.GetBetTable()
+
+	// Won round
+	if err := bets.PlaceBet(user.ID, 300, "round-1", testGameInstance); err != nil {
+		t.Fatalf("placing bet: %v", err)
+	}
+	if err := bets.SettleBet("round-1", 500, testGameInstance); err != nil {
+		t.Fatalf("settling bet: %v", err)
+	}
+
+	// Refunded round, placing it twice has no effect
+	for i := 0; i < 2; i++ {
+		if err := bets.PlaceBet(user.ID, 200, "round-2", testGameInstance); err != nil {
+			t.Fatalf("placing bet: %v", err)
+		}
+	}
+	if err := bets.RefundBet("round-2", testGameInstance); err != nil {
+		t.Fatalf("refunding bet: %v", err)
+	}
+
+	var wallet models.WalletModel
+	if err := bets.DB.First(&wallet, user.Wallet.ID).Error; err != nil {
+		t.Fatalf("loading wallet: %v", err)
+	}
+	if wallet.NetworthCents != 1200 {
+		t.Errorf("balance = %d, want 1200", wallet.NetworthCents)
+	}
+
+	report, err := db.GetTransactionTable().Reconcile()
+	if err != nil {
+		t.Fatalf("reconciling: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("ledger does not reconcile: %+v", report)
+	}
+	if report.WalletsChecked != 1 {
+		t.Errorf("checked %d wallets, want 1", report.WalletsChecked)
+	}
+
+	// Every transfer of a bet is posted twice and records the game instance
+	var postings []models.TransactionModel
+	if err := bets.DB.Where("reference IN ?", []string{"round-1", "round-2"}).Find(&postings).Error; err != nil {
+		t.Fatalf("loading postings: %v", err)
+	}
+	if len(postings) != 10 {
+		t.Errorf("found %d postings, want 10 (bet, settlement and payout of round-1, bet and refund of round-2)", len(postings))
+	}
+	for _, posting := range postings {
+		if posting.GameInstance != testGameInstance {
+			t.Errorf("posting %q of %s has game instance %q, want %q", posting.Reason, posting.Reference, posting.GameInstance, testGameInstance)
+		}
+	}
+}
+
+func TestBetsBelongToTheirGameInstance(t *testing.T) {
+	db := newTestDatabase(t)
+	user := newFundedUser(t, db, "carol", 1000)
+	bets := db.GetBetTable()
+
+	// Both instances have a round with the same ID
+	if err := bets.PlaceBet(user.ID, 100, "round-1", testGameInstance); err != nil {
+		t.Fatalf("placing bet: %v", err)
+	}
+	if err := bets.PlaceBet(user.ID, 200, "round-1", "dice/table-2"); err != nil {
+		t.Fatalf("placing bet of the other instance: %v", err)
+	}
+
+	if err := bets.SettleBet("round-1", 1000, "dice/table-3"); !errors.Is(err, tables.ErrBetNotFound) {
+		t.Errorf("settling from another instance: got %v, want %v", err, tables.ErrBetNotFound)
+	}
+	if err := bets.RefundBet("round-1", "dice/table-3"); !errors.Is(err, tables.ErrBetNotFound) {
+		t.Errorf("refunding from another instance: got %v, want %v", err, tables.ErrBetNotFound)
+	}
+
+	if err := bets.RefundBet("round-1", "dice/table-2"); err != nil {
+		t.Fatalf("refunding bet: %v", err)
+	}
+	bet, err := bets.FindByRoundID("round-1", testGameInstance)
+	if err != nil {
+		t.Fatalf("loading bet: %v", err)
+	}
+	if bet.Status != models.BetStatusPlaced || bet.AmountCents != 100 {
+		t.Errorf("bet of %s is %s with %d cents, want placed with 100", testGameInstance, bet.Status, bet.AmountCents)
+	}
+}
+
+func TestReconcileFindsMismatch(t *testing.T) {
+	db := newTestDatabase(t)
+	user := newFundedUser(t, db, "bob", 1000)
+
+	// A balance that was changed without a transfer
+	err := db.GetTransactionTable().DB.Model(&models.WalletModel{}).
+		Where("id = ?", user.Wallet.ID).
+		Update("networth_cents", 1500).Error
+	if err != nil {
+		t.Fatalf("changing balance: %v", err)
+	}
+
+	report, err := db.GetTransactionTable().Reconcile()
+	if err != nil {
+		t.Fatalf("reconciling: %v", err)
+	}
+	if len(report.Mismatches) != 1 {
+		t.Fatalf("found %d mismatches, want 1: %+v", len(report.Mismatches), report)
+	}
+	mismatch := report.Mismatches[0]
+	if mismatch.WalletID != user.Wallet.ID || mismatch.BalanceCents != 1500 || mismatch.PostedCents != 1000 {
+		t.Errorf("mismatch = %+v, want wallet %d with balance 1500 and 1000 posted", mismatch, user.Wallet.ID)
+	}
+	if report.ImbalanceCents != 0 {
+		t.Errorf("imbalance = %d, want 0", report.ImbalanceCents)
+	}
+}
@@ -1,29 +1,50 @@
 package game
 
 import (
+	"errors"
 	"jhgambling/backend/core/utils"
 	"jhgambling/protocol"
+	"runtime/debug"
+	"sync"
+	"time"
 )
 
 type GameManager struct {
 	GameProviders []protocol.GameProvider
 	Adapter       protocol.CasinoAdapter
+
+	loops    map[string]*instanceLoop
+	running  bool
+	stopSync chan struct{}
+	mu       sync.Mutex
 }
 
 func NewGameManager() *GameManager {
 	return &GameManager{
 		GameProviders: []protocol.GameProvider{},
+		loops:         make(map[string]*instanceLoop),
 	}
 }
 
 func (gm *GameManager) SetAdapter(adapter protocol.CasinoAdapter) {
 	gm.Adapter = adapter
+
+	for _, instance := range gm.GetGameInstances() {
+		instance.SetAdapter(adapter)
+	}
 }
 
 // RegisterProvider adds a new game provider to the manager.
 func (gm *GameManager) RegisterProvider(provider protocol.GameProvider) {
 	gm.GameProviders = append(gm.GameProviders, provider)
 
+	// Give already running instances a way to reach the casino
+	if gm.Adapter != nil {
+		for _, instance := range provider.GetInstances() {
+			instance.SetAdapter(gm.Adapter)
+		}
+	}
+
 	utils.Log("ok", "casino::games", "registered game provider '", provider.GetID(), "' ('", provider.GetName(), "')")
 }
 
@@ -64,3 +85,88 @@ func (gm *GameManager) GetInstanceByID(providerID, instanceID string) protocol.G
 	}
 	return nil
 }
+
+// CreateInstance asks a provider to open a new game instance and starts its game loop.
+func (gm *GameManager) CreateInstance(providerID string, config protocol.GameInstanceConfig) (protocol.GameInstance, error) {
+	provider := gm.GetProviderByID(providerID)
+	if provider == nil {
+		return nil, protocol.NewError(protocol.ErrorGameProviderNotFound, "game provider not found")
+	}
+
+	if config.ID != "" && gm.GetInstanceByID(providerID, config.ID) != nil {
+		return nil, protocol.NewError(protocol.ErrorGameExists, "game instance already exists")
+	}
+
+	instance, err := provider.CreateInstance(config)
+	if err != nil {
+		return nil, providerError(err)
+	}
+	if instance == nil {
+		return nil, errors.New("game provider did not return an instance")
+	}
+
+	if gm.Adapter != nil {
+		instance.SetAdapter(gm.Adapter)
+	}
+
+	if tickRate := provider.GetTickRate(); tickRate > 0 {
+		gm.startLoop(instanceKey(providerID, instance.GetID()), instance, time.Second/time.Duration(tickRate))
+	}
+
+	utils.Log("ok", "casino::games", "created game instance '", providerID, "/", instance.GetID(), "'")
+	return instance, nil
+}
+
+// CloseInstance stops the game loop of an instance and asks its provider to shut it down.
+func (gm *GameManager) CloseInstance(providerID, instanceID string) error {
+	provider := gm.GetProviderByID(providerID)
+	if provider == nil {
+		return protocol.NewError(protocol.ErrorGameProviderNotFound, "game provider not found")
+	}
+
+	if gm.GetInstanceByID(providerID, instanceID) == nil {
+		return protocol.NewError(protocol.ErrorGameNotFound, "game instance not found")
+	}
+
+	// Waits for a running tick, so the provider never closes an instance in the middle of one
+	gm.stopLoop(instanceKey(providerID, instanceID))
+
+	if err := provider.CloseInstance(instanceID); err != nil {
+		return providerError(err)
+	}
+
+	utils.Log("ok", "casino::games", "closed game instance '", providerID, "/", instanceID, "'")
+	return nil
+}
+
+// CloseAll closes every game instance, so providers can finish or refund their open rounds.
+// A failing or panicking provider is logged and does not keep the others from closing.
+// The instances that have been closed are returned.
+func (gm *GameManager) CloseAll() []protocol.GameInstance {
+	closed := []protocol.GameInstance{}
+	for _, instance := range gm.GetGameInstances() {
+		providerID, instanceID := instance.GetProviderID(), instance.GetID()
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					utils.Log("error", "casino::games", "instance '", providerID, "/", instanceID, "' panicked while closing: ", r, "\n", string(debug.Stack()))
+				}
+			}()
+			if err := gm.CloseInstance(providerID, instanceID); err != nil {
+				utils.Log("warn", "casino::games", "failed to close game instance '", providerID, "/", instanceID, "': ", err)
+				return
+			}
+			closed = append(closed, instance)
+		}()
+	}
+	return closed
+}
+
+// providerError keeps errors of game providers from the catalog, other errors
+// might contain internals of the plugin and are only kept as the cause
+func providerError(err error) error {
+	if _, ok := protocol.AsError(err); ok {
+		return err
+	}
+	return protocol.NewError(protocol.ErrorGameRejected, "the game rejected the request").WithCause(err)
+}
@@ -0,0 +1,189 @@
+package game
+
+import (
+	"fmt"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"runtime/debug"
+	"time"
+)
+
+// How often the manager looks for instances that were started or stopped by their provider
+const loopSyncInterval = time.Second
+
+// instanceLoop drives the Tick() function of a single game instance
+type instanceLoop struct {
+	instance protocol.GameInstance
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{} // Closed once the loop has returned, after its last tick
+}
+
+func instanceKey(providerID, instanceID string) string {
+	return providerID + "/" + instanceID
+}
+
+// Start begins ticking all game instances and keeps the running loops in sync with the providers
+func (gm *GameManager) Start() {
+	gm.mu.Lock()
+	if gm.running {
+		gm.mu.Unlock()
+		return
+	}
+	gm.running = true
+	gm.stopSync = make(chan struct{})
+	gm.mu.Unlock()
+
+	gm.SyncLoops()
+
+	go func() {
+		ticker := time.NewTicker(loopSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-gm.stopSync:
+				return
+			case <-ticker.C:
+				gm.SyncLoops()
+			}
+		}
+	}()
+
+	utils.Log("info", "casino::games", "game loop started")
+}
+
+// Stop halts all running game loops and waits for ticks that are still running
+func (gm *GameManager) Stop() {
+	gm.mu.Lock()
+	if !gm.running {
+		gm.mu.Unlock()
+		return
+	}
+	gm.running = false
+	close(gm.stopSync)
+
+	stopped := make([]*instanceLoop, 0, len(gm.loops))
+	for key, loop := range gm.loops {
+		close(loop.stop)
+		delete(gm.loops, key)
+		stopped = append(stopped, loop)
+	}
+	gm.mu.Unlock()
+
+	// Ticks may call back into the manager, so they are awaited outside of the lock
+	for _, loop := range stopped {
+		<-loop.done
+	}
+
+	utils.Log("info", "casino::games", "game loop stopped")
+}
+
+// SyncLoops starts loops for new instances and stops loops of instances that no longer exist
+func (gm *GameManager) SyncLoops() {
+	active := map[string]bool{}
+
+	for _, provider := range gm.GetAllProviders() {
+		tickRate := provider.GetTickRate()
+		if tickRate <= 0 {
+			continue
+		}
+		interval := time.Second / time.Duration(tickRate)
+
+		for _, instance := range provider.GetInstances() {
+			key := instanceKey(instance.GetProviderID(), instance.GetID())
+			active[key] = true
+			gm.startLoop(key, instance, interval)
+		}
+	}
+
+	gm.mu.Lock()
+	stale := []string{}
+	for key := range gm.loops {
+		if !active[key] {
+			stale = append(stale, key)
+		}
+	}
+	gm.mu.Unlock()
+
+	for _, key := range stale {
+		gm.stopLoop(key)
+	}
+}
+
+func (gm *GameManager) startLoop(key string, instance protocol.GameInstance, interval time.Duration) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	if !gm.running {
+		return
+	}
+	if _, exists := gm.loops[key]; exists {
+		return
+	}
+
+	loop := &instanceLoop{
+		instance: instance,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	gm.loops[key] = loop
+	go loop.run(key)
+
+	utils.Log("debug", "casino::games", "[loop] started loop for '", key, "' every ", interval)
+}
+
+// stopLoop stops the loop of an instance and waits for its last tick, so the instance can be closed afterwards
+func (gm *GameManager) stopLoop(key string) {
+	gm.mu.Lock()
+	loop, exists := gm.loops[key]
+	if !exists {
+		gm.mu.Unlock()
+		return
+	}
+
+	close(loop.stop)
+	delete(gm.loops, key)
+	gm.mu.Unlock()
+
+	// Like in Stop, the tick is awaited outside of the lock
+	<-loop.done
+	utils.Log("debug", "casino::games", "[loop] stopped loop for '", key, "'")
+}
+
+func (loop *instanceLoop) run(key string) {
+	defer close(loop.done)
+
+	ticker := time.NewTicker(loop.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-loop.stop:
+			return
+		case <-ticker.C:
+			loop.tick(key)
+		}
+	}
+}
+
+// tick runs a single Tick() of the instance and makes sure a panicking
+// plugin only loses this tick instead of crashing the casino
+func (loop *instanceLoop) tick(key string) {
+	start := time.Now()
+
+	defer func() {
+		if r := recover(); r != nil {
+			utils.Log("error", "casino::games", "[loop] instance '", key, "' panicked during tick: ", r, "\n", string(debug.Stack()))
+		}
+	}()
+
+	loop.instance.Tick()
+
+	elapsed := time.Since(start)
+	if elapsed > loop.interval {
+		utils.Log("warn", "casino::games", "[loop] instance '", key, "' tick took ",
+			elapsed, fmt.Sprintf(" (budget %v, %.0f%%)", loop.interval, float64(elapsed)/float64(loop.interval)*100))
+	}
+}
@@ -3,6 +3,7 @@ package plugins
 import (
 	"errors"
 	"io/ioutil"
+	"jhgambling/backend/core/config"
 	"jhgambling/backend/core/utils"
 	"path"
 	"plugin"
@@ -13,11 +14,14 @@ import (
 
 type PluginManager struct {
 	GameProviders []protocol.GameProvider
+
+	directory string
 }
 
-func NewPluginManager() *PluginManager {
+func NewPluginManager(cfg config.PluginsConfig) *PluginManager {
 	return &PluginManager{
 		GameProviders: []protocol.GameProvider{},
+		directory:     cfg.Directory,
 	}
 }
 
@@ -39,7 +43,7 @@ func (pm *PluginManager) LoadPlugins() {
 }
 
 func (pm *PluginManager) ListAvailablePlugins() []string {
-	pluginPath := "../games/"
+	pluginPath := pm.directory
 
 	result := []string{}
 
@@ -0,0 +1,263 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"jhgambling/backend/core/codec"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Schema is a JSON Schema (draft 2020-12), limited to the keywords Go types need
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+
+	order []string // Properties in the order of the struct fields
+}
+
+// Nullable allows null in addition to the schema
+func Nullable(s *Schema) *Schema {
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
+
+// PropertyNames returns the properties in the order of the struct fields
+func (s *Schema) PropertyNames() []string {
+	return s.order
+}
+
+// Without returns a copy of an object schema without the given properties
+func (s *Schema) Without(names ...string) *Schema {
+	c := *s
+	c.Properties = map[string]*Schema{}
+	c.order = []string{}
+	c.Required = []string{}
+	for _, name := range s.order {
+		if !contains(names, name) {
+			c.Properties[name] = s.Properties[name]
+			c.order = append(c.order, name)
+		}
+	}
+	for _, name := range s.Required {
+		if !contains(names, name) {
+			c.Required = append(c.Required, name)
+		}
+	}
+	return &c
+}
+
+// Optional returns a copy of an object schema where the given properties are not required
+func (s *Schema) Optional(names ...string) *Schema {
+	c := *s
+	c.Required = []string{}
+	for _, name := range s.Required {
+		if !contains(names, name) {
+			c.Required = append(c.Required, name)
+		}
+	}
+	return &c
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Types with their own JSON encoding, which can't be derived from their fields
+var knownTypes = map[reflect.Type]*Schema{
+	reflect.TypeOf(time.Time{}):        {Type: "string", Format: "date-time"},
+	reflect.TypeOf(json.RawMessage{}):  {},
+	reflect.TypeOf(codec.RawMessage{}): {},
+	reflect.TypeOf(gorm.DeletedAt{}):   Nullable(&Schema{Type: "string", Format: "date-time"}),
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generator derives schemas from Go types. Named types become definitions,
+// which are referenced by every schema that uses them.
+type Generator struct {
+	Definitions map[string]*Schema
+
+	names map[reflect.Type]string
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		Definitions: map[string]*Schema{},
+		names:       map[reflect.Type]string{},
+	}
+}
+
+// Ref returns a reference to a definition
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+// DefinitionName returns the name a reference points to
+func DefinitionName(ref string) string {
+	return strings.TrimPrefix(ref, "#/$defs/")
+}
+
+// Names returns the names of all definitions, sorted
+func (g *Generator) Names() []string {
+	names := make([]string, 0, len(g.Definitions))
+	for name := range g.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Define adds a definition for a schema under the given name
+func (g *Generator) Define(name string, s *Schema) *Schema {
+	g.Definitions[name] = s
+	return Ref(name)
+}
+
+// Schema returns the schema of the value's type, see SchemaOf
+func (g *Generator) Schema(v interface{}) *Schema {
+	return g.SchemaOf(reflect.TypeOf(v))
+}
+
+// SchemaOf returns the schema of a type as it is encoded by the codecs.
+// Named structs and named basic types are returned as references to their definition.
+func (g *Generator) SchemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if s, ok := knownTypes[t]; ok {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return Nullable(g.SchemaOf(t.Elem()))
+	case reflect.Interface:
+		return &Schema{}
+	}
+
+	// Custom encodings can't be described, except that text ends up as a string
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return &Schema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	if t.Name() == "" || t.PkgPath() == "" {
+		return g.inline(t)
+	}
+
+	if name, ok := g.names[t]; ok {
+		return Ref(name)
+	}
+	name := g.definitionName(t)
+	g.names[t] = name
+	// Reserved before the type is described, so recursive types end in a reference
+	g.Definitions[name] = &Schema{}
+	*g.Definitions[name] = *g.inline(t)
+	return Ref(name)
+}
+
+// inline describes a type without creating a definition for it
+func (g *Generator) inline(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"} // Base64 in JSON
+		}
+		// Nil slices are encoded as null
+		return Nullable(&Schema{Type: "array", Items: g.SchemaOf(t.Elem())})
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.SchemaOf(t.Elem())}
+	case reflect.Map:
+		return Nullable(&Schema{Type: "object", AdditionalProperties: g.SchemaOf(t.Elem())})
+	case reflect.Struct:
+		return g.object(t)
+	}
+	// Channels and functions can't be encoded
+	return &Schema{}
+}
+
+func (g *Generator) object(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{},
+		Required:   []string{},
+		order:      []string{},
+	}
+	for _, field := range codec.Fields(t) {
+		property := g.SchemaOf(field.Type)
+		if field.OmitEmpty && len(property.AnyOf) == 2 && property.AnyOf[1].Type == "null" {
+			// Nil values are left out instead of being null
+			property = property.AnyOf[0]
+		}
+		s.Properties[field.Name] = property
+		s.order = append(s.order, field.Name)
+		// Fields are always written unless they may be omitted
+		if !field.OmitEmpty {
+			s.Required = append(s.Required, field.Name)
+		}
+	}
+	return s
+}
+
+// definitionName names a type after itself, or after its package and itself if the name is taken
+func (g *Generator) definitionName(t reflect.Type) string {
+	name := identifier(t.Name())
+	if _, taken := g.Definitions[name]; !taken {
+		return name
+	}
+
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	base := identifier(strings.ToUpper(pkg[:1]) + pkg[1:] + name)
+	name = base
+	for i := 2; ; i++ {
+		if _, taken := g.Definitions[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// identifier removes everything from a type name that can't be part of an identifier, e.g. of generic types
+func identifier(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+}
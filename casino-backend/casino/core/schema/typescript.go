@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// TypeScript writes all definitions as TypeScript declarations, objects as interfaces
+func (g *Generator) TypeScript() string {
+	var b strings.Builder
+	for _, name := range g.Names() {
+		s := g.Definitions[name]
+		if s.Type == "object" && s.Properties != nil {
+			fmt.Fprintf(&b, "export interface %s %s\n\n", name, TSObject(s, ""))
+		} else {
+			fmt.Fprintf(&b, "export type %s = %s;\n\n", name, TSType(s))
+		}
+	}
+	return b.String()
+}
+
+// TSType returns the TypeScript type of a schema
+func TSType(s *Schema) string {
+	if s.Ref != "" {
+		return DefinitionName(s.Ref)
+	}
+	if len(s.AnyOf) > 0 {
+		types := make([]string, len(s.AnyOf))
+		for i, option := range s.AnyOf {
+			types[i] = TSType(option)
+		}
+		return strings.Join(types, " | ")
+	}
+
+	switch s.Type {
+	case "boolean", "string", "null":
+		return s.Type
+	case "integer", "number":
+		return "number"
+	case "array":
+		items := TSType(s.Items)
+		if strings.Contains(items, " ") {
+			items = "(" + items + ")"
+		}
+		return items + "[]"
+	case "object":
+		if s.Properties != nil {
+			return TSObject(s, "")
+		}
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + TSType(s.AdditionalProperties) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+// TSObject returns the TypeScript type of an object schema, one property per line.
+// Properties that are not required are optional.
+func TSObject(s *Schema, indent string) string {
+	if len(s.order) == 0 {
+		return "{}"
+	}
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range s.order {
+		key := name
+		if !tsIdentifier.MatchString(key) {
+			key = fmt.Sprintf("%q", key)
+		}
+		if !contains(s.Required, name) {
+			key += "?"
+		}
+
+		property := s.Properties[name]
+		var value string
+		if property.Type == "object" && property.Properties != nil {
+			value = TSObject(property, indent+"\t")
+		} else {
+			value = TSType(property)
+		}
+		if property.Description != "" {
+			fmt.Fprintf(&b, "%s\t/** %s */\n", indent, property.Description)
+		}
+		fmt.Fprintf(&b, "%s\t%s: %s;\n", indent, key, value)
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
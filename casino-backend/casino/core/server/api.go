@@ -0,0 +1,419 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"jhgambling/backend/core/codec"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiRoute maps an HTTP endpoint to a packet. The packet is handled by the same handler
+// as on the websocket, so both have the same permissions, limits and error codes.
+type apiRoute struct {
+	Pattern    string // Method and path, see http.ServeMux
+	PacketType string
+	// Builds the payload of the packet, the request body is used if nil
+	Payload func(r *http.Request, ctx *HandlerContext) (interface{}, error)
+}
+
+// The nonce of API requests, the responses are picked up by it
+const apiNonce = 1
+
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		// Auth
+		{Pattern: "POST /api/auth/register", PacketType: "auth/register"},
+		{Pattern: "POST /api/auth/login", PacketType: "auth/login"},
+		{Pattern: "POST /api/auth/login_2fa", PacketType: "auth/login_2fa"},
+		{Pattern: "POST /api/auth/refresh", PacketType: "auth/refresh"},
+		{Pattern: "POST /api/auth/logout", PacketType: "auth/logout"},
+		{Pattern: "POST /api/auth/change_password", PacketType: "auth/change_password"},
+		{Pattern: "POST /api/auth/create_reset_code", PacketType: "auth/create_reset_code"},
+		{Pattern: "POST /api/auth/reset_password", PacketType: "auth/reset_password"},
+		{Pattern: "POST /api/auth/2fa/setup", PacketType: "auth/2fa/setup"},
+		{Pattern: "POST /api/auth/2fa/enable", PacketType: "auth/2fa/enable"},
+		{Pattern: "POST /api/auth/2fa/disable", PacketType: "auth/2fa/disable"},
+		{Pattern: "POST /api/auth/2fa/recovery_codes", PacketType: "auth/2fa/recovery_codes"},
+
+		// Users and wallets
+		{Pattern: "GET /api/users", PacketType: "db/op", Payload: tableOperation("users", "findAll")},
+		{Pattern: "GET /api/users/me", PacketType: "db/op", Payload: ownRecord("users", func(ctx *HandlerContext) (uint, error) {
+			return ctx.Client.GetAuthenticatedUserID(), nil
+		})},
+		{Pattern: "GET /api/users/{id}", PacketType: "db/op", Payload: tableOperation("users", "findByID")},
+		{Pattern: "GET /api/wallets/me", PacketType: "db/op", Payload: ownRecord("wallets", func(ctx *HandlerContext) (uint, error) {
+			user, err := ctx.GetUser()
+			if err != nil {
+				return 0, err
+			}
+			return user.Wallet.ID, nil
+		})},
+		{Pattern: "GET /api/wallets/{id}", PacketType: "db/op", Payload: tableOperation("wallets", "findByID")},
+		{Pattern: "POST /api/wallets/{id}/adjust", PacketType: "wallet/adjust", Payload: walletAdjustment},
+
+		// Any table, like db/op
+		{Pattern: "GET /api/tables/{table}", PacketType: "db/op", Payload: tableOperation("", "findAll")},
+		{Pattern: "POST /api/tables/{table}", PacketType: "db/op", Payload: tableOperation("", "create")},
+		{Pattern: "GET /api/tables/{table}/{id}", PacketType: "db/op", Payload: tableOperation("", "findByID")},
+		{Pattern: "PATCH /api/tables/{table}/{id}", PacketType: "db/op", Payload: tableOperation("", "update")},
+		{Pattern: "DELETE /api/tables/{table}/{id}", PacketType: "db/op", Payload: tableOperation("", "delete")},
+
+		// Games
+		{Pattern: "GET /api/games", PacketType: "game/list", Payload: func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+			return GameListPacket{ProviderID: r.URL.Query().Get("provider")}, nil
+		}},
+		{Pattern: "POST /api/games", PacketType: "game/create"},
+		{Pattern: "DELETE /api/games/{provider}/{instance}", PacketType: "game/close", Payload: func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+			return GameClosePacket{ProviderID: r.PathValue("provider"), InstanceID: r.PathValue("instance")}, nil
+		}},
+	}
+}
+
+// registerAPI adds the REST API to the mux
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	for _, route := range apiRoutes() {
+		mux.HandleFunc(route.Pattern, s.withCORS(s.handleAPIRoute(route.PacketType, route.Payload)))
+	}
+
+	// Packets of game plugins, e.g. POST /api/games/blackjack/packets/stats for "blackjack/stats"
+	mux.HandleFunc("POST /api/games/{provider}/packets/{name}", s.withCORS(func(w http.ResponseWriter, r *http.Request) {
+		provider := r.PathValue("provider")
+		if s.gateway.ctx.Games == nil || s.gateway.ctx.Games.GetProviderByID(provider) == nil {
+			writeAPIError(w, http.StatusNotFound, failed(protocol.ErrorGameProviderNotFound, "game provider not found"))
+			return
+		}
+		s.handleAPIRoute(provider+"/"+r.PathValue("name"), nil)(w, r)
+	}))
+
+	mux.HandleFunc("OPTIONS /api/", s.withCORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/api/", s.withCORS(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, failed(protocol.ErrorProtocolUnknownPacket, "unknown endpoint"))
+	}))
+}
+
+// withCORS lets browsers call the API from the allowed origins, or any origin if none are configured
+func (s *Server) withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (len(s.allowedOrigins) == 0 || slices.Contains(s.allowedOrigins, origin)) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Add("Vary", "Origin")
+		}
+		next(w, r)
+	}
+}
+
+// handleAPIRoute handles the packet of an endpoint on a client that only lives for the request
+func (s *Server) handleAPIRoute(packetType string, buildPayload func(r *http.Request, ctx *HandlerContext) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := NewGatewayClient(r.RemoteAddr, codec.JSON, s.gateway.ctx)
+		ctx := &client.handlerContext
+
+		if token, ok := bearerToken(r); ok {
+			claims, err := ctx.Auth.VerifyToken(token)
+			if err != nil {
+				writeAPIError(w, http.StatusUnauthorized, failed(protocol.ErrorAuthInvalidToken, "invalid token"))
+				return
+			}
+			client.Authenticate(claims.UserID, claims.SessionID, claims.ExpiresAt)
+		}
+
+		if ok, wait := s.apiLimiter.Allow(client.RemoteIP(), packetType); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, failed(protocol.ErrorRateLimited, "Too many requests"))
+			return
+		}
+
+		def, ok := s.gateway.Packets.Get(packetType)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, failed(protocol.ErrorProtocolUnknownPacket, "unknown packet type"))
+			return
+		}
+
+		payload, err := readAPIPayload(w, r, ctx, buildPayload)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, failed(protocol.ErrorInvalidRequest, err.Error()))
+			return
+		}
+
+		responses := client.dispatch(def, WebsocketPacket{Type: packetType, Payload: codec.RawMessage(payload), Nonce: apiNonce})
+		if len(responses) == 0 {
+			writeAPIError(w, http.StatusInternalServerError, failed(protocol.ErrorInternal, "the request was not answered"))
+			return
+		}
+		writeAPIResponse(w, client, responses[0])
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// readAPIPayload returns the payload of the packet, built from the request or taken from its body
+func readAPIPayload(w http.ResponseWriter, r *http.Request, ctx *HandlerContext,
+	buildPayload func(r *http.Request, ctx *HandlerContext) (interface{}, error)) (json.RawMessage, error) {
+
+	if buildPayload != nil {
+		payload, err := buildPayload(r, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(payload)
+	}
+
+	body := r.Body
+	if limit := ctx.Config.Server.RateLimit.MaxMessageBytes; limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errors.New("the request body could not be read")
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	return data, nil
+}
+
+// readJSONBody decodes the request body, an empty body leaves v unchanged
+func readJSONBody(r *http.Request, ctx *HandlerContext, v interface{}) error {
+	body := r.Body
+	if limit := ctx.Config.Server.RateLimit.MaxMessageBytes; limit > 0 {
+		body = io.NopCloser(io.LimitReader(r.Body, limit))
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil && err != io.EOF {
+		return errors.New("invalid JSON body")
+	}
+	return nil
+}
+
+// tableOperation builds a db/op packet. The table is taken from the path if it is empty,
+// the ID from the path, limit and offset from the query and the data from the body.
+func tableOperation(table string, operation string) func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+	return func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+		op := DatabaseOperationPacket{Table: table, Operation: operation}
+		if op.Table == "" {
+			op.Table = r.PathValue("table")
+		}
+
+		switch operation {
+		case "findAll":
+			limit, offset := 10, 0
+			var err error
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+					return nil, errors.New("invalid limit")
+				}
+			}
+			if v := r.URL.Query().Get("offset"); v != "" {
+				if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+					return nil, errors.New("invalid offset")
+				}
+			}
+			op.OpId, op.OpData = limit, offset
+		case "create", "update":
+			var data interface{}
+			if err := readJSONBody(r, ctx, &data); err != nil {
+				return nil, err
+			}
+			op.OpData = data
+		}
+
+		if v := r.PathValue("id"); v != "" {
+			id, err := protocol.ParseID(v)
+			if err != nil {
+				return nil, errors.New("invalid ID")
+			}
+			op.OpId = id
+		}
+		return op, nil
+	}
+}
+
+// ownRecord builds a db/op packet that reads a record of the authenticated user
+func ownRecord(table string, recordID func(ctx *HandlerContext) (uint, error)) func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+	return func(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+		op := DatabaseOperationPacket{Table: table, Operation: "findByID"}
+		// Without a user the packet is rejected as unauthorized
+		if ctx.Client.IsAuthenticated() {
+			id, err := recordID(ctx)
+			if err != nil {
+				return nil, err
+			}
+			op.OpId = id
+		}
+		return op, nil
+	}
+}
+
+func walletAdjustment(r *http.Request, ctx *HandlerContext) (interface{}, error) {
+	var packet WalletAdjustPacket
+	if err := readJSONBody(r, ctx, &packet); err != nil {
+		return nil, err
+	}
+	id, err := protocol.ParseID(r.PathValue("id"))
+	if err != nil {
+		return nil, errors.New("invalid ID")
+	}
+	packet.WalletID = id
+	return packet, nil
+}
+
+// apiResult is the part of a response that decides the HTTP status
+type apiResult struct {
+	Success      *bool              `json:"success"`
+	Status       string             `json:"status"`
+	Code         protocol.ErrorCode `json:"code"`
+	RetryAfterMs int64              `json:"retryAfterMs"`
+}
+
+// writeAPIResponse writes the response packet of a request as the body.
+// Responses of db/op only contain the result, failed responses get a matching status code.
+func writeAPIResponse(w http.ResponseWriter, client *GatewayClient, packet *Packet) {
+	if op, ok := packet.Payload.(DatabaseOperationResponsePacket); ok {
+		if op.Error != nil {
+			writeAPIError(w, apiStatus(op.Error.Code, client), failed(op.Error.Code, op.Error.Message))
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"success": true, "status": "ok", "result": op.Result})
+		return
+	}
+
+	body, err := json.Marshal(packet.Payload)
+	if err != nil {
+		utils.Log("error", "casino::api", "error encoding the response of '", packet.Type, "': ", err)
+		writeAPIError(w, http.StatusInternalServerError, failed(protocol.ErrorInternal, "internal error"))
+		return
+	}
+
+	status := http.StatusOK
+	var result apiResult
+	if err := json.Unmarshal(body, &result); err == nil && result.Success != nil && !*result.Success {
+		status = apiStatus(result.Code, client)
+		if result.RetryAfterMs > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(float64(result.RetryAfterMs)/1000))))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, response ResponsePacket) {
+	writeAPIJSON(w, status, response)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		utils.Log("error", "casino::api", "error writing response: ", err)
+	}
+}
+
+// apiStatus maps an error code to the HTTP status of the response
+func apiStatus(code protocol.ErrorCode, client *GatewayClient) int {
+	switch code {
+	case protocol.ErrorUnauthorized:
+		// Also sent to users that lack a permission
+		if client.IsAuthenticated() {
+			return http.StatusForbidden
+		}
+		return http.StatusUnauthorized
+	case protocol.ErrorAuthInvalidToken, protocol.ErrorAuthInvalidCredentials, protocol.ErrorAuthWrongPassword,
+		protocol.ErrorAuthInvalidRefreshToken, protocol.ErrorAuthSessionNotFound, protocol.ErrorAuthSessionRevoked,
+		protocol.ErrorAuthSessionExpired, protocol.ErrorAuthRefreshTokenReused, protocol.ErrorAuth2FARequired,
+		protocol.ErrorAuth2FASetupRequired, protocol.ErrorAuth2FAInvalidCode, protocol.ErrorAuth2FAInvalidChallenge,
+		protocol.ErrorAuthInvalidResetCode:
+		return http.StatusUnauthorized
+	case protocol.ErrorDBPermissionDenied, protocol.ErrorDBOperationForbidden, protocol.ErrorAuth2FAMandatory,
+		protocol.ErrorAuthLookupDisabled:
+		return http.StatusForbidden
+	case protocol.ErrorAuthUserNotFound, protocol.ErrorDBTableNotFound, protocol.ErrorDBRecordNotFound,
+		protocol.ErrorAuth2FANotSetUp, protocol.ErrorBetNotFound, protocol.ErrorGameProviderNotFound,
+		protocol.ErrorGameNotFound, protocol.ErrorProtocolUnknownPacket:
+		return http.StatusNotFound
+	case protocol.ErrorAuthUserExists, protocol.ErrorDBConflict, protocol.ErrorAuth2FAAlreadyEnabled,
+		protocol.ErrorBetConflict, protocol.ErrorGameExists, protocol.ErrorProtocolNonceReused:
+		return http.StatusConflict
+	case protocol.ErrorWalletInsufficientFunds, protocol.ErrorGameRejected, protocol.ErrorBetClosed:
+		return http.StatusUnprocessableEntity
+	case protocol.ErrorRateLimited:
+		return http.StatusTooManyRequests
+	case protocol.ErrorInternal:
+		return http.StatusInternalServerError
+	case protocol.ErrorProtocolRequestTimeout:
+		return http.StatusGatewayTimeout
+	case protocol.ErrorShuttingDown:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// APILimiter applies the packet rate limits to API requests. HTTP requests have no
+// connection, so the limits are kept per IP and forgotten once the IP is quiet.
+type APILimiter struct {
+	cfg config.PacketRateLimitConfig
+
+	mu          sync.Mutex
+	ips         map[string]*apiLimiterEntry
+	lastCleanup time.Time
+}
+
+type apiLimiterEntry struct {
+	limiter  *PacketLimiter
+	lastSeen time.Time
+}
+
+func NewAPILimiter(cfg config.PacketRateLimitConfig) *APILimiter {
+	return &APILimiter{
+		cfg:         cfg,
+		ips:         map[string]*apiLimiterEntry{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// Allow takes a token for the packet type and reports how long to wait if the IP is limited
+func (l *APILimiter) Allow(ip string, packetType string) (bool, time.Duration) {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.lastCleanup) >= time.Minute {
+		l.lastCleanup = now
+		for key, entry := range l.ips {
+			// Buckets are full again after this long, so nothing is lost
+			if now.Sub(entry.lastSeen) > time.Minute*10 {
+				delete(l.ips, key)
+			}
+		}
+	}
+
+	entry, ok := l.ips[ip]
+	if !ok {
+		entry = &apiLimiterEntry{limiter: NewPacketLimiter(l.cfg)}
+		l.ips[ip] = entry
+	}
+	entry.lastSeen = now
+	l.mu.Unlock()
+
+	allowed, wait, _ := entry.limiter.Allow(packetType)
+	return allowed, wait
+}
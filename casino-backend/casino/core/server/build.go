@@ -1,32 +1,51 @@
 package server
 
 import (
-	"encoding/json"
-	"jhgambling/backend/core/utils"
+	"errors"
+	"jhgambling/backend/core/codec"
+	"sync"
 )
 
-// BuildPacket creates a WebsocketPacket and serializes it into a sendable JSON format.
-func BuildPacket(packetType string, payload interface{}, nonce uint64) ([]byte, error) {
-	// Serialize the payload into JSON
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		utils.Log("error", "casino::server", "error marshaling payload:", err)
-		return nil, err
+// Packet is a packet that is ready to be sent. It is encoded with the codec of each
+// client it is sent to, and only once per codec when it goes to many clients.
+type Packet struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+	Nonce   uint64      `json:"nonce,omitempty"`
+
+	mu      sync.Mutex
+	encoded map[string][]byte
+}
+
+// BuildPacket creates a packet from a payload struct. Encoding happens when it is sent.
+func BuildPacket(packetType string, payload interface{}, nonce uint64) (*Packet, error) {
+	if packetType == "" {
+		return nil, errors.New("packets need a type")
 	}
 
-	// Create the WebsocketPacket
-	wsPacket := WebsocketPacket{
+	return &Packet{
 		Type:    packetType,
-		Payload: payloadBytes,
+		Payload: payload,
 		Nonce:   nonce,
+	}, nil
+}
+
+// Encode returns the message of the packet in the given encoding
+func (p *Packet) Encode(c codec.Codec) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if data, ok := p.encoded[c.Name()]; ok {
+		return data, nil
 	}
 
-	// Serialize the WebsocketPacket into JSON
-	packetBytes, err := json.Marshal(wsPacket)
+	data, err := c.Marshal(p)
 	if err != nil {
-		utils.Log("error", "casino::server", "error marshaling websocket packet:", err)
 		return nil, err
 	}
-
-	return packetBytes, nil
+	if p.encoded == nil {
+		p.encoded = map[string][]byte{}
+	}
+	p.encoded[c.Name()] = data
+	return data, nil
 }
@@ -1,9 +1,11 @@
 package server
 
 import (
-	"encoding/json"
+	"jhgambling/backend/core/codec"
 	"jhgambling/backend/core/utils"
 	"jhgambling/protocol"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,25 +15,58 @@ type GatewayClient struct {
 	IncomingChan chan []byte // Channel for messages coming from WebSocket
 	OutgoingChan chan []byte // Channel for messages going to WebSocket
 
+	codec codec.Codec // Wire encoding of the connection, chosen when it is opened
+
 	handlerContext HandlerContext
 
-	clientType              string // Type of client (e.g. "app", "game-sdk")
+	clientType      string // Type of client (e.g. "app", "game-sdk")
+	protocolVersion int    // Set by the hello packet
+	capabilities    []string
+	session         atomic.Uint64 // Read by the handlers of other clients in the same session
+
+	// Logouts of other clients revoke the authentication from their goroutine, so it is guarded by authMu
+	authMu                  sync.RWMutex
 	isAuthenticated         bool
 	authenticatedAs         uint
 	authenticationExpriesAt time.Time
-	session                 uint
+	authSessionID           string // Login session the access token belongs to
+
+	// Updates are sent from the goroutine of the subscription manager, so they are guarded by subscriptionsMu
+	subscriptions   []DBSubscription
+	subscriptionsMu sync.Mutex
+
+	joinedGames []JoinedGame
+	gamesMu     sync.Mutex
 
-	Subscriptions []DBSubscription
+	limiter *PacketLimiter
+
+	tracker requestTracker // Responses to the packet that is being handled
+	replays *replayCache
+
+	pending      map[uint64]pendingRequest // Requests of the server, by nonce
+	pendingMu    sync.Mutex
+	pendingCount atomic.Int64
+	nextNonce    atomic.Uint64
+
+	busy      atomic.Bool   // Set while a packet of the client is handled
+	done      chan struct{} // Closed when the connection should be dropped
+	closeOnce sync.Once
 }
 
-func NewGatewayClient(addr string, ctx GatewayContext) *GatewayClient {
+func NewGatewayClient(addr string, wireCodec codec.Codec, ctx GatewayContext) *GatewayClient {
 	client := &GatewayClient{
 		ID:           utils.GenerateID(),
 		Addr:         addr,
 		IncomingChan: make(chan []byte, 100),
 		OutgoingChan: make(chan []byte, 100),
+		codec:        wireCodec,
 
-		Subscriptions: []DBSubscription{},
+		subscriptions: []DBSubscription{},
+		joinedGames:   []JoinedGame{},
+		limiter:       NewPacketLimiter(ctx.Config.Server.RateLimit),
+		replays:       newReplayCache(ctx.Config.Server.Requests),
+		pending:       map[uint64]pendingRequest{},
+		done:          make(chan struct{}),
 	}
 
 	client.handlerContext = HandlerContext{
@@ -42,118 +77,190 @@ func NewGatewayClient(addr string, ctx GatewayContext) *GatewayClient {
 	return client
 }
 
-// Send queues a message to be sent to the WebSocket client
-func (gc *GatewayClient) Send(message []byte) {
+// Send encodes a packet and queues it to be sent to the WebSocket client
+func (gc *GatewayClient) Send(packet *Packet) {
+	message, err := packet.Encode(gc.codec)
+	if err != nil {
+		utils.Log("error", "casino::gateway", "error encoding '", packet.Type, "' as ", gc.codec.Name(), ": ", err)
+		return
+	}
+	gc.tracker.track(packet)
+
 	select {
 	case gc.OutgoingChan <- message:
 		// Message queued successfully
 	default:
-		utils.Log("error", "casino::gateway", "outgoing channel full for client: ", gc.ID)
+		// The client doesn't read fast enough. Dropping single messages would leave it
+		// with an inconsistent state, so it gets disconnected and has to resync.
+		utils.Log("error", "casino::gateway", "outgoing channel full for client: ", gc.ID, ", disconnecting")
+		gc.Disconnect()
 	}
 }
 
 // HandleIncomingMessage processes a message from the WebSocket
 func (gc *GatewayClient) ProcessIncomingMessage(message []byte) {
 	var packet WebsocketPacket
-	if err := json.Unmarshal(message, &packet); err != nil {
+	if err := gc.codec.Unmarshal(message, &packet); err != nil {
 		utils.Log("warn", "casino::gateway", "error unmarshaling data: ", err)
 		return
 	}
 
+	if isServerResponse(packet) {
+		if !gc.resolveRequest(packet) {
+			utils.Log("debug", "casino::gateway", "client ", gc.ID, " answered '", packet.Type, "' after the request timed out")
+		}
+		return
+	}
+
+	if ok, wait, abusive := gc.limiter.Allow(packet.Type); !ok {
+		if abusive {
+			utils.Log("warn", "casino::gateway", "client ", gc.ID, " (", gc.Addr, ") keeps exceeding the rate limit, disconnecting")
+			gc.Disconnect()
+			return
+		}
+
+		gc.SendRateLimitedPacket(packet, wait)
+		return
+	}
+
 	gc.handleIncomingPacket(packet)
 }
 
 func (gc *GatewayClient) handleIncomingPacket(packet WebsocketPacket) {
-	switch packet.Type {
-	case "auth/register":
-		var payload AuthRegisterPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "auth/login":
-		var payload AuthLoginPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "auth/authenticate":
-		var payload AuthAuthenticatePacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "auth/does_user_exist":
-		var payload DoesUserExistPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "db/op":
-		var payload DatabaseOperationPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "db/sub":
-		var payload DatabaseSubscribePacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "ping":
-		// Simply respond with a pong to keep the connection alive
-		if res, err := BuildPacket("pong", map[string]interface{}{}, packet.Nonce); err == nil {
+	def, ok := gc.handlerContext.Gateway.Packets.Get(packet.Type)
+	if !ok {
+		utils.Log("warn", "casino::gateway", "unknown packet type: ", packet.Type)
+		if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorProtocolUnknownPacket, "unknown packet type"), packet.Nonce); err == nil {
 			gc.Send(res)
 		}
-		break
-	case "client/set_session":
-		var payload SetSessionPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
-		}
-		break
-	case "game/finished_loading":
-		var payload GameFinishedLoadingPacket
-		if gc.unmarshalPayload(packet.Payload, &payload) {
-			payload.Handle(packet, &gc.handlerContext)
+		return
+	}
+	if !gc.requireHello(packet) {
+		return
+	}
+	if gc.handlerContext.Gateway.ShuttingDown() {
+		if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorShuttingDown, "the server is shutting down"), packet.Nonce); err == nil {
+			gc.Send(res)
 		}
-		break
-	default:
-		utils.Log("warn", "casino::gateway", "unknown packet type: ", packet.Type)
 		return
 	}
+
+	gc.dispatch(def, packet)
 }
 
-func (gc *GatewayClient) unmarshalPayload(payload json.RawMessage, v any) bool {
-	if err := json.Unmarshal(payload, v); err != nil {
+// unmarshalPayload decodes the payload of a packet and answers with INVALID_REQUEST if it can't be decoded
+func (gc *GatewayClient) unmarshalPayload(packet WebsocketPacket, v any) bool {
+	payloadCodec, payload := gc.codec, []byte(packet.Payload)
+	// Packets like ping don't need to send a payload
+	if len(payload) == 0 {
+		payloadCodec, payload = codec.JSON, []byte("{}")
+	}
+	if err := payloadCodec.Unmarshal(payload, v); err != nil {
 		utils.Log("warn", "casino::gateway", "error unmarshalling data: ", err)
+		if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorInvalidRequest, "invalid payload"), packet.Nonce); err == nil {
+			gc.Send(res)
+		}
 		return false
 	}
 	return true
 }
 
 func (gc *GatewayClient) IsAuthenticated() bool {
+	gc.authMu.RLock()
+	defer gc.authMu.RUnlock()
+	return gc.isAuthenticatedLocked()
+}
+
+func (gc *GatewayClient) isAuthenticatedLocked() bool {
 	return gc.isAuthenticated && time.Now().Unix() < gc.authenticationExpriesAt.Unix()
 }
 
-func (gc *GatewayClient) Authenticate(userID uint, expiresAt time.Time) {
+func (gc *GatewayClient) Authenticate(userID uint, sessionID string, expiresAt time.Time) {
+	gc.authMu.Lock()
+	defer gc.authMu.Unlock()
 	gc.isAuthenticated = true
 	gc.authenticatedAs = userID
+	gc.authSessionID = sessionID
 	gc.authenticationExpriesAt = expiresAt
 }
 
+// RefreshAuthentication extends the authentication if the client is still authenticated with the session,
+// it returns false if it isn't, e.g. because the session has been revoked in the meantime
+func (gc *GatewayClient) RefreshAuthentication(sessionID string, expiresAt time.Time) bool {
+	gc.authMu.Lock()
+	defer gc.authMu.Unlock()
+	if !gc.isAuthenticatedLocked() || gc.authSessionID != sessionID {
+		return false
+	}
+	gc.authenticationExpriesAt = expiresAt
+	return true
+}
+
 func (gc *GatewayClient) RevokeAuthentication() {
+	gc.authMu.Lock()
+	defer gc.authMu.Unlock()
+	gc.revokeAuthenticationLocked()
+}
+
+func (gc *GatewayClient) revokeAuthenticationLocked() {
 	gc.isAuthenticated = false
 	gc.authenticatedAs = 0
+	gc.authSessionID = ""
 	gc.authenticationExpriesAt = time.UnixMicro(0)
 }
 
+// revokeSessions logs the client out if it authenticated with one of the sessions and returns that session
+func (gc *GatewayClient) revokeSessions(sessionIDs map[string]bool) (string, bool) {
+	gc.authMu.Lock()
+	defer gc.authMu.Unlock()
+	sessionID := gc.authSessionID
+	if sessionID == "" || !sessionIDs[sessionID] {
+		return "", false
+	}
+	gc.revokeAuthenticationLocked()
+	return sessionID, true
+}
+
+// GetAuthenticatedUserID returns the user the client is authenticated as, 0 if it isn't
+func (gc *GatewayClient) GetAuthenticatedUserID() uint {
+	userID, _, _ := gc.GetAuthentication()
+	return userID
+}
+
+// GetAuthSessionID returns the login session the client authenticated with
+func (gc *GatewayClient) GetAuthSessionID() string {
+	_, sessionID, _ := gc.GetAuthentication()
+	return sessionID
+}
+
+// GetAuthentication returns the user and the session of the client at once, ok is false if it isn't authenticated
+func (gc *GatewayClient) GetAuthentication() (userID uint, sessionID string, ok bool) {
+	gc.authMu.RLock()
+	defer gc.authMu.RUnlock()
+	if !gc.isAuthenticatedLocked() {
+		return 0, "", false
+	}
+	return gc.authenticatedAs, gc.authSessionID, true
+}
+
+// Disconnect asks the server to close the connection once all queued messages are sent
+func (gc *GatewayClient) Disconnect() {
+	gc.closeOnce.Do(func() {
+		close(gc.done)
+	})
+}
+
+// Done is closed when the client has been disconnected by the server
+func (gc *GatewayClient) Done() <-chan struct{} {
+	return gc.done
+}
+
 func (gc *GatewayClient) SendUnauthorizedPacket(nonce uint64) {
 	if res, err := BuildPacket("res",
 		ResponsePacket{
 			Success: false,
 			Status:  "unauthorized",
+			Code:    protocol.ErrorUnauthorized,
 			Message: "You have to be authorized to interact",
 		},
 		nonce); err == nil {
@@ -174,14 +281,19 @@ func (gc *GatewayClient) SendSubscriptionUpdatePacket(record protocol.SubChanged
 	}
 }
 
+// Codec returns the wire encoding of the connection
+func (gc *GatewayClient) Codec() codec.Codec {
+	return gc.codec
+}
+
 func (gc *GatewayClient) GetClientType() string {
 	return gc.clientType
 }
 
 func (gc *GatewayClient) SetSession(session uint) {
-	gc.session = session
+	gc.session.Store(uint64(session))
 }
 
 func (gc *GatewayClient) GetSession() uint {
-	return gc.session
+	return uint(gc.session.Load())
 }
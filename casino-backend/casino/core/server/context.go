@@ -1,12 +1,16 @@
 package server
 
 import (
+	"errors"
 	"jhgambling/backend/core/auth"
+	"jhgambling/backend/core/config"
 	"jhgambling/backend/core/data"
 	"jhgambling/backend/core/game"
+	"jhgambling/protocol/models"
 )
 
 type GatewayContext struct {
+	Config   *config.Config
 	Database *data.Database
 	Auth     *auth.AuthManager
 	Gateway  *Gateway
@@ -18,3 +22,22 @@ type HandlerContext struct {
 
 	GatewayContext
 }
+
+// GetUser loads the user the client is authenticated as
+func (ctx *HandlerContext) GetUser() (*models.UserModel, error) {
+	if !ctx.Client.IsAuthenticated() {
+		return nil, errors.New("client is not authenticated")
+	}
+
+	user, err := ctx.Database.GetUserTable().FindByID(ctx.Client.GetAuthenticatedUserID())
+	if err != nil {
+		return nil, err
+	}
+
+	userModel, ok := user.(*models.UserModel)
+	if !ok {
+		return nil, errors.New("invalid user model type")
+	}
+
+	return userModel, nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+)
+
+// ErrorInfo is what clients get to see of an error
+type ErrorInfo struct {
+	Code    protocol.ErrorCode `json:"code"`
+	Message string             `json:"message"`
+}
+
+// failed builds the response of a request that failed for a reason from the catalog
+func failed(code protocol.ErrorCode, message string) ResponsePacket {
+	return ResponsePacket{Success: false, Status: "failed", Code: code, Message: message}
+}
+
+// errorInfo converts an error for a client. Errors from the catalog keep their code
+// and message, everything else is logged and only reported as an internal error.
+func (ctx *HandlerContext) errorInfo(wsPacket WebsocketPacket, err error) ErrorInfo {
+	if coded, ok := protocol.AsError(err); ok {
+		if coded.Cause != nil {
+			utils.Log("debug", "casino::gateway", "[", wsPacket.Type, "] ", coded.Code, ": ", err)
+		}
+		return ErrorInfo{Code: coded.Code, Message: coded.Message}
+	}
+
+	// The reference lets support find the log entry without telling the client what went wrong
+	ref := utils.GenerateID()
+	utils.Log("error", "casino::gateway", "[", wsPacket.Type, "] internal error (ref ", ref, ") for client ", ctx.Client.ID, ": ", err)
+	return ErrorInfo{Code: protocol.ErrorInternal, Message: "internal error (ref " + ref + ")"}
+}
+
+// failedWith builds the response of a request that failed with an error
+func (ctx *HandlerContext) failedWith(wsPacket WebsocketPacket, err error) ResponsePacket {
+	info := ctx.errorInfo(wsPacket, err)
+	return failed(info.Code, info.Message)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"jhgambling/backend/core/game"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"time"
+)
+
+// JoinedGame references a game instance a client has joined
+type JoinedGame struct {
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+// GetGameClient returns the representation of this client that is handed to game instances
+func (gc *GatewayClient) GetGameClient() protocol.GameClient {
+	return protocol.GameClient{
+		ID:     gc.ID,
+		UserID: gc.GetAuthenticatedUserID(),
+	}
+}
+
+// HasJoinedGame returns whether the client is currently part of the given game instance
+func (gc *GatewayClient) HasJoinedGame(providerID, instanceID string) bool {
+	gc.gamesMu.Lock()
+	defer gc.gamesMu.Unlock()
+
+	for _, g := range gc.joinedGames {
+		if g.ProviderID == providerID && g.InstanceID == instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetJoinedGames returns a copy of all game instances the client has joined
+func (gc *GatewayClient) GetJoinedGames() []JoinedGame {
+	gc.gamesMu.Lock()
+	defer gc.gamesMu.Unlock()
+
+	games := make([]JoinedGame, len(gc.joinedGames))
+	copy(games, gc.joinedGames)
+	return games
+}
+
+// JoinGame adds the client to a game instance and notifies the instance.
+// Returns false if the client already joined the instance.
+func (gc *GatewayClient) JoinGame(instance protocol.GameInstance) bool {
+	gc.gamesMu.Lock()
+	for _, g := range gc.joinedGames {
+		if g.ProviderID == instance.GetProviderID() && g.InstanceID == instance.GetID() {
+			gc.gamesMu.Unlock()
+			return false
+		}
+	}
+	gc.joinedGames = append(gc.joinedGames, JoinedGame{
+		ProviderID: instance.GetProviderID(),
+		InstanceID: instance.GetID(),
+	})
+	gc.gamesMu.Unlock()
+
+	instance.HandleClientJoin(gc.GetGameClient())
+	return true
+}
+
+// LeaveGame removes the client from a game instance and notifies the instance.
+// Returns false if the client was not part of the instance.
+func (gc *GatewayClient) LeaveGame(instance protocol.GameInstance) bool {
+	gc.gamesMu.Lock()
+	found := gc.removeJoinedGame(JoinedGame{
+		ProviderID: instance.GetProviderID(),
+		InstanceID: instance.GetID(),
+	})
+	gc.gamesMu.Unlock()
+
+	if found {
+		instance.HandleClientLeave(gc.ID)
+	}
+	return found
+}
+
+// LeaveAllGames removes the client from every game instance it has joined
+func (gc *GatewayClient) LeaveAllGames(games *game.GameManager) {
+	for _, g := range gc.GetJoinedGames() {
+		instance := games.GetInstanceByID(g.ProviderID, g.InstanceID)
+		if instance == nil {
+			// The instance is gone already, just forget about it
+			gc.gamesMu.Lock()
+			gc.removeJoinedGame(g)
+			gc.gamesMu.Unlock()
+			continue
+		}
+
+		gc.LeaveGame(instance)
+		utils.Log("debug", "casino::gateway", "[game] client ", gc.ID, " left '", g.ProviderID, "/", g.InstanceID, "'")
+	}
+}
+
+// removeJoinedGame drops a reference without notifying the instance, gamesMu has to be held
+func (gc *GatewayClient) removeJoinedGame(game JoinedGame) bool {
+	for i, g := range gc.joinedGames {
+		if g == game {
+			gc.joinedGames = append(gc.joinedGames[:i], gc.joinedGames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SendGamePacket sends a packet originating from a game instance to the client
+func (gc *GatewayClient) SendGamePacket(providerID, instanceID string, packet protocol.GamePacket) error {
+	res, err := BuildPacket("game/packet", GameInstancePacket{
+		ProviderID: providerID,
+		InstanceID: instanceID,
+		Packet:     packet,
+	}, packet.Nonce)
+	if err != nil {
+		return err
+	}
+
+	gc.Send(res)
+	return nil
+}
+
+// RequestGamePacket sends a game packet as "game/request" and waits for the client to answer
+// with "game/request:res", whose payload is the game packet of the answer
+func (gc *GatewayClient) RequestGamePacket(providerID, instanceID string, packet protocol.GamePacket, timeout time.Duration) (protocol.GamePacket, error) {
+	if !gc.HasJoinedGame(providerID, instanceID) {
+		return protocol.GamePacket{}, protocol.NewError(protocol.ErrorGameNotJoined, "the client is not part of this game instance")
+	}
+
+	payload, err := gc.Request("game/request", GameInstancePacket{
+		ProviderID: providerID,
+		InstanceID: instanceID,
+		Packet:     packet,
+	}, timeout)
+	if err != nil {
+		return protocol.GamePacket{}, err
+	}
+
+	var answer protocol.GamePacket
+	if err := json.Unmarshal(payload, &answer); err != nil {
+		return protocol.GamePacket{}, protocol.NewError(protocol.ErrorInvalidRequest, "invalid answer of the client").WithCause(err)
+	}
+	return answer, nil
+}
+
+// GetClient returns a connected client by ID or nil if it does not exist
+func (g *Gateway) GetClient(clientID string) *GatewayClient {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.Clients[clientID]
+}
+
+// GetClientsInGame returns all clients that joined the given game instance
+func (g *Gateway) GetClientsInGame(providerID, instanceID string) []*GatewayClient {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	clients := []*GatewayClient{}
+	for _, client := range g.Clients {
+		if client.HasJoinedGame(providerID, instanceID) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// KickFromGame removes all clients from a game instance that has been closed and tells them with "game/closed".
+// The instance isn't told that the clients left, it is gone already.
+func (g *Gateway) KickFromGame(instance protocol.GameInstance) {
+	providerID, instanceID := instance.GetProviderID(), instance.GetID()
+	closed, _ := BuildPacket("game/closed", GameClosedPacket{
+		ProviderID: providerID,
+		InstanceID: instanceID,
+	}, 0)
+	for _, c := range g.GetClientsInGame(providerID, instanceID) {
+		c.gamesMu.Lock()
+		c.removeJoinedGame(JoinedGame{ProviderID: providerID, InstanceID: instanceID})
+		c.gamesMu.Unlock()
+		if closed != nil {
+			c.Send(closed)
+		}
+	}
+}
+
+// GetClientsOfUser returns all authenticated clients of a user
+func (g *Gateway) GetClientsOfUser(userID uint) []*GatewayClient {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	clients := []*GatewayClient{}
+	for _, client := range g.Clients {
+		if client.GetAuthenticatedUserID() == userID {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
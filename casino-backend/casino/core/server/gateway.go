@@ -1,16 +1,26 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"jhgambling/backend/core/utils"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// How often a shutdown checks whether the clients are done
+const shutdownPollInterval = time.Millisecond * 10
+
 type Gateway struct {
 	Clients map[string]*GatewayClient
 	mu      sync.Mutex
 
 	Subscriptions *SubscriptionManager
+	AuthLimiter   *AuthLimiter
+	Packets       *PacketRegistry
+
+	shuttingDown atomic.Bool
 
 	ctx GatewayContext
 }
@@ -22,6 +32,9 @@ func NewGateway(ctx GatewayContext) *Gateway {
 	}
 
 	gw.Subscriptions = NewSubscriptionsManager(gw)
+	gw.AuthLimiter = NewAuthLimiter(ctx.Config.Auth.RateLimit)
+	gw.Packets = NewPacketRegistry()
+	RegisterDefaultPackets(gw.Packets)
 	gw.ctx.Gateway = gw
 
 	return gw
@@ -38,15 +51,74 @@ func (g *Gateway) AddClient(client *GatewayClient) {
 
 func (g *Gateway) RemoveClient(clientID string) {
 	g.mu.Lock()
-	defer g.mu.Unlock()
-	if _, exists := g.Clients[clientID]; exists {
+	client, exists := g.Clients[clientID]
+	if exists {
 		delete(g.Clients, clientID)
 		utils.Log("info", "casino::gateway", ">> client removed: ", clientID)
 	}
+	g.mu.Unlock()
+
+	// Ends requests of the server that are still waiting for the client
+	if exists {
+		client.Disconnect()
+	}
+
+	// Let all game instances know that the client is gone. This happens
+	// outside of the lock, since games may want to message other clients.
+	if exists && g.ctx.Games != nil {
+		client.LeaveAllGames(g.ctx.Games)
+	}
+}
+
+// DropSessions logs out and disconnects all clients that authenticated with one of the sessions.
+// The except client (may be nil) is only logged out, since it requested the logout itself.
+func (g *Gateway) DropSessions(sessionIDs []string, except *GatewayClient) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+
+	for _, client := range g.GetClients() {
+		// Checked and revoked at once, the client may log in again on its own goroutine
+		sessionID, ok := client.revokeSessions(revoked)
+		if !ok {
+			continue
+		}
+
+		if g.ctx.Games != nil {
+			client.LeaveAllGames(g.ctx.Games)
+		}
+
+		if client == except {
+			continue
+		}
+
+		utils.Log("info", "casino::gateway", "[Auth] dropping client ", client.ID, " of revoked session ", sessionID)
+		if res, err := BuildPacket("auth/revoked", AuthRevokedPacket{SessionID: sessionID}, 0); err == nil {
+			client.Send(res)
+		}
+		client.Disconnect()
+	}
+}
+
+// GetClients returns a snapshot of the connected clients
+func (g *Gateway) GetClients() []*GatewayClient {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	clients := make([]*GatewayClient, 0, len(g.Clients))
+	for _, client := range g.Clients {
+		clients = append(clients, client)
+	}
+	return clients
 }
 
 // Broadcast sends a message to all connected clients
-func (g *Gateway) Broadcast(message []byte) {
+func (g *Gateway) Broadcast(message *Packet) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	for _, client := range g.Clients {
@@ -55,7 +127,7 @@ func (g *Gateway) Broadcast(message []byte) {
 }
 
 // SendToClient sends a message to a specific client by ID
-func (g *Gateway) SendToClient(clientID string, message []byte) error {
+func (g *Gateway) SendToClient(clientID string, message *Packet) error {
 	g.mu.Lock()
 	client, exists := g.Clients[clientID]
 	g.mu.Unlock()
@@ -78,8 +150,80 @@ func (g *Gateway) StartClientHandler(client *GatewayClient) {
 					return
 				}
 				// Process the message
+				client.busy.Store(true)
 				client.ProcessIncomingMessage(msg)
+				client.busy.Store(false)
 			}
 		}
 	}()
 }
+
+// ShuttingDown reports whether the gateway stopped handling new packets
+func (g *Gateway) ShuttingDown() bool {
+	return g.shuttingDown.Load()
+}
+
+// Shutdown tells all clients that the server stops and waits until the packets they sent
+// before are handled, or until ctx ends. Packets that arrive afterwards are rejected.
+func (g *Gateway) Shutdown(ctx context.Context, reason string) error {
+	g.shuttingDown.Store(true)
+
+	shutdown := ServerShutdownPacket{Reason: reason}
+	if deadline, ok := ctx.Deadline(); ok {
+		shutdown.ClosesAt = deadline.UnixMilli()
+	}
+	if res, err := BuildPacket("server/shutdown", shutdown, 0); err == nil {
+		g.Broadcast(res)
+	}
+
+	return g.waitFor(ctx, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, client := range g.Clients {
+			if client.busy.Load() || len(client.IncomingChan) > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// CloseGames closes every game instance and removes the clients from the instances that closed
+func (g *Gateway) CloseGames() {
+	if g.ctx.Games == nil {
+		return
+	}
+	for _, instance := range g.ctx.Games.CloseAll() {
+		g.KickFromGame(instance)
+	}
+}
+
+// DisconnectAll drops every client once the packets queued for it are sent,
+// and waits until the connections are closed or ctx ends
+func (g *Gateway) DisconnectAll(ctx context.Context) error {
+	g.mu.Lock()
+	for _, client := range g.Clients {
+		client.Disconnect()
+	}
+	g.mu.Unlock()
+
+	return g.waitFor(ctx, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.Clients) == 0
+	})
+}
+
+func (g *Gateway) waitFor(ctx context.Context, done func() bool) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -3,20 +3,45 @@ package server
 import (
 	"errors"
 	"fmt"
+	"jhgambling/backend/core/auth"
+	"jhgambling/backend/core/data/tables"
 	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
 	"jhgambling/protocol/models"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if !ctx.allowAuthAttempt(wsPacket, "") {
+		return
+	}
+
+	if strings.TrimSpace(packet.Username) == "" {
+		if res, err := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
+			ResponsePacket: failed(protocol.ErrorAuthInvalidUsername, "username cannot be empty"),
+		}, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+		return
+	}
+	if err := ctx.Auth.ValidatePassword(packet.Password, packet.Username); err != nil {
+		if res, err2 := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		}, wsPacket.Nonce); err2 == nil {
+			ctx.Client.Send(res)
+		}
+		return
+	}
+
 	_, err := ctx.Database.GetUserTable().FindByUsername(packet.Username)
 
 	if err == nil {
 		// FindByUsername succeeded -> User already exists
 		if res, err := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
-			ResponsePacket:    ResponsePacket{Success: false, Status: "failed", Message: "User already exists!"},
+			ResponsePacket:    failed(protocol.ErrorAuthUserExists, "User already exists!"),
 			UserAlreadyExists: true,
 		}, wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
@@ -26,11 +51,11 @@ func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerC
 		// FindByUsername failed -> Send error response if the error is not about the record not existing
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			if res, err2 := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
-				ResponsePacket:    ResponsePacket{Success: false, Status: "failed", Message: "internal error: " + err.Error()},
-				UserAlreadyExists: true,
+				ResponsePacket: ctx.failedWith(wsPacket, err),
 			}, wsPacket.Nonce); err2 == nil {
 				ctx.Client.Send(res)
 			}
+			return
 		}
 	}
 
@@ -38,10 +63,14 @@ func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerC
 
 	// Hash the password before storing
 	hash, err := ctx.Auth.HashPassword(packet.Password)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		ctx.Client.SendRateLimitedPacket(wsPacket, hasherBusyRetryAfter)
+		return
+	}
 	if err != nil {
-		if res, err := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: "Error hashing password!"},
-		}, wsPacket.Nonce); err == nil {
+		if res, err2 := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		}, wsPacket.Nonce); err2 == nil {
 			ctx.Client.Send(res)
 		}
 		return
@@ -55,22 +84,21 @@ func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerC
 		JoinedAt:     time.Now(),
 	}
 
-	// Create wallet with starting bonus
-	wallet := &models.WalletModel{
-		NetworthCents:         100000, // $1000 in cents
-		ReceivedStartingBonus: true,
-	}
-	user.Wallet = *wallet
-
+	// The user table creates an empty wallet, the starting bonus is credited through the ledger
 	err = ctx.Database.GetUserTable().Create(user)
 	if err != nil {
-		if res, err := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: "error creating user entry: " + err.Error()},
-		}, wsPacket.Nonce); err == nil {
+		if res, err2 := BuildPacket("auth/register:res", AuthRegisterResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		}, wsPacket.Nonce); err2 == nil {
 			ctx.Client.Send(res)
 		}
 		return
 	}
+
+	if err := ctx.Database.GetUserTable().GrantStartingBonus(user.Wallet.ID, ctx.Config.Wallet.StartingBonusCents); err != nil {
+		utils.Log("error", "casino::gateway", "failed to grant starting bonus to user ", user.ID, ": ", err)
+	}
+
 	utils.Log("ok", "casino::gateway",
 		"new user registered: ",
 		fmt.Sprintf(
@@ -81,19 +109,21 @@ func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerC
 		),
 	)
 
-	// Generate authentication token
-	token, err := ctx.Auth.CreateTokenForUser(user.ID)
+	// Start a session for the new user
+	tokens, err := ctx.Auth.CreateSession(user.ID, ctx.Client.Addr)
 
 	var response AuthRegisterResponsePacket
 	if err != nil {
 		response = AuthRegisterResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: fmt.Sprintf("internal error: %v", err)},
+			ResponsePacket: ctx.failedWith(wsPacket, err),
 		}
 	} else {
 		utils.Log("debug", "casino::gateway", "[Auth] user ", user.ID, " with username '", user.Username, "' has registered")
 		response = AuthRegisterResponsePacket{
 			ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
-			Token:          token,
+			Token:          tokens.AccessToken,
+			RefreshToken:   tokens.RefreshToken,
+			ExpiresAt:      tokens.ExpiresAt.UnixMilli(),
 		}
 	}
 
@@ -103,18 +133,21 @@ func (packet *AuthRegisterPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerC
 }
 
 func (packet *AuthAuthenticatePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
-	valid, userID, expiresAt := ctx.Auth.VerifyToken(packet.Token)
+	claims, err := ctx.Auth.VerifyToken(packet.Token)
 
-	if valid {
-		ctx.Client.Authenticate(userID, expiresAt)
-		ctx.Client.clientType = packet.ClientType
-		utils.Log("debug", "casino::gateway", "[Auth] user ", userID, " has been authenticated with type '", packet.ClientType, "'")
+	if err == nil {
+		ctx.Client.Authenticate(claims.UserID, claims.SessionID, claims.ExpiresAt)
+		if packet.ClientType != "" {
+			ctx.Client.clientType = packet.ClientType
+		}
+		utils.Log("debug", "casino::gateway", "[Auth] user ", claims.UserID, " has been authenticated with type '", packet.ClientType, "'")
 		// Send response
 		if res, err := BuildPacket("auth/authenticate:res",
 			AuthAuthenticateResponsePacket{
 				ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
-				UserID:         userID,
-				ExpiresAt:      expiresAt.UnixMilli(),
+				UserID:         claims.UserID,
+				SessionID:      claims.SessionID,
+				ExpiresAt:      claims.ExpiresAt.UnixMilli(),
 			},
 			wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
@@ -125,7 +158,7 @@ func (packet *AuthAuthenticatePacket) Handle(wsPacket WebsocketPacket, ctx *Hand
 		// Send response
 		if res, err := BuildPacket("auth/authenticate:res",
 			AuthAuthenticateResponsePacket{
-				ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: "invalid token"},
+				ResponsePacket: failed(protocol.ErrorAuthInvalidToken, "invalid token"),
 			},
 			wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
@@ -134,109 +167,238 @@ func (packet *AuthAuthenticatePacket) Handle(wsPacket WebsocketPacket, ctx *Hand
 }
 
 func (packet *AuthLoginPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if !ctx.allowAuthAttempt(wsPacket, packet.Username) {
+		return
+	}
+
+	uniformErrors := ctx.Config.Auth.RateLimit.UniformLoginErrors
+	loginFailed := AuthLoginResponsePacket{
+		ResponsePacket: failed(protocol.ErrorAuthInvalidCredentials, "Invalid username or password"),
+	}
+
 	user, err := ctx.Database.GetUserTable().FindByUsername(packet.Username)
 
 	if err != nil {
-		if res, err := BuildPacket("auth/login:res", AuthLoginResponsePacket{
-			ResponsePacket:   ResponsePacket{Success: false, Status: "failed", Message: "User not found!"},
+		// Unknown usernames count as failures too, otherwise lockouts would reveal which users exist
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), packet.Username)
+
+		response := AuthLoginResponsePacket{
+			ResponsePacket:   failed(protocol.ErrorAuthUserNotFound, "User not found!"),
 			UserDoesNotExist: true,
-		}, wsPacket.Nonce); err == nil {
+		}
+		if uniformErrors {
+			ctx.Auth.CheckDummyPassword(packet.Password)
+			response = loginFailed
+		}
+		if res, err := BuildPacket("auth/login:res", response, wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
 		}
 		return
 	}
 
 	// Check for correct password
-	if !ctx.Auth.CheckPasswordHash(packet.Password, user.PasswordHash) {
+	match, ok := ctx.checkPassword(wsPacket, user, packet.Password)
+	if !ok {
+		return
+	}
+	if !match {
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), packet.Username)
+
 		response := AuthLoginResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: "Wrong password"},
+			ResponsePacket: failed(protocol.ErrorAuthWrongPassword, "Wrong password"),
 			WrongPassword:  true,
 		}
+		if uniformErrors {
+			response = loginFailed
+		}
 		if res, err := BuildPacket("auth/login:res", response, wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
 		}
 		return
 	}
 
-	// Generate authentication token
-	token, err := ctx.Auth.CreateTokenForUser(user.ID)
+	// Accounts with 2FA need a second step before they get a session
+	if !ctx.checkSecondFactor(wsPacket, user, packet.Code) {
+		return
+	}
+
+	ctx.completeLogin(wsPacket, user)
+}
+
+// completeLogin starts a session for a user who passed all login steps and sends the tokens
+func (ctx *HandlerContext) completeLogin(wsPacket WebsocketPacket, user *models.UserModel) {
+	ctx.Gateway.AuthLimiter.RecordSuccess(ctx.Client.RemoteIP(), user.Username)
+
+	// Start a new session
+	tokens, err := ctx.Auth.CreateSession(user.ID, ctx.Client.Addr)
 
 	var response AuthLoginResponsePacket
 	if err != nil {
 		response = AuthLoginResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: fmt.Sprintf("internal error: %v", err)},
+			ResponsePacket: ctx.failedWith(wsPacket, err),
 		}
 	} else {
 		utils.Log("debug", "casino::gateway", "[Auth] user ", user.ID, " has logged in")
 		response = AuthLoginResponsePacket{
 			ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
-			Token:          token,
+			Token:          tokens.AccessToken,
+			RefreshToken:   tokens.RefreshToken,
+			ExpiresAt:      tokens.ExpiresAt.UnixMilli(),
 		}
 	}
 
-	if res, err := BuildPacket("auth/login:res", response, wsPacket.Nonce); err == nil {
+	if res, err := BuildPacket(wsPacket.Type+":res", response, wsPacket.Nonce); err == nil {
 		ctx.Client.Send(res)
 	}
 }
 
-func (packet *DatabaseOperationPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
-	if !ctx.Client.IsAuthenticated() {
-		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+func (packet *AuthRefreshPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if !ctx.allowAuthAttempt(wsPacket, "") {
 		return
 	}
 
-	start := time.Now()
-
-	userInterface, err := ctx.Database.GetUserTable().FindByID(ctx.Client.authenticatedAs)
+	tokens, err := ctx.Auth.RefreshSession(packet.RefreshToken)
 	if err != nil {
-		utils.Log("warn", "casino::gateway", "[db/op] error getting user:", err)
-		response := DatabaseOperationResponsePacket{
-			Op:         *packet,
-			Result:     nil,
-			Error:      err,
-			ExecTimeUs: time.Since(start).Microseconds(),
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), "")
+
+		if errors.Is(err, tables.ErrRefreshTokenReused) {
+			// The session has been revoked, anyone still using it gets dropped
+			ctx.Gateway.DropSessions([]string{auth.RefreshTokenSessionID(packet.RefreshToken)}, ctx.Client)
 		}
-		if res, err := BuildPacket("db/op:res", response, wsPacket.Nonce); err == nil {
+
+		utils.Log("debug", "casino::gateway", "[Auth] client failed to refresh session: ", err)
+		if res, err2 := BuildPacket("auth/refresh:res", AuthRefreshResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		}, wsPacket.Nonce); err2 == nil {
 			ctx.Client.Send(res)
 		}
 		return
 	}
 
-	// Convert the interface{} to models.UserModel
-	userModel, ok := userInterface.(*models.UserModel)
-	if !ok {
-		response := DatabaseOperationResponsePacket{
-			Op:         *packet,
-			Result:     nil,
-			Error:      "internal error: invalid user model type",
-			ExecTimeUs: time.Since(start).Microseconds(),
+	// Sessions started before 2FA became mandatory for the user end at the next refresh
+	if found, err := ctx.Database.GetUserTable().FindByID(tokens.UserID); err == nil {
+		if user := found.(*models.UserModel); ctx.Auth.TwoFactorRequired(user) && !ctx.Auth.TwoFactorEnabled(user.ID) {
+			ctx.Auth.RevokeSession(tokens.SessionID)
+			ctx.Gateway.DropSessions([]string{tokens.SessionID}, ctx.Client)
+
+			if res, err := BuildPacket("auth/refresh:res", AuthRefreshResponsePacket{
+				ResponsePacket: failed(protocol.ErrorAuth2FASetupRequired, "two-factor authentication is required for this account, log in again"),
+			}, wsPacket.Nonce); err == nil {
+				ctx.Client.Send(res)
+			}
+			return
 		}
-		if res, err := BuildPacket("db/op:res", response, wsPacket.Nonce); err == nil {
+	}
+
+	// Keep the connection authenticated if it belongs to the refreshed session
+	ctx.Client.RefreshAuthentication(tokens.SessionID, tokens.ExpiresAt)
+
+	if res, err := BuildPacket("auth/refresh:res", AuthRefreshResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+		Token:          tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		ExpiresAt:      tokens.ExpiresAt.UnixMilli(),
+	}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *AuthLogoutPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	sendResponse := func(response AuthLogoutResponsePacket) {
+		if res, err := BuildPacket("auth/logout:res", response, wsPacket.Nonce); err == nil {
 			ctx.Client.Send(res)
 		}
+	}
+
+	// Find out which session is being logged out
+	userID, sessionID, authenticated := ctx.Client.GetAuthentication()
+	if !authenticated {
+		if packet.RefreshToken == "" {
+			ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+			return
+		}
+
+		session, err := ctx.Auth.SessionOf(packet.RefreshToken)
+		if err != nil {
+			sendResponse(AuthLogoutResponsePacket{
+				ResponsePacket: ctx.failedWith(wsPacket, err),
+			})
+			return
+		}
+		userID = session.UserID
+		sessionID = session.SessionID
+	}
+
+	revoked := []string{sessionID}
+	var err error
+	if packet.AllDevices {
+		revoked, err = ctx.Auth.RevokeAllSessions(userID)
+	} else {
+		err = ctx.Auth.RevokeSession(sessionID)
+	}
+
+	// Sessions revoked before an error still have to be dropped
+	ctx.Gateway.DropSessions(revoked, ctx.Client)
+	ctx.Client.RevokeAuthentication()
+
+	if err != nil {
+		utils.Log("error", "casino::gateway", "[Auth] failed to log out user ", userID, ": ", err)
+		sendResponse(AuthLogoutResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		})
 		return
 	}
 
-	// Pass the concrete UserModel to PerformOperationAsUser
-	result, err := ctx.Database.PerformOperationAsUser(*userModel, packet.Table, packet.Operation, packet.OpId, packet.OpData)
+	utils.Log("debug", "casino::gateway", "[Auth] user ", userID, " logged out of ", len(revoked), " session(s)")
+	sendResponse(AuthLogoutResponsePacket{
+		ResponsePacket:  ResponsePacket{Success: true, Status: "ok"},
+		RevokedSessions: len(revoked),
+	})
+}
+
+func (packet *DatabaseOperationPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	start := time.Now()
+
+	var result interface{}
+	userModel, err := ctx.GetUser()
+	if err == nil {
+		result, err = ctx.Database.PerformOperationAsUser(*userModel, packet.Table, packet.Operation, packet.OpId, packet.OpData)
+	}
 
 	response := DatabaseOperationResponsePacket{
 		Op:     *packet,
 		Result: result,
-		Error:  err,
 
 		ExecTimeUs: time.Since(start).Microseconds(),
 	}
+	if err != nil {
+		info := ctx.errorInfo(wsPacket, err)
+		response.Error = &info
+	}
 	if res, err := BuildPacket("db/op:res", response, wsPacket.Nonce); err == nil {
 		ctx.Client.Send(res)
 	}
 }
 
 func (packet *DoesUserExistPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if ctx.Config.Auth.RateLimit.UniformLoginErrors {
+		// The answer would undo hiding which usernames exist on login
+		if res, err := BuildPacket("auth/does_user_exist:res", DoesUserExistResponsePacket{
+			ResponsePacket: failed(protocol.ErrorAuthLookupDisabled, "username lookups are disabled"),
+		}, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+		return
+	}
+
+	if !ctx.allowAuthAttempt(wsPacket, "") {
+		return
+	}
+
 	result, err := ctx.Database.GetUserTable().FindByUsername(packet.Username)
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		response := DoesUserExistResponsePacket{
-			ResponsePacket: ResponsePacket{Success: false, Status: "failed", Message: "internal error: " + err.Error()},
+			ResponsePacket: ctx.failedWith(wsPacket, err),
 			UserExists:     false,
 		}
 		if res, err := BuildPacket("auth/does_user_exist:res", response, wsPacket.Nonce); err == nil {
@@ -255,44 +417,66 @@ func (packet *DoesUserExistPacket) Handle(wsPacket WebsocketPacket, ctx *Handler
 }
 
 func (packet *DatabaseSubscribePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
-	if !ctx.Client.IsAuthenticated() {
-		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
-		return
-	}
+	utils.Log("debug", "casino::gateway", "[db/sub] user:", ctx.Client.GetAuthenticatedUserID(), " op:'", packet.Operation, "' table:", packet.TableID, " resource:", packet.ResourceID)
 
-	utils.Log("debug", "casino::gateway", "[db/sub] user:", ctx.Client.authenticatedAs, " op:'", packet.Operation, "' table:", packet.TableID, " resource:", packet.ResourceID)
+	response := DatabaseSubscribeResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+		Operation:      packet.Operation,
+		TableID:        packet.TableID,
+		ResourceID:     packet.ResourceID,
+	}
 
 	if packet.Operation == "subscribe" {
-		ctx.Client.Subscriptions = append(ctx.Client.Subscriptions, DBSubscription{
+		if _, err := ctx.Database.GetTable(packet.TableID); err != nil {
+			response.ResponsePacket = ctx.failedWith(wsPacket, err)
+		} else {
+			ctx.Client.Subscribe(DBSubscription{
+				TableID:    packet.TableID,
+				ResourceID: packet.ResourceID,
+			})
+		}
+	} else if packet.Operation == "unsubscribe" {
+		ctx.Client.Unsubscribe(DBSubscription{
 			TableID:    packet.TableID,
 			ResourceID: packet.ResourceID,
 		})
-	} else if packet.Operation == "unsubscribe" {
-		for i, sub := range ctx.Client.Subscriptions {
-			// Remove matching subscriptions
-			// or Remove all subscriptions (if tableID is empty)
-			if sub.TableID == packet.TableID && sub.ResourceID == packet.ResourceID || len(packet.TableID) == 0 {
-				ctx.Client.Subscriptions = append(ctx.Client.Subscriptions[:i], ctx.Client.Subscriptions[i+1:]...)
-				break
-			}
-		}
 	} else {
-		utils.Log("warn", "casino::gateway", "[db/sub] user ", ctx.Client.authenticatedAs, " tried to perform unkown db/sub operation: ", packet.Operation)
+		utils.Log("warn", "casino::gateway", "[db/sub] user ", ctx.Client.GetAuthenticatedUserID(), " tried to perform unkown db/sub operation: ", packet.Operation)
+		response.ResponsePacket = failed(protocol.ErrorInvalidRequest, "unknown operation '"+packet.Operation+"'")
+	}
+
+	if res, err := BuildPacket("db/sub:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
 	}
 }
 
 func (packet *SetSessionPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
 	ctx.Client.SetSession(packet.SessionID)
+
+	if res, err := BuildPacket("client/set_session:res", ResponsePacket{Success: true, Status: "ok"}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
 }
 
 func (packet *GameFinishedLoadingPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
-	for _, c := range ctx.Gateway.Clients {
-		if c.GetSession() == packet.SessionID {
-			// Client is part of the same session, so we can send the
-			// finished loading packet to the client
-			if res, err := BuildPacket("game/finished_loading", packet, wsPacket.Nonce); err == nil {
+	// The nonce belongs to the sender, for everyone else this is a notification
+	if res, err := BuildPacket("game/finished_loading", packet, 0); err == nil {
+		for _, c := range ctx.Gateway.GetClients() {
+			if c.GetSession() == packet.SessionID {
+				// Client is part of the same session, so we can send the
+				// finished loading packet to the client
 				c.Send(res)
 			}
 		}
 	}
+
+	if res, err := BuildPacket("game/finished_loading:res", ResponsePacket{Success: true, Status: "ok"}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *PingPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if res, err := BuildPacket("pong", map[string]interface{}{}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
 }
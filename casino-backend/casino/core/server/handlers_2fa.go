@@ -0,0 +1,263 @@
+package server
+
+import (
+	"jhgambling/backend/core/auth"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+)
+
+// checkSecondFactor makes sure a user who entered the right password also passed 2FA.
+// If not, it answers the login with the step the client has to take next.
+func (ctx *HandlerContext) checkSecondFactor(wsPacket WebsocketPacket, user *models.UserModel, code string) bool {
+	sendResponse := func(response AuthLoginResponsePacket) {
+		if res, err := BuildPacket(wsPacket.Type+":res", response, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	if ctx.Auth.TwoFactorEnabled(user.ID) {
+		if code == "" {
+			challenge, err := ctx.Auth.CreateChallenge(user.ID, auth.ChallengeLogin)
+			if err != nil {
+				sendResponse(AuthLoginResponsePacket{
+					ResponsePacket: ctx.failedWith(wsPacket, err),
+				})
+				return false
+			}
+			sendResponse(AuthLoginResponsePacket{
+				ResponsePacket: ResponsePacket{Success: false, Status: "2fa_required", Code: protocol.ErrorAuth2FARequired, Message: "Two-factor code required"},
+				Requires2FA:    true,
+				Challenge:      challenge,
+			})
+			return false
+		}
+
+		if err := ctx.Auth.VerifySecondFactor(user.ID, code); err != nil {
+			ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+			sendResponse(AuthLoginResponsePacket{
+				ResponsePacket: failed(protocol.ErrorAuth2FAInvalidCode, "Invalid two-factor code"),
+				Requires2FA:    true,
+			})
+			return false
+		}
+		return true
+	}
+
+	if ctx.Auth.TwoFactorRequired(user) {
+		challenge, err := ctx.Auth.CreateChallenge(user.ID, auth.ChallengeEnroll)
+		if err != nil {
+			sendResponse(AuthLoginResponsePacket{
+				ResponsePacket: ctx.failedWith(wsPacket, err),
+			})
+			return false
+		}
+		sendResponse(AuthLoginResponsePacket{
+			ResponsePacket:   ResponsePacket{Success: false, Status: "2fa_setup_required", Code: protocol.ErrorAuth2FASetupRequired, Message: "Two-factor authentication has to be set up for this account"},
+			Requires2FASetup: true,
+			Challenge:        challenge,
+		})
+		return false
+	}
+
+	return true
+}
+
+func (packet *AuthLogin2FAPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	userID, err := ctx.Auth.VerifyChallenge(packet.Challenge, auth.ChallengeLogin)
+	var user *models.UserModel
+	if err == nil {
+		if found, findErr := ctx.Database.GetUserTable().FindByID(userID); findErr == nil {
+			user = found.(*models.UserModel)
+		}
+	}
+	if user == nil {
+		if ctx.allowAuthAttempt(wsPacket, "") {
+			ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), "")
+			if res, err := BuildPacket("auth/login_2fa:res", AuthLoginResponsePacket{
+				ResponsePacket: ctx.failedWith(wsPacket, auth.ErrInvalidChallenge),
+			}, wsPacket.Nonce); err == nil {
+				ctx.Client.Send(res)
+			}
+		}
+		return
+	}
+
+	if !ctx.allowAuthAttempt(wsPacket, user.Username) {
+		return
+	}
+	if !ctx.checkSecondFactor(wsPacket, user, packet.Code) {
+		return
+	}
+
+	ctx.completeLogin(wsPacket, user)
+}
+
+// twoFactorSubject finds the user a 2FA setup packet is about, either the
+// authenticated user or the one a login enrollment challenge was issued for
+func (ctx *HandlerContext) twoFactorSubject(challenge string) (*models.UserModel, bool) {
+	if ctx.Client.IsAuthenticated() {
+		user, err := ctx.GetUser()
+		return user, err == nil
+	}
+
+	userID, err := ctx.Auth.VerifyChallenge(challenge, auth.ChallengeEnroll)
+	if err != nil {
+		return nil, false
+	}
+	found, err := ctx.Database.GetUserTable().FindByID(userID)
+	if err != nil {
+		return nil, false
+	}
+	return found.(*models.UserModel), true
+}
+
+func (packet *TwoFactorSetupPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, ok := ctx.twoFactorSubject(packet.Challenge)
+	if !ok {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+
+	response := TwoFactorSetupResponsePacket{}
+	setup, err := ctx.Auth.BeginTwoFactorSetup(user)
+	if err != nil {
+		response.ResponsePacket = ctx.failedWith(wsPacket, err)
+	} else {
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+		response.Secret = setup.Secret
+		response.URI = setup.URI
+	}
+
+	if res, err := BuildPacket("auth/2fa/setup:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *TwoFactorEnablePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	duringLogin := !ctx.Client.IsAuthenticated()
+	user, ok := ctx.twoFactorSubject(packet.Challenge)
+	if !ok {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+	if !ctx.allowAuthAttempt(wsPacket, user.Username) {
+		return
+	}
+
+	sendResponse := func(response TwoFactorEnableResponsePacket) {
+		if res, err := BuildPacket("auth/2fa/enable:res", response, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	codes, err := ctx.Auth.EnableTwoFactor(user.ID, packet.Code)
+	if err != nil {
+		if err == auth.ErrInvalidSecondFactor {
+			ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+		}
+		sendResponse(TwoFactorEnableResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+		})
+		return
+	}
+
+	utils.Log("info", "casino::gateway", "[Auth] user ", user.ID, " enabled two-factor authentication")
+	response := TwoFactorEnableResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+		RecoveryCodes:  codes,
+	}
+
+	// Enrolling was the last step of the login
+	if duringLogin {
+		ctx.Gateway.AuthLimiter.RecordSuccess(ctx.Client.RemoteIP(), user.Username)
+		tokens, err := ctx.Auth.CreateSession(user.ID, ctx.Client.Addr)
+		if err != nil {
+			// The recovery codes still have to reach the user, so the response stays successful
+			info := ctx.errorInfo(wsPacket, err)
+			response.Code = info.Code
+			response.Message = "two-factor authentication enabled, but logging in failed: " + info.Message
+		} else {
+			response.Token = tokens.AccessToken
+			response.RefreshToken = tokens.RefreshToken
+			response.ExpiresAt = tokens.ExpiresAt.UnixMilli()
+		}
+	}
+
+	sendResponse(response)
+}
+
+func (packet *TwoFactorDisablePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+	if !ctx.allowAuthAttempt(wsPacket, user.Username) {
+		return
+	}
+
+	fail := func(response ResponsePacket) {
+		if res, err := BuildPacket("auth/2fa/disable:res", TwoFactorDisableResponsePacket{
+			ResponsePacket: response,
+		}, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	if ctx.Auth.TwoFactorRequired(user) {
+		fail(failed(protocol.ErrorAuth2FAMandatory, "two-factor authentication is mandatory for this account"))
+		return
+	}
+	match, ok := ctx.checkPassword(wsPacket, user, packet.Password)
+	if !ok {
+		return
+	}
+	if !match {
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+		fail(failed(protocol.ErrorAuthWrongPassword, "Wrong password"))
+		return
+	}
+	if err := ctx.Auth.VerifySecondFactor(user.ID, packet.Code); err != nil {
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+		fail(ctx.failedWith(wsPacket, err))
+		return
+	}
+	if err := ctx.Auth.DisableTwoFactor(user.ID); err != nil {
+		fail(ctx.failedWith(wsPacket, err))
+		return
+	}
+
+	utils.Log("info", "casino::gateway", "[Auth] user ", user.ID, " disabled two-factor authentication")
+	if res, err := BuildPacket("auth/2fa/disable:res", TwoFactorDisableResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+	}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *TwoFactorRecoveryCodesPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+	if !ctx.allowAuthAttempt(wsPacket, user.Username) {
+		return
+	}
+
+	response := TwoFactorRecoveryCodesResponsePacket{}
+	if err := ctx.Auth.VerifySecondFactor(user.ID, packet.Code); err != nil {
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+		response.ResponsePacket = ctx.failedWith(wsPacket, err)
+	} else if codes, err := ctx.Auth.RegenerateRecoveryCodes(user.ID); err != nil {
+		response.ResponsePacket = ctx.failedWith(wsPacket, err)
+	} else {
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+		response.RecoveryCodes = codes
+	}
+
+	if res, err := BuildPacket("auth/2fa/recovery_codes:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
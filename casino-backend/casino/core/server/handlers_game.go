@@ -0,0 +1,173 @@
+package server
+
+import (
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+)
+
+func (packet *GameJoinPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	response := GameJoinResponsePacket{
+		ProviderID: packet.ProviderID,
+		InstanceID: packet.InstanceID,
+	}
+
+	instance := ctx.Games.GetInstanceByID(packet.ProviderID, packet.InstanceID)
+	if instance == nil {
+		response.ResponsePacket = failed(protocol.ErrorGameNotFound, "game instance not found")
+	} else if !ctx.Client.JoinGame(instance) {
+		response.ResponsePacket = failed(protocol.ErrorGameAlreadyJoined, "already joined this game instance")
+	} else {
+		utils.Log("debug", "casino::gateway", "[game] user ", ctx.Client.GetAuthenticatedUserID(), " joined '", packet.ProviderID, "/", packet.InstanceID, "'")
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+	}
+
+	if res, err := BuildPacket("game/join:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *GameLeavePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	response := GameLeaveResponsePacket{
+		ProviderID: packet.ProviderID,
+		InstanceID: packet.InstanceID,
+	}
+
+	instance := ctx.Games.GetInstanceByID(packet.ProviderID, packet.InstanceID)
+	if instance == nil {
+		response.ResponsePacket = failed(protocol.ErrorGameNotFound, "game instance not found")
+	} else if !ctx.Client.LeaveGame(instance) {
+		response.ResponsePacket = failed(protocol.ErrorGameNotJoined, "not part of this game instance")
+	} else {
+		utils.Log("debug", "casino::gateway", "[game] user ", ctx.Client.GetAuthenticatedUserID(), " left '", packet.ProviderID, "/", packet.InstanceID, "'")
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+	}
+
+	if res, err := BuildPacket("game/leave:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *GameInstancePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	// Only clients that joined the instance are allowed to talk to it
+	if !ctx.Client.HasJoinedGame(packet.ProviderID, packet.InstanceID) {
+		ctx.Client.sendGamePacketError(wsPacket.Nonce, protocol.ErrorGameNotJoined, "not part of this game instance")
+		return
+	}
+
+	instance := ctx.Games.GetInstanceByID(packet.ProviderID, packet.InstanceID)
+	if instance == nil {
+		ctx.Client.sendGamePacketError(wsPacket.Nonce, protocol.ErrorGameNotFound, "game instance not found")
+		return
+	}
+
+	// Fall back to the envelope nonce so games can correlate their responses
+	if packet.Packet.Nonce == 0 {
+		packet.Packet.Nonce = wsPacket.Nonce
+	}
+
+	instance.HandlePacket(ctx.Client.GetGameClient(), packet.Packet)
+
+	// Acknowledges the delivery, answers of the game are sent as game/packet with the same nonce
+	if res, err := BuildPacket("game/packet:res", ResponsePacket{Success: true, Status: "ok"}, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+// sendGamePacketError answers a game packet that could not be delivered
+func (gc *GatewayClient) sendGamePacketError(nonce uint64, code protocol.ErrorCode, message string) {
+	if res, err := BuildPacket("game/packet:res",
+		failed(code, message),
+		nonce); err == nil {
+		gc.Send(res)
+	}
+}
+
+func (packet *GameListPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	providers := []GameProviderInfo{}
+	for _, provider := range ctx.Games.GetAllProviders() {
+		if packet.ProviderID != "" && provider.GetID() != packet.ProviderID {
+			continue
+		}
+
+		info := GameProviderInfo{
+			ID:        provider.GetID(),
+			Name:      provider.GetName(),
+			Instances: []GameInstanceInfo{},
+		}
+		for _, instance := range provider.GetInstances() {
+			info.Instances = append(info.Instances, GameInstanceInfo{
+				ID:      instance.GetID(),
+				Users:   len(instance.GetUsers()),
+				Clients: len(ctx.Gateway.GetClientsInGame(provider.GetID(), instance.GetID())),
+			})
+		}
+		providers = append(providers, info)
+	}
+
+	response := GameListResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+		Providers:      providers,
+	}
+	if res, err := BuildPacket("game/list:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *GameCreatePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+
+	response := GameCreateResponsePacket{
+		ProviderID: packet.ProviderID,
+	}
+
+	instance, err := ctx.Games.CreateInstance(packet.ProviderID, packet.Config)
+	if err != nil {
+		utils.Log("warn", "casino::gateway", "[game] user ", user.ID, " failed to create instance of '", packet.ProviderID, "': ", err)
+		response.ResponsePacket = ctx.failedWith(wsPacket, err)
+	} else {
+		utils.Log("info", "casino::gateway", "[game] user ", user.ID, " created '", packet.ProviderID, "/", instance.GetID(), "'")
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+		response.InstanceID = instance.GetID()
+	}
+
+	if res, err := BuildPacket("game/create:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+func (packet *GameClosePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+
+	response := GameCloseResponsePacket{
+		ProviderID: packet.ProviderID,
+		InstanceID: packet.InstanceID,
+	}
+
+	instance := ctx.Games.GetInstanceByID(packet.ProviderID, packet.InstanceID)
+	if instance == nil {
+		response.ResponsePacket = failed(protocol.ErrorGameNotFound, "game instance not found")
+	} else {
+		if err := ctx.Games.CloseInstance(packet.ProviderID, packet.InstanceID); err != nil {
+			utils.Log("warn", "casino::gateway", "[game] user ", user.ID, " failed to close '", packet.ProviderID, "/", packet.InstanceID, "': ", err)
+			response.ResponsePacket = ctx.failedWith(wsPacket, err)
+		} else {
+			// Only now that the instance is gone, players stay in an instance that refused to close
+			ctx.Gateway.KickFromGame(instance)
+
+			utils.Log("info", "casino::gateway", "[game] user ", user.ID, " closed '", packet.ProviderID, "/", packet.InstanceID, "'")
+			response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+		}
+	}
+
+	if res, err := BuildPacket("game/close:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
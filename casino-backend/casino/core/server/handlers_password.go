@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"jhgambling/backend/core/auth"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"time"
+)
+
+func (packet *AuthChangePasswordPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+	if !ctx.allowAuthAttempt(wsPacket, user.Username) {
+		return
+	}
+
+	sendResponse := func(response AuthChangePasswordResponsePacket) {
+		if res, err := BuildPacket("auth/change_password:res", response, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+	fail := func(response ResponsePacket) {
+		sendResponse(AuthChangePasswordResponsePacket{
+			ResponsePacket: response,
+		})
+	}
+
+	match, ok := ctx.checkPassword(wsPacket, user, packet.CurrentPassword)
+	if !ok {
+		return
+	}
+	if !match {
+		// A stolen access token must not be enough to guess the password
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), user.Username)
+		fail(failed(protocol.ErrorAuthWrongPassword, "Wrong password"))
+		return
+	}
+	if packet.NewPassword == packet.CurrentPassword {
+		fail(failed(protocol.ErrorAuthPasswordUnchanged, "the new password has to be different from the current one"))
+		return
+	}
+	if err := ctx.Auth.ValidatePassword(packet.NewPassword, user.Username); err != nil {
+		fail(ctx.failedWith(wsPacket, err))
+		return
+	}
+
+	if err := setPassword(ctx, user, packet.NewPassword); errors.Is(err, auth.ErrHasherBusy) {
+		ctx.Client.SendRateLimitedPacket(wsPacket, hasherBusyRetryAfter)
+		return
+	} else if err != nil {
+		fail(ctx.failedWith(wsPacket, err))
+		return
+	}
+
+	// Everyone else who knew the old password gets logged out
+	revoked, err := ctx.Auth.RevokeOtherSessions(user.ID, ctx.Client.GetAuthSessionID())
+	ctx.Gateway.DropSessions(revoked, nil)
+	if err != nil {
+		utils.Log("error", "casino::gateway", "[Auth] failed to revoke sessions of user ", user.ID, " after password change: ", err)
+	}
+
+	utils.Log("info", "casino::gateway", "[Auth] user ", user.ID, " changed their password, ", len(revoked), " other session(s) revoked")
+	sendResponse(AuthChangePasswordResponsePacket{
+		ResponsePacket:  ResponsePacket{Success: true, Status: "ok"},
+		RevokedSessions: len(revoked),
+	})
+}
+
+func (packet *AuthCreateResetCodePacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	admin, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+
+	sendResponse := func(response AuthCreateResetCodeResponsePacket) {
+		if res, err := BuildPacket("auth/create_reset_code:res", response, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	var target *models.UserModel
+	if packet.UserID != 0 {
+		found, err := ctx.Database.GetUserTable().FindByID(packet.UserID)
+		if err == nil {
+			target = found.(*models.UserModel)
+		}
+	} else if packet.Username != "" {
+		target, _ = ctx.Database.GetUserTable().FindByUsername(packet.Username)
+	}
+	if target == nil || target.ID == 0 {
+		sendResponse(AuthCreateResetCodeResponsePacket{
+			ResponsePacket: failed(protocol.ErrorAuthUserNotFound, "user not found"),
+		})
+		return
+	}
+
+	// A reset code takes over the account, so it may not grant more than the issuer has already.
+	// Accounts that need 2FA are only reset by users with all permissions, the enrollment
+	// challenge would otherwise go to whoever holds the code.
+	allowed := admin.HasPermissionsOf(*target)
+	if ctx.Auth.TwoFactorRequired(target) && !admin.HasPermission(protocol.PermissionAll) {
+		allowed = false
+	}
+	if !allowed {
+		utils.Log("warn", "casino::gateway", "[Auth] user ", admin.ID, " tried to issue a password reset code for user ", target.ID, " who has more permissions")
+		sendResponse(AuthCreateResetCodeResponsePacket{
+			ResponsePacket: failed(protocol.ErrorUnauthorized, "you can't reset the password of a user with more permissions than you"),
+			UserID:         target.ID,
+		})
+		return
+	}
+
+	code, expiresAt, err := ctx.Auth.CreateResetCode(target.ID, admin.ID)
+	if err != nil {
+		sendResponse(AuthCreateResetCodeResponsePacket{
+			ResponsePacket: ctx.failedWith(wsPacket, err),
+			UserID:         target.ID,
+		})
+		return
+	}
+
+	utils.Log("info", "casino::gateway", "[Auth] user ", admin.ID, " issued a password reset code for user ", target.ID)
+	sendResponse(AuthCreateResetCodeResponsePacket{
+		ResponsePacket: ResponsePacket{Success: true, Status: "ok"},
+		UserID:         target.ID,
+		ResetCode:      code,
+		ExpiresAt:      expiresAt.UnixMilli(),
+	})
+}
+
+func (packet *AuthResetPasswordPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	if !ctx.allowAuthAttempt(wsPacket, packet.Username) {
+		return
+	}
+
+	sendResponse := func(response ResponsePacket) {
+		if res, err := BuildPacket("auth/reset_password:res", AuthResetPasswordResponsePacket{
+			ResponsePacket: response,
+		}, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	// Check the policy first, so a valid code isn't used up by a password that gets rejected
+	if err := ctx.Auth.ValidatePassword(packet.NewPassword, packet.Username); err != nil {
+		sendResponse(ctx.failedWith(wsPacket, err))
+		return
+	}
+
+	// Hashed before the code is used up, so busy hashing workers don't cost the user their code
+	hash, err := ctx.Auth.HashPassword(packet.NewPassword)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		ctx.Client.SendRateLimitedPacket(wsPacket, hasherBusyRetryAfter)
+		return
+	} else if err != nil {
+		sendResponse(ctx.failedWith(wsPacket, err))
+		return
+	}
+
+	// Unknown users and wrong codes get the same answer
+	user, err := ctx.Database.GetUserTable().FindByUsername(packet.Username)
+	if err == nil {
+		err = ctx.Auth.ResetPassword(user.ID, packet.Code, hash)
+	}
+	if err != nil {
+		ctx.Gateway.AuthLimiter.RecordFailure(ctx.Client.RemoteIP(), packet.Username)
+		sendResponse(failed(protocol.ErrorAuthInvalidResetCode, "invalid or expired reset code"))
+		return
+	}
+	ctx.Gateway.AuthLimiter.RecordSuccess(ctx.Client.RemoteIP(), packet.Username)
+
+	revoked, err := ctx.Auth.RevokeAllSessions(user.ID)
+	ctx.Gateway.DropSessions(revoked, nil)
+	if err != nil {
+		utils.Log("error", "casino::gateway", "[Auth] failed to revoke sessions of user ", user.ID, " after password reset: ", err)
+	}
+
+	utils.Log("info", "casino::gateway", "[Auth] user ", user.ID, " reset their password with a reset code")
+	sendResponse(ResponsePacket{Success: true, Status: "ok"})
+}
+
+func setPassword(ctx *HandlerContext, user *models.UserModel, password string) error {
+	hash, err := ctx.Auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return ctx.Database.GetUserTable().SetPasswordHash(user.ID, hash)
+}
+
+// How long clients are asked to wait when all hashing workers are busy
+const hasherBusyRetryAfter = time.Second
+
+// checkPassword verifies the password of a user and replaces the stored hash if it is outdated.
+// If the hashing workers are busy, the packet is answered as rate limited and ok is false.
+func (ctx *HandlerContext) checkPassword(wsPacket WebsocketPacket, user *models.UserModel, password string) (match bool, ok bool) {
+	match, upgraded, err := ctx.Auth.CheckPasswordHash(password, user.PasswordHash)
+	if errors.Is(err, auth.ErrHasherBusy) {
+		ctx.Client.SendRateLimitedPacket(wsPacket, hasherBusyRetryAfter)
+		return false, false
+	}
+	if err != nil {
+		utils.Log("warn", "casino::gateway", "[Auth] failed to check the password of user ", user.ID, ": ", err)
+	}
+
+	if match && upgraded != "" {
+		if err := ctx.Database.GetUserTable().SetPasswordHash(user.ID, upgraded); err != nil {
+			utils.Log("error", "casino::gateway", "[Auth] failed to upgrade the password hash of user ", user.ID, ": ", err)
+		} else {
+			utils.Log("debug", "casino::gateway", "[Auth] upgraded the password hash of user ", user.ID)
+		}
+	}
+	return match, true
+}
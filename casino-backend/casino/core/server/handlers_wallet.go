@@ -0,0 +1,54 @@
+package server
+
+import (
+	"jhgambling/backend/core/data/tables"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol/models"
+)
+
+// Balances can't be written through db/op, admins correct them with ledger postings instead
+func (packet *WalletAdjustPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	user, err := ctx.GetUser()
+	if err != nil {
+		ctx.Client.SendUnauthorizedPacket(wsPacket.Nonce)
+		return
+	}
+
+	reason := packet.Reason
+	if reason == "" {
+		reason = "adjustment"
+	}
+
+	transfer := tables.Transfer{
+		From:      tables.AccountAdjustment,
+		To:        tables.WalletAccount(packet.WalletID),
+		Reason:    reason,
+		Reference: utils.GenerateID(),
+	}
+	if packet.AmountCents < 0 {
+		transfer.From, transfer.To = transfer.To, transfer.From
+		transfer.AmountCents = uint(-packet.AmountCents)
+	} else {
+		transfer.AmountCents = uint(packet.AmountCents)
+	}
+
+	response := WalletAdjustResponsePacket{WalletID: packet.WalletID}
+
+	if err := ctx.Database.GetTransactionTable().Transfer(transfer); err != nil {
+		utils.Log("warn", "casino::gateway", "[wallet] admin ", user.ID, " failed to adjust wallet ", packet.WalletID, ": ", err)
+		response.ResponsePacket = ctx.failedWith(wsPacket, err)
+	} else {
+		utils.Log("info", "casino::gateway", "[wallet] admin ", user.ID, " adjusted wallet ", packet.WalletID, " by ", packet.AmountCents, " ('", reason, "')")
+		response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+
+		if wallet, err := ctx.Database.GetTable("wallets"); err == nil {
+			if found, err := wallet.FindByID(packet.WalletID); err == nil {
+				response.BalanceCents = found.(*models.WalletModel).NetworthCents
+			}
+		}
+	}
+
+	if res, err := BuildPacket("wallet/adjust:res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
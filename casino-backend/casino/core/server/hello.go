@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"slices"
+	"time"
+)
+
+// ProtocolVersion is raised whenever the gateway protocol changes in a way old clients can't handle.
+// Clients state the version they speak in their hello packet.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// Optional features clients can ask for in their hello packet. There are none yet,
+// everything the server has, like the codes of failed responses, is always sent.
+var serverCapabilities = []string{}
+
+// HasCapability returns whether the client asked for a capability the server supports
+func (gc *GatewayClient) HasCapability(capability string) bool {
+	return slices.Contains(gc.capabilities, capability)
+}
+
+// GetProtocolVersion returns the version the client stated in its hello, zero if it didn't send one
+func (gc *GatewayClient) GetProtocolVersion() int {
+	return gc.protocolVersion
+}
+
+func (packet *HelloPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	response := HelloResponsePacket{
+		Version:      ProtocolVersion,
+		MinVersion:   MinProtocolVersion,
+		ClientID:     ctx.Client.ID,
+		Capabilities: []string{},
+		Packets:      ctx.Gateway.Packets.Types(),
+		Encoding:     ctx.Client.Codec().Name(),
+		ServerTime:   time.Now().UnixMilli(),
+	}
+	sendResponse := func() {
+		if res, err := BuildPacket("hello:res", response, wsPacket.Nonce); err == nil {
+			ctx.Client.Send(res)
+		}
+	}
+
+	if ctx.Client.protocolVersion != 0 {
+		response.ResponsePacket = failed(protocol.ErrorInvalidRequest, "hello has already been sent")
+		sendResponse()
+		return
+	}
+
+	// The response still tells the client which versions would work before it is dropped
+	if packet.Version < MinProtocolVersion || packet.Version > ProtocolVersion {
+		utils.Log("info", "casino::gateway", "[hello] rejecting client ", ctx.Client.ID, " (", packet.ClientType, ") with protocol version ", packet.Version)
+		response.ResponsePacket = failed(protocol.ErrorProtocolVersionUnsupported,
+			fmt.Sprintf("protocol version %d is not supported, the server speaks %d to %d", packet.Version, MinProtocolVersion, ProtocolVersion))
+		sendResponse()
+		ctx.Client.Disconnect()
+		return
+	}
+
+	for _, capability := range packet.Capabilities {
+		if slices.Contains(serverCapabilities, capability) && !slices.Contains(response.Capabilities, capability) {
+			response.Capabilities = append(response.Capabilities, capability)
+		}
+	}
+
+	ctx.Client.protocolVersion = packet.Version
+	ctx.Client.capabilities = response.Capabilities
+	if packet.ClientType != "" {
+		ctx.Client.clientType = packet.ClientType
+	}
+	utils.Log("debug", "casino::gateway", "[hello] client ", ctx.Client.ID, " speaks version ", packet.Version, " as '", packet.ClientType, "' with ", response.Capabilities)
+
+	response.ResponsePacket = ResponsePacket{Success: true, Status: "ok"}
+	sendResponse()
+}
+
+// requireHello rejects packets of clients that haven't sent a hello, if the server is configured to
+func (gc *GatewayClient) requireHello(packet WebsocketPacket) bool {
+	if !gc.handlerContext.Config.Server.RequireHello || gc.protocolVersion != 0 || packet.Type == "hello" {
+		return true
+	}
+
+	utils.Log("info", "casino::gateway", "[hello] client ", gc.ID, " sent '", packet.Type, "' before hello, disconnecting")
+	if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorProtocolHelloRequired, "the first packet has to be hello"), packet.Nonce); err == nil {
+		gc.Send(res)
+	}
+	gc.Disconnect()
+	return false
+}
@@ -1,9 +1,9 @@
 package server
 
-import "encoding/json"
+import "jhgambling/backend/core/codec"
 
 type WebsocketPacket struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
-	Nonce   uint64          `json:"nonce,omitempty"`
+	Type    string           `json:"type"`
+	Payload codec.RawMessage `json:"payload"` // In the encoding of the connection
+	Nonce   uint64           `json:"nonce,omitempty"`
 }
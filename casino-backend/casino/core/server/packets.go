@@ -1,9 +1,12 @@
 package server
 
+import "jhgambling/protocol"
+
 type ResponsePacket struct {
-	Success bool   `json:"success"`
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Success bool               `json:"success"`
+	Status  string             `json:"status"`
+	Code    protocol.ErrorCode `json:"code,omitempty"` // Set if the request failed
+	Message string             `json:"message"`
 }
 
 // User registration
@@ -16,18 +19,32 @@ type AuthRegisterResponsePacket struct {
 	ResponsePacket
 	UserAlreadyExists bool   `json:"userAlreadyExists"`
 	Token             string `json:"token,omitempty"`
+	RefreshToken      string `json:"refreshToken,omitempty"`
+	ExpiresAt         int64  `json:"expiresAt,omitempty"`
 }
 
 // User login
 type AuthLoginPacket struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Code     string `json:"code,omitempty"` // Two-factor code, can also be sent with auth/login_2fa
 }
 type AuthLoginResponsePacket struct {
 	ResponsePacket
 	UserDoesNotExist bool   `json:"userDoesNotExist"`
 	WrongPassword    bool   `json:"wrongPassword"`
+	Requires2FA      bool   `json:"requires2FA"`         // Continue with auth/login_2fa
+	Requires2FASetup bool   `json:"requires2FASetup"`    // Continue with auth/2fa/setup and auth/2fa/enable
+	Challenge        string `json:"challenge,omitempty"` // Identifies the login in the next step
 	Token            string `json:"token,omitempty"`
+	RefreshToken     string `json:"refreshToken,omitempty"`
+	ExpiresAt        int64  `json:"expiresAt,omitempty"`
+}
+
+// Second login step for accounts with 2FA, answered with an AuthLoginResponsePacket
+type AuthLogin2FAPacket struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // TOTP or recovery code
 }
 
 // User authenticate
@@ -37,8 +54,42 @@ type AuthAuthenticatePacket struct {
 }
 type AuthAuthenticateResponsePacket struct {
 	ResponsePacket
-	UserID    uint  `json:"userID"`
-	ExpiresAt int64 `json:"expiresAt"`
+	UserID    uint   `json:"userID"`
+	SessionID string `json:"sessionID"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Exchange a refresh token for a new token pair
+type AuthRefreshPacket struct {
+	RefreshToken string `json:"refreshToken"`
+}
+type AuthRefreshResponsePacket struct {
+	ResponsePacket
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresAt    int64  `json:"expiresAt,omitempty"`
+}
+
+// End the current session, or all sessions of the user.
+// The refresh token identifies the session if the client is not authenticated.
+type AuthLogoutPacket struct {
+	RefreshToken string `json:"refreshToken,omitempty"`
+	AllDevices   bool   `json:"allDevices"`
+}
+type AuthLogoutResponsePacket struct {
+	ResponsePacket
+	RevokedSessions int `json:"revokedSessions"`
+}
+
+// Sent to clients whose session has been revoked
+type AuthRevokedPacket struct {
+	SessionID string `json:"sessionID"`
+}
+
+// Sent to all clients when the server stops. Packets sent afterwards fail with SHUTTING_DOWN.
+type ServerShutdownPacket struct {
+	Reason   string `json:"reason"`
+	ClosesAt int64  `json:"closesAt,omitempty"` // Unix milliseconds, connections are closed by then at the latest
 }
 
 // Does User exist
@@ -62,7 +113,7 @@ type DatabaseOperationPacket struct {
 type DatabaseOperationResponsePacket struct {
 	Op         DatabaseOperationPacket `json:"op"`
 	Result     interface{}             `json:"result"`
-	Error      interface{}             `json:"err"`
+	Error      *ErrorInfo              `json:"err"`
 	ExecTimeUs int64                   `json:"exec_time_us"`
 }
 
@@ -72,6 +123,12 @@ type DatabaseSubscribePacket struct {
 	TableID    string `json:"tableID"`
 	ResourceID uint   `json:"resourceID"`
 }
+type DatabaseSubscribeResponsePacket struct {
+	ResponsePacket
+	Operation  string `json:"operation"`
+	TableID    string `json:"tableID"`
+	ResourceID uint   `json:"resourceID"`
+}
 
 type DatabaseSubUpdatePacket struct {
 	TableID    string      `json:"tableID"`
@@ -89,3 +146,186 @@ type SetSessionPacket struct {
 type GameFinishedLoadingPacket struct {
 	SessionID uint `json:"sessionID"`
 }
+
+// Game instances
+type GameJoinPacket struct {
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameJoinResponsePacket struct {
+	ResponsePacket
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameLeavePacket struct {
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameLeaveResponsePacket struct {
+	ResponsePacket
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameInstancePacket struct {
+	ProviderID string              `json:"providerID"`
+	InstanceID string              `json:"instanceID"`
+	Packet     protocol.GamePacket `json:"packet"`
+}
+
+type GameListPacket struct {
+	ProviderID string `json:"providerID"` // Optional, lists all providers if empty
+}
+
+type GameListResponsePacket struct {
+	ResponsePacket
+	Providers []GameProviderInfo `json:"providers"`
+}
+
+type GameProviderInfo struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	Instances []GameInstanceInfo `json:"instances"`
+}
+
+type GameInstanceInfo struct {
+	ID      string `json:"id"`
+	Users   int    `json:"users"`
+	Clients int    `json:"clients"`
+}
+
+type GameCreatePacket struct {
+	ProviderID string                      `json:"providerID"`
+	Config     protocol.GameInstanceConfig `json:"config"`
+}
+
+type GameCreateResponsePacket struct {
+	ResponsePacket
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameClosePacket struct {
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+type GameCloseResponsePacket struct {
+	ResponsePacket
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+// Sent to all clients of an instance right before it is closed
+type GameClosedPacket struct {
+	ProviderID string `json:"providerID"`
+	InstanceID string `json:"instanceID"`
+}
+
+// Wallet adjustment (requires the wallets.adjust permission)
+type WalletAdjustPacket struct {
+	WalletID    uint   `json:"walletID"`
+	AmountCents int64  `json:"amountCents"` // Positive to credit, negative to debit the wallet
+	Reason      string `json:"reason"`
+}
+
+type WalletAdjustResponsePacket struct {
+	ResponsePacket
+	WalletID     uint `json:"walletID"`
+	BalanceCents uint `json:"balanceCents"`
+}
+
+// Password change of the authenticated user, ends all of their other sessions
+type AuthChangePasswordPacket struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+type AuthChangePasswordResponsePacket struct {
+	ResponsePacket
+	RevokedSessions int `json:"revokedSessions"`
+}
+
+// One-time password reset code (requires the users.reset permission)
+type AuthCreateResetCodePacket struct {
+	UserID   uint   `json:"userID"`
+	Username string `json:"username,omitempty"` // Used if no user ID is given
+}
+type AuthCreateResetCodeResponsePacket struct {
+	ResponsePacket
+	UserID    uint   `json:"userID"`
+	ResetCode string `json:"resetCode,omitempty"` // Not "code", which is the error code of failed responses
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+// Password reset with a code issued by an admin, ends all sessions of the user
+type AuthResetPasswordPacket struct {
+	Username    string `json:"username"`
+	Code        string `json:"code"`
+	NewPassword string `json:"newPassword"`
+}
+type AuthResetPasswordResponsePacket struct {
+	ResponsePacket
+}
+
+// Two-factor authentication. Setup and enable work either for an authenticated
+// client or with the challenge of a login that requires 2FA to be set up.
+type TwoFactorSetupPacket struct {
+	Challenge string `json:"challenge,omitempty"`
+}
+type TwoFactorSetupResponsePacket struct {
+	ResponsePacket
+	Secret string `json:"secret,omitempty"`
+	URI    string `json:"uri,omitempty"`
+}
+
+type TwoFactorEnablePacket struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code"`
+}
+type TwoFactorEnableResponsePacket struct {
+	ResponsePacket
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// Set if 2FA was enabled during a login
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresAt    int64  `json:"expiresAt,omitempty"`
+}
+
+type TwoFactorDisablePacket struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+type TwoFactorDisableResponsePacket struct {
+	ResponsePacket
+}
+
+type TwoFactorRecoveryCodesPacket struct {
+	Code string `json:"code"`
+}
+type TwoFactorRecoveryCodesResponsePacket struct {
+	ResponsePacket
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// Keeps the connection alive, answered with "pong"
+type PingPacket struct{}
+
+// Handshake, sent by clients as their first packet
+type HelloPacket struct {
+	Version      int      `json:"version"`    // Protocol version the client speaks
+	ClientType   string   `json:"clientType"` // e.g. "app", "game-sdk"
+	Capabilities []string `json:"capabilities"`
+}
+type HelloResponsePacket struct {
+	ResponsePacket
+	Version      int      `json:"version"`      // Newest version the server speaks
+	MinVersion   int      `json:"minVersion"`   // Oldest version the server still accepts
+	ClientID     string   `json:"clientID"`     // ID of the connection, as seen by games
+	Capabilities []string `json:"capabilities"` // Capabilities of the client the server supports
+	Packets      []string `json:"packets"`      // Packet types the server accepts
+	Encoding     string   `json:"encoding"`     // Wire encoding of the connection
+	ServerTime   int64    `json:"serverTime"`   // Unix milliseconds
+}
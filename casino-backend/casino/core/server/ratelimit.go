@@ -0,0 +1,314 @@
+package server
+
+import (
+	"fmt"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limitState tracks the attempts and failures of a single IP or username
+type limitState struct {
+	windowStart  time.Time
+	attempts     int
+	failures     int
+	blockedUntil time.Time
+	lastSeen     time.Time
+}
+
+// AuthLimiter protects the auth packets against brute-forcing.
+// IPs are limited in how many auth packets they send, IPs and usernames
+// are backed off after failed logins and usernames get locked eventually.
+type AuthLimiter struct {
+	cfg config.RateLimitConfig
+
+	mu          sync.Mutex
+	ips         map[string]*limitState
+	usernames   map[string]*limitState
+	lastCleanup time.Time
+}
+
+func NewAuthLimiter(cfg config.RateLimitConfig) *AuthLimiter {
+	return &AuthLimiter{
+		cfg:         cfg,
+		ips:         map[string]*limitState{},
+		usernames:   map[string]*limitState{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// Allow counts an attempt of the IP and reports how long to wait if it is blocked.
+// The username is optional and only checked, attempts are not counted against it.
+func (l *AuthLimiter) Allow(ip string, username string) (bool, time.Duration) {
+	return l.allow(ip, username, time.Now())
+}
+
+func (l *AuthLimiter) allow(ip string, username string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup(now)
+
+	ipState := l.state(l.ips, ip, now)
+	if wait := ipState.blockedUntil.Sub(now); wait > 0 {
+		return false, wait
+	}
+
+	if username != "" {
+		if userState, ok := l.usernames[normalizeUsername(username)]; ok {
+			if wait := userState.blockedUntil.Sub(now); wait > 0 {
+				return false, wait
+			}
+		}
+	}
+
+	if l.cfg.AttemptsPerMinute > 0 {
+		if now.Sub(ipState.windowStart) >= time.Minute {
+			ipState.windowStart = now
+			ipState.attempts = 0
+		}
+		if ipState.attempts >= l.cfg.AttemptsPerMinute {
+			return false, ipState.windowStart.Add(time.Minute).Sub(now)
+		}
+		ipState.attempts++
+	}
+
+	return true, 0
+}
+
+// RecordFailure backs off the IP and username after a failed login
+func (l *AuthLimiter) RecordFailure(ip string, username string) {
+	l.recordFailure(ip, username, time.Now())
+}
+
+func (l *AuthLimiter) recordFailure(ip string, username string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ipState := l.state(l.ips, ip, now)
+	ipState.failures++
+	l.backoff(ipState, now)
+
+	if username == "" {
+		return
+	}
+
+	userState := l.state(l.usernames, normalizeUsername(username), now)
+	userState.failures++
+	l.backoff(userState, now)
+
+	if l.cfg.LockoutThreshold > 0 && userState.failures >= l.cfg.LockoutThreshold {
+		lockedUntil := now.Add(l.cfg.LockoutDuration.Duration)
+		if lockedUntil.After(userState.blockedUntil) {
+			userState.blockedUntil = lockedUntil
+		}
+		utils.Log("warn", "casino::gateway", "[Auth] username '", username, "' locked after ", userState.failures, " failed logins (last from ", ip, ")")
+	}
+}
+
+// RecordSuccess clears the failures after a successful login
+func (l *AuthLimiter) RecordSuccess(ip string, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, ok := l.ips[ip]; ok {
+		state.failures = 0
+	}
+	delete(l.usernames, normalizeUsername(username))
+}
+
+func (l *AuthLimiter) state(states map[string]*limitState, key string, now time.Time) *limitState {
+	state, ok := states[key]
+	if !ok {
+		state = &limitState{windowStart: now}
+		states[key] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+// backoff blocks the key exponentially longer for every failure past the free ones
+func (l *AuthLimiter) backoff(state *limitState, now time.Time) {
+	excess := state.failures - l.cfg.FreeFailures
+	if excess <= 0 || l.cfg.BaseBackoff.Duration <= 0 {
+		return
+	}
+
+	delay := l.cfg.MaxBackoff.Duration
+	if excess <= 32 {
+		scaled := float64(l.cfg.BaseBackoff.Duration) * math.Pow(2, float64(excess-1))
+		if scaled < float64(delay) {
+			delay = time.Duration(scaled)
+		}
+	}
+
+	if blockedUntil := now.Add(delay); blockedUntil.After(state.blockedUntil) {
+		state.blockedUntil = blockedUntil
+	}
+}
+
+// cleanup forgets keys that have been quiet for longer than any block could last
+func (l *AuthLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+
+	retention := max(l.cfg.LockoutDuration.Duration, l.cfg.MaxBackoff.Duration, time.Minute) * 2
+	for _, states := range []map[string]*limitState{l.ips, l.usernames} {
+		for key, state := range states {
+			if now.Sub(state.lastSeen) > retention && now.After(state.blockedUntil) {
+				delete(states, key)
+			}
+		}
+	}
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// RemoteIP returns the IP of the client without its port
+func (gc *GatewayClient) RemoteIP() string {
+	host, _, err := net.SplitHostPort(gc.Addr)
+	if err != nil {
+		return gc.Addr
+	}
+	return host
+}
+
+// tokenBucket refills with rate tokens per second up to burst tokens
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit config.PacketLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// take removes a token, or reports how long it takes until one is available
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// PacketLimiter limits the packets of a single client, both in total and per packet type.
+// Clients that keep sending after being limited are reported as abusive.
+type PacketLimiter struct {
+	cfg config.PacketRateLimitConfig
+
+	mu          sync.Mutex
+	total       *tokenBucket
+	packets     map[string]*tokenBucket
+	violations  int
+	windowStart time.Time
+}
+
+func NewPacketLimiter(cfg config.PacketRateLimitConfig) *PacketLimiter {
+	return newPacketLimiter(cfg, time.Now())
+}
+
+func newPacketLimiter(cfg config.PacketRateLimitConfig, now time.Time) *PacketLimiter {
+	limiter := &PacketLimiter{
+		cfg:         cfg,
+		packets:     map[string]*tokenBucket{},
+		windowStart: now,
+	}
+
+	if cfg.Default.Rate > 0 {
+		limiter.total = newTokenBucket(cfg.Default, now)
+	}
+	// Buckets only exist for configured types, so unknown packet types can't grow the map
+	for packetType, limit := range cfg.Packets {
+		if limit.Rate > 0 {
+			limiter.packets[packetType] = newTokenBucket(limit, now)
+		}
+	}
+
+	return limiter
+}
+
+// Allow takes a token for the packet type. If the packet is rejected, it returns
+// how long the client should wait and whether it exceeded the allowed violations.
+func (l *PacketLimiter) Allow(packetType string) (bool, time.Duration, bool) {
+	return l.allow(packetType, time.Now())
+}
+
+func (l *PacketLimiter) allow(packetType string, now time.Time) (bool, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, ok := l.packets[packetType]; ok {
+		if ok, wait := bucket.take(now); !ok {
+			return false, wait, l.violate(now)
+		}
+	}
+	if l.total != nil {
+		if ok, wait := l.total.take(now); !ok {
+			return false, wait, l.violate(now)
+		}
+	}
+
+	return true, 0, false
+}
+
+func (l *PacketLimiter) violate(now time.Time) bool {
+	if now.Sub(l.windowStart) >= l.cfg.ViolationWindow.Duration {
+		l.windowStart = now
+		l.violations = 0
+	}
+	l.violations++
+	return l.cfg.MaxViolations > 0 && l.violations > l.cfg.MaxViolations
+}
+
+type RateLimitedResponsePacket struct {
+	ResponsePacket
+	RetryAfterMs int64 `json:"retryAfterMs"`
+}
+
+// SendRateLimitedPacket answers a packet that has been rejected by a rate limiter
+func (gc *GatewayClient) SendRateLimitedPacket(wsPacket WebsocketPacket, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if res, err := BuildPacket(wsPacket.Type+":res", RateLimitedResponsePacket{
+		ResponsePacket: ResponsePacket{
+			Success: false,
+			Status:  "rate_limited",
+			Code:    protocol.ErrorRateLimited,
+			Message: fmt.Sprintf("Too many requests, try again in %d seconds", seconds),
+		},
+		RetryAfterMs: wait.Milliseconds(),
+	}, wsPacket.Nonce); err == nil {
+		gc.Send(res)
+	}
+}
+
+// allowAuthAttempt checks the auth limiter and answers with a rate_limited response if the client is blocked
+func (ctx *HandlerContext) allowAuthAttempt(wsPacket WebsocketPacket, username string) bool {
+	ok, wait := ctx.Gateway.AuthLimiter.Allow(ctx.Client.RemoteIP(), username)
+	if ok {
+		return true
+	}
+
+	utils.Log("debug", "casino::gateway", "[Auth] rate limited '", wsPacket.Type, "' from ", ctx.Client.RemoteIP())
+	ctx.Client.SendRateLimitedPacket(wsPacket, wait)
+	return false
+}
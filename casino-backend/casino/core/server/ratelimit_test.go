@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"jhgambling/backend/core/config"
+	"testing"
+	"time"
+)
+
+func authLimits() config.RateLimitConfig {
+	return config.RateLimitConfig{
+		AttemptsPerMinute: 3,
+		FreeFailures:      2,
+		BaseBackoff:       config.Duration{Duration: time.Second},
+		MaxBackoff:        config.Duration{Duration: 8 * time.Second},
+		LockoutThreshold:  6,
+		LockoutDuration:   config.Duration{Duration: 15 * time.Minute},
+	}
+}
+
+func TestAuthLimiterAttempts(t *testing.T) {
+	limiter := NewAuthLimiter(authLimits())
+	start := time.Now()
+
+	// In order, the window starts with the first attempt of the IP
+	tests := []struct {
+		name     string
+		at       time.Duration
+		ip       string
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		{"first attempt", 0, "10.0.0.1", true, 0},
+		{"second attempt", time.Second, "10.0.0.1", true, 0},
+		{"third attempt", 2 * time.Second, "10.0.0.1", true, 0},
+		{"over the limit", 10 * time.Second, "10.0.0.1", false, 50 * time.Second},
+		{"other IP", 10 * time.Second, "10.0.0.2", true, 0},
+		{"still over the limit", 59 * time.Second, "10.0.0.1", false, time.Second},
+		{"next minute", time.Minute, "10.0.0.1", true, 0},
+	}
+
+	for _, test := range tests {
+		ok, wait := limiter.allow(test.ip, "", start.Add(test.at))
+		if ok != test.wantOK || wait != test.wantWait {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", test.name, ok, wait, test.wantOK, test.wantWait)
+		}
+	}
+}
+
+func TestAuthLimiterBackoff(t *testing.T) {
+	limits := authLimits()
+	limits.AttemptsPerMinute = 0
+	limits.LockoutThreshold = 0
+
+	tests := []struct {
+		failures int
+		wantWait time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 8 * time.Second},
+		{100, 8 * time.Second},
+	}
+
+	for _, test := range tests {
+		limiter := NewAuthLimiter(limits)
+		now := time.Now()
+		for i := 0; i < test.failures; i++ {
+			limiter.recordFailure("10.0.0.1", "alice", now)
+		}
+
+		// Both the IP and the username are backed off, each on its own
+		checks := []struct {
+			name     string
+			ip       string
+			username string
+		}{
+			{"IP", "10.0.0.1", ""},
+			{"username", "10.0.0.2", "Alice"},
+		}
+		for _, check := range checks {
+			ok, wait := limiter.allow(check.ip, check.username, now)
+			if ok != (test.wantWait == 0) || wait != test.wantWait {
+				t.Errorf("%d failures, %s: got (%v, %v), want a wait of %v", test.failures, check.name, ok, wait, test.wantWait)
+			}
+			if ok, _ := limiter.allow(check.ip, check.username, now.Add(test.wantWait)); !ok {
+				t.Errorf("%d failures, %s: still blocked after %v", test.failures, check.name, test.wantWait)
+			}
+		}
+	}
+}
+
+func TestAuthLimiterLockout(t *testing.T) {
+	limits := authLimits()
+	limits.AttemptsPerMinute = 0
+
+	tests := []struct {
+		name       string
+		failures   int
+		success    bool // Logs in successfully before the last failure
+		wantLocked bool
+	}{
+		{"below the threshold", 5, false, false},
+		{"at the threshold", 6, false, true},
+		{"past the threshold", 8, false, true},
+		{"success clears the failures", 6, true, false},
+	}
+
+	for _, test := range tests {
+		limiter := NewAuthLimiter(limits)
+		now := time.Now()
+		for i := 0; i < test.failures; i++ {
+			if test.success && i == test.failures-1 {
+				limiter.RecordSuccess("10.0.0.1", "alice")
+			}
+			// Every failure from another IP, so only the username gets locked
+			limiter.recordFailure(fmt.Sprintf("10.0.1.%d", i), "alice", now)
+		}
+
+		// The backoff of the username is over long before the lockout
+		later := now.Add(limits.MaxBackoff.Duration)
+		ok, wait := limiter.allow("10.0.0.1", " ALICE ", later)
+		if ok == test.wantLocked {
+			t.Errorf("%s: got (%v, %v), want locked %v", test.name, ok, wait, test.wantLocked)
+		}
+		if test.wantLocked {
+			if want := limits.LockoutDuration.Duration - limits.MaxBackoff.Duration; wait != want {
+				t.Errorf("%s: got a wait of %v, want %v", test.name, wait, want)
+			}
+			if ok, _ := limiter.allow("10.0.0.1", "alice", now.Add(limits.LockoutDuration.Duration)); !ok {
+				t.Errorf("%s: still locked after %v", test.name, limits.LockoutDuration.Duration)
+			}
+		}
+	}
+}
+
+func TestPacketLimiterRefill(t *testing.T) {
+	start := time.Now()
+	limiter := newPacketLimiter(config.PacketRateLimitConfig{
+		Default: config.PacketLimit{Rate: 4, Burst: 3},
+		Packets: map[string]config.PacketLimit{"bet": {Rate: 0.5, Burst: 1}},
+	}, start)
+
+	// In order, each packet takes from the buckets the ones before it left
+	tests := []struct {
+		name       string
+		at         time.Duration
+		packetType string
+		wantOK     bool
+		wantWait   time.Duration
+	}{
+		{"burst", 0, "ping", true, 0},
+		{"burst", 0, "ping", true, 0},
+		{"burst", 0, "ping", true, 0},
+		{"burst used up", 0, "ping", false, 250 * time.Millisecond},
+		{"half a token", 125 * time.Millisecond, "ping", false, 125 * time.Millisecond},
+		{"refilled", 250 * time.Millisecond, "ping", true, 0},
+		{"own limit", time.Second, "bet", true, 0},
+		{"own limit used up", time.Second, "bet", false, 2 * time.Second},
+		{"other types are not limited by it", time.Second, "ping", true, 0},
+		{"own limit half refilled", 2 * time.Second, "bet", false, time.Second},
+		{"own limit refilled", 3 * time.Second, "bet", true, 0},
+		{"refilled up to the burst", 10 * time.Second, "ping", true, 0},
+		{"refilled up to the burst", 10 * time.Second, "ping", true, 0},
+		{"refilled up to the burst", 10 * time.Second, "ping", true, 0},
+		{"no more than the burst", 10 * time.Second, "ping", false, 250 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		ok, wait, _ := limiter.allow(test.packetType, start.Add(test.at))
+		if ok != test.wantOK || wait != test.wantWait {
+			t.Errorf("%s at %v: got (%v, %v), want (%v, %v)", test.name, test.at, ok, wait, test.wantOK, test.wantWait)
+		}
+	}
+}
+
+func TestPacketLimiterViolations(t *testing.T) {
+	start := time.Now()
+	limiter := newPacketLimiter(config.PacketRateLimitConfig{
+		Default:         config.PacketLimit{Rate: 1, Burst: 1},
+		MaxViolations:   2,
+		ViolationWindow: config.Duration{Duration: 10 * time.Second},
+	}, start)
+
+	// In order, rejected packets count as violations within the window
+	tests := []struct {
+		name        string
+		at          time.Duration
+		wantOK      bool
+		wantAbusive bool
+	}{
+		{"allowed", 0, true, false},
+		{"first violation", 0, false, false},
+		{"second violation", time.Second / 2, false, false},
+		{"too many violations", time.Second / 2, false, true},
+		{"allowed packets don't count", 10 * time.Second, true, false},
+		{"new window", 10 * time.Second, false, false},
+		{"second violation in the new window", 10*time.Second + time.Second/2, false, false},
+		{"too many violations in the new window", 10*time.Second + time.Second/2, false, true},
+	}
+
+	for _, test := range tests {
+		ok, _, abusive := limiter.allow("ping", start.Add(test.at))
+		if ok != test.wantOK || abusive != test.wantAbusive {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", test.name, ok, abusive, test.wantOK, test.wantAbusive)
+		}
+	}
+
+	// Without a maximum, clients are limited but never reported
+	unlimited := newPacketLimiter(config.PacketRateLimitConfig{
+		Default:         config.PacketLimit{Rate: 1, Burst: 1},
+		ViolationWindow: config.Duration{Duration: 10 * time.Second},
+	}, start)
+	for i := 0; i < 10; i++ {
+		if _, _, abusive := unlimited.allow("ping", start); abusive {
+			t.Errorf("packet %d: reported as abusive without a maximum", i)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrPacketAlreadyRegistered = errors.New("packet type is already registered")
+
+// PacketHandler is implemented by the payload struct of every packet type
+type PacketHandler interface {
+	Handle(wsPacket WebsocketPacket, ctx *HandlerContext)
+}
+
+// PacketDefinition describes a packet type the gateway accepts
+type PacketDefinition struct {
+	Type        string
+	RequireAuth bool                 // Rejects clients that haven't authenticated
+	Permission  string               // Permission the user needs, implies RequireAuth
+	Deduplicate bool                 // Retries with the same nonce get the first response instead of being handled again
+	New         func() PacketHandler // Creates the payload the packet is decoded into
+	Response    interface{}          // Payload of the response, only used to describe the packet in schemas
+}
+
+// PacketRegistry maps packet types to their definitions
+type PacketRegistry struct {
+	mu      sync.RWMutex
+	packets map[string]PacketDefinition
+}
+
+func NewPacketRegistry() *PacketRegistry {
+	return &PacketRegistry{
+		packets: map[string]PacketDefinition{},
+	}
+}
+
+// Register adds a packet type, every type can only be registered once
+func (r *PacketRegistry) Register(def PacketDefinition) error {
+	if def.Type == "" || def.New == nil {
+		return errors.New("packet definitions need a type and a payload constructor")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.packets[def.Type]; exists {
+		return fmt.Errorf("%w: '%s'", ErrPacketAlreadyRegistered, def.Type)
+	}
+	r.packets[def.Type] = def
+	return nil
+}
+
+// Get returns the definition of a packet type
+func (r *PacketRegistry) Get(packetType string) (PacketDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def, ok := r.packets[packetType]
+	return def, ok
+}
+
+// Types returns all registered packet types, sorted
+func (r *PacketRegistry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.packets))
+	for packetType := range r.packets {
+		types = append(types, packetType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Definitions returns the definitions of all registered packet types, sorted by type
+func (r *PacketRegistry) Definitions() []PacketDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]PacketDefinition, 0, len(r.packets))
+	for _, def := range r.packets {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Type < defs[j].Type })
+	return defs
+}
+
+// RegisterPluginPackets registers the packet handlers of a game provider.
+// Their types have to start with the provider ID, so plugins can't take over core packets.
+func (r *PacketRegistry) RegisterPluginPackets(providerID string, handlers []protocol.PacketHandler) error {
+	errs := []error{}
+	for _, handler := range handlers {
+		if !strings.HasPrefix(handler.Type, providerID+"/") || handler.Handle == nil {
+			errs = append(errs, fmt.Errorf("packet '%s' of plugin '%s' needs a handler and has to start with '%s/'", handler.Type, providerID, providerID))
+			continue
+		}
+
+		handler := handler
+		err := r.Register(PacketDefinition{
+			Type:        handler.Type,
+			RequireAuth: handler.RequireAuth,
+			Permission:  handler.Permission,
+			New:         func() PacketHandler { return &pluginPacket{handler: handler} },
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		utils.Log("info", "casino::gateway", "registered packet '", handler.Type, "' of plugin '", providerID, "'")
+	}
+	return errors.Join(errs...)
+}
+
+// ServerPacket describes a packet the server sends on its own, with nonce 0 or a nonce of the server
+type ServerPacket struct {
+	Type    string
+	Payload interface{}
+}
+
+// ServerPackets lists the packets the server pushes to clients
+var ServerPackets = []ServerPacket{
+	{Type: "auth/revoked", Payload: AuthRevokedPacket{}},
+	{Type: "db/sub:update", Payload: DatabaseSubUpdatePacket{}},
+	{Type: "game/packet", Payload: GameInstancePacket{}},
+	{Type: "game/request", Payload: GameInstancePacket{}}, // Answered with "game/request:res" and a game packet
+	{Type: "game/closed", Payload: GameClosedPacket{}},
+	{Type: "game/finished_loading", Payload: GameFinishedLoadingPacket{}},
+	{Type: "server/shutdown", Payload: ServerShutdownPacket{}},
+}
+
+// pluginPacket hands the raw payload to the handler of a plugin
+type pluginPacket struct {
+	handler protocol.PacketHandler
+	payload json.RawMessage
+}
+
+func (packet *pluginPacket) UnmarshalJSON(data []byte) error {
+	packet.payload = append(json.RawMessage{}, data...)
+	return nil
+}
+
+func (packet *pluginPacket) Handle(wsPacket WebsocketPacket, ctx *HandlerContext) {
+	client := ctx.Client.GetGameClient()
+	if !ctx.Client.IsAuthenticated() {
+		client.UserID = 0
+	}
+
+	result, err := packet.handler.Handle(protocol.PacketRequest{
+		Client:  client,
+		Payload: packet.payload,
+	})
+
+	var response interface{} = result
+	if err != nil {
+		response = ctx.failedWith(wsPacket, err)
+	}
+	if res, err := BuildPacket(wsPacket.Type+":res", response, wsPacket.Nonce); err == nil {
+		ctx.Client.Send(res)
+	}
+}
+
+// dispatch handles a packet and keeps its responses if retries are answered from the cache.
+// Every packet should get exactly one response with its nonce, the responses are returned.
+func (gc *GatewayClient) dispatch(def PacketDefinition, packet WebsocketPacket) []*Packet {
+	gc.tracker.begin(packet.Nonce)
+	handled := func() (handled bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				// The handler may have changed something already, so retries get the error as well
+				handled = true
+				gc.answerPanic(packet, r)
+			}
+		}()
+		return gc.handlePacket(def, packet)
+	}()
+	responses := gc.tracker.end()
+
+	if packet.Nonce != 0 && len(responses) == 0 {
+		utils.Log("warn", "casino::gateway", "'", packet.Type, "' with nonce ", packet.Nonce, " of client ", gc.ID, " was not answered")
+	}
+	if handled && def.Deduplicate {
+		gc.replays.store(packet, responses)
+	}
+	return responses
+}
+
+// answerPanic logs a handler that panicked and answers its packet as an internal error,
+// unless the handler sent a response before it panicked
+func (gc *GatewayClient) answerPanic(packet WebsocketPacket, r interface{}) {
+	ref := utils.GenerateID()
+	utils.Log("error", "casino::gateway", "[", packet.Type, "] handler panicked (ref ", ref, ") for client ", gc.ID, ": ", r, "\n", string(debug.Stack()))
+
+	if gc.tracker.answered() {
+		return
+	}
+	if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorInternal, "internal error (ref "+ref+")"), packet.Nonce); err == nil {
+		gc.Send(res)
+	}
+}
+
+// handlePacket checks the auth requirements of a packet, decodes its payload and handles it.
+// It returns whether the handler has been called.
+func (gc *GatewayClient) handlePacket(def PacketDefinition, packet WebsocketPacket) bool {
+	ctx := &gc.handlerContext
+
+	if def.RequireAuth || def.Permission != "" {
+		if !gc.IsAuthenticated() {
+			gc.SendUnauthorizedPacket(packet.Nonce)
+			return false
+		}
+	}
+	if def.Permission != "" {
+		user, err := ctx.GetUser()
+		if err != nil || !user.HasPermission(def.Permission) {
+			gc.SendUnauthorizedPacket(packet.Nonce)
+			return false
+		}
+	}
+	if def.Deduplicate && gc.replay(packet) {
+		return false
+	}
+
+	payload := def.New()
+	if !gc.unmarshalPayload(packet, payload) {
+		return false
+	}
+	payload.Handle(packet, ctx)
+	return true
+}
+
+// RegisterDefaultPackets registers all packet types of the core
+func RegisterDefaultPackets(r *PacketRegistry) {
+	defs := []PacketDefinition{
+		// Auth
+		{Type: "auth/register", Deduplicate: true, Response: AuthRegisterResponsePacket{}, New: func() PacketHandler { return &AuthRegisterPacket{} }},
+		{Type: "auth/login", Response: AuthLoginResponsePacket{}, New: func() PacketHandler { return &AuthLoginPacket{} }},
+		{Type: "auth/login_2fa", Response: AuthLoginResponsePacket{}, New: func() PacketHandler { return &AuthLogin2FAPacket{} }},
+		{Type: "auth/authenticate", Response: AuthAuthenticateResponsePacket{}, New: func() PacketHandler { return &AuthAuthenticatePacket{} }},
+		{Type: "auth/refresh", Response: AuthRefreshResponsePacket{}, New: func() PacketHandler { return &AuthRefreshPacket{} }},
+		{Type: "auth/logout", Response: AuthLogoutResponsePacket{}, New: func() PacketHandler { return &AuthLogoutPacket{} }},
+		{Type: "auth/change_password", RequireAuth: true, Deduplicate: true, Response: AuthChangePasswordResponsePacket{}, New: func() PacketHandler { return &AuthChangePasswordPacket{} }},
+		{Type: "auth/create_reset_code", Permission: protocol.PermissionUsersReset, Deduplicate: true, Response: AuthCreateResetCodeResponsePacket{}, New: func() PacketHandler { return &AuthCreateResetCodePacket{} }},
+		{Type: "auth/reset_password", Deduplicate: true, Response: AuthResetPasswordResponsePacket{}, New: func() PacketHandler { return &AuthResetPasswordPacket{} }},
+		{Type: "auth/does_user_exist", Response: DoesUserExistResponsePacket{}, New: func() PacketHandler { return &DoesUserExistPacket{} }},
+
+		// Two-factor authentication, setup and enable also work with the challenge of a login
+		{Type: "auth/2fa/setup", Response: TwoFactorSetupResponsePacket{}, New: func() PacketHandler { return &TwoFactorSetupPacket{} }},
+		{Type: "auth/2fa/enable", Response: TwoFactorEnableResponsePacket{}, New: func() PacketHandler { return &TwoFactorEnablePacket{} }},
+		{Type: "auth/2fa/disable", RequireAuth: true, Response: TwoFactorDisableResponsePacket{}, New: func() PacketHandler { return &TwoFactorDisablePacket{} }},
+		{Type: "auth/2fa/recovery_codes", RequireAuth: true, Response: TwoFactorRecoveryCodesResponsePacket{}, New: func() PacketHandler { return &TwoFactorRecoveryCodesPacket{} }},
+
+		// Database
+		{Type: "db/op", RequireAuth: true, Deduplicate: true, Response: DatabaseOperationResponsePacket{}, New: func() PacketHandler { return &DatabaseOperationPacket{} }},
+		{Type: "db/sub", RequireAuth: true, Response: DatabaseSubscribeResponsePacket{}, New: func() PacketHandler { return &DatabaseSubscribePacket{} }},
+
+		// Wallets
+		{Type: "wallet/adjust", Permission: protocol.PermissionWalletsAdjust, Deduplicate: true, Response: WalletAdjustResponsePacket{}, New: func() PacketHandler { return &WalletAdjustPacket{} }},
+
+		// Connection
+		{Type: "hello", Response: HelloResponsePacket{}, New: func() PacketHandler { return &HelloPacket{} }},
+		{Type: "ping", Response: PingPacket{}, New: func() PacketHandler { return &PingPacket{} }}, // Answered with "pong"
+		{Type: "client/set_session", Response: ResponsePacket{}, New: func() PacketHandler { return &SetSessionPacket{} }},
+
+		// Games
+		{Type: "game/join", RequireAuth: true, Response: GameJoinResponsePacket{}, New: func() PacketHandler { return &GameJoinPacket{} }},
+		{Type: "game/leave", RequireAuth: true, Response: GameLeaveResponsePacket{}, New: func() PacketHandler { return &GameLeavePacket{} }},
+		{Type: "game/packet", RequireAuth: true, Response: ResponsePacket{}, New: func() PacketHandler { return &GameInstancePacket{} }},
+		{Type: "game/list", RequireAuth: true, Response: GameListResponsePacket{}, New: func() PacketHandler { return &GameListPacket{} }},
+		{Type: "game/create", Permission: protocol.PermissionGamesManage, Deduplicate: true, Response: GameCreateResponsePacket{}, New: func() PacketHandler { return &GameCreatePacket{} }},
+		{Type: "game/close", Permission: protocol.PermissionGamesManage, Deduplicate: true, Response: GameCloseResponsePacket{}, New: func() PacketHandler { return &GameClosePacket{} }},
+		{Type: "game/finished_loading", Response: ResponsePacket{}, New: func() PacketHandler { return &GameFinishedLoadingPacket{} }},
+	}
+
+	for _, def := range defs {
+		if err := r.Register(def); err != nil {
+			utils.Log("error", "casino::gateway", "error registering packet:", err)
+			panic("failed to register default packets")
+		}
+	}
+}
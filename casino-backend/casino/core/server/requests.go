@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"jhgambling/backend/core/codec"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRequestTimeout     = protocol.NewError(protocol.ErrorProtocolRequestTimeout, "the client did not answer in time")
+	ErrClientDisconnected = protocol.NewError(protocol.ErrorProtocolClientDisconnected, "the client has disconnected")
+)
+
+// Nonces of requests sent by the server start at 2^52, so they never collide with the nonces
+// clients count up themselves and are still exact numbers in JavaScript
+const serverNonceBit = uint64(1) << 52
+
+// requestTracker collects the responses a client gets while one of its packets is handled
+type requestTracker struct {
+	mu        sync.Mutex
+	nonce     uint64
+	responses []*Packet
+}
+
+func (t *requestTracker) begin(nonce uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nonce = nonce
+	t.responses = nil
+}
+
+// track remembers a packet if it answers the packet that is being handled
+func (t *requestTracker) track(packet *Packet) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nonce != 0 && packet.Nonce == t.nonce {
+		t.responses = append(t.responses, packet)
+	}
+}
+
+// answered returns whether the packet that is being handled got a response already
+func (t *requestTracker) answered() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.responses) > 0
+}
+
+func (t *requestTracker) end() []*Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	responses := t.responses
+	t.nonce = 0
+	t.responses = nil
+	return responses
+}
+
+// replayCache keeps the responses of packets with side effects, so a client that retries
+// a packet with the same nonce gets the same answer instead of e.g. a second record
+type replayCache struct {
+	cfg     config.RequestConfig
+	entries map[uint64]replayEntry
+	order   []uint64 // Oldest first
+}
+
+type replayEntry struct {
+	request   [sha256.Size]byte // Type and payload, a nonce must not be used for another request
+	responses []*Packet
+	storedAt  time.Time
+}
+
+func newReplayCache(cfg config.RequestConfig) *replayCache {
+	return &replayCache{
+		cfg:     cfg,
+		entries: map[uint64]replayEntry{},
+	}
+}
+
+func requestHash(packet WebsocketPacket) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(packet.Type+"\n"), packet.Payload...))
+}
+
+// lookup returns the responses of an earlier packet with the same nonce.
+// reused is set if the nonce has been used for a different packet.
+func (c *replayCache) lookup(packet WebsocketPacket) (responses []*Packet, found bool, reused bool) {
+	entry, ok := c.entries[packet.Nonce]
+	if !ok || time.Since(entry.storedAt) > c.cfg.ReplayWindow.Duration {
+		return nil, false, false
+	}
+	if entry.request != requestHash(packet) {
+		return nil, false, true
+	}
+	return entry.responses, true, false
+}
+
+func (c *replayCache) store(packet WebsocketPacket, responses []*Packet) {
+	if c.cfg.ReplayCacheSize == 0 || packet.Nonce == 0 {
+		return
+	}
+
+	if _, exists := c.entries[packet.Nonce]; !exists {
+		c.order = append(c.order, packet.Nonce)
+	}
+	c.entries[packet.Nonce] = replayEntry{
+		request:   requestHash(packet),
+		responses: responses,
+		storedAt:  time.Now(),
+	}
+
+	for len(c.order) > c.cfg.ReplayCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// replay answers a retried packet from the cache. It returns false if the packet has to be handled.
+func (gc *GatewayClient) replay(packet WebsocketPacket) bool {
+	if packet.Nonce == 0 {
+		return false
+	}
+
+	responses, found, reused := gc.replays.lookup(packet)
+	if reused {
+		if res, err := BuildPacket(packet.Type+":res", failed(protocol.ErrorProtocolNonceReused, "the nonce has already been used for another request"), packet.Nonce); err == nil {
+			gc.Send(res)
+		}
+		return true
+	}
+	if !found {
+		return false
+	}
+
+	utils.Log("debug", "casino::gateway", "client ", gc.ID, " retried '", packet.Type, "' with nonce ", packet.Nonce, ", replaying ", len(responses), " response(s)")
+	for _, res := range responses {
+		gc.Send(res)
+	}
+	return true
+}
+
+// pendingRequest is a request of the server that waits for the answer of the client
+type pendingRequest struct {
+	packetType string
+	response   chan WebsocketPacket
+}
+
+// Request sends a packet to the client and waits until it answers with "<type>:res" and the
+// same nonce. The payload of the answer is returned as JSON, whatever the encoding of the client.
+// A timeout of zero uses server.requests.timeout. It must not be called while holding locks the
+// handlers of the client need.
+func (gc *GatewayClient) Request(packetType string, payload interface{}, timeout time.Duration) (json.RawMessage, error) {
+	if timeout <= 0 {
+		timeout = gc.handlerContext.Config.Server.Requests.Timeout.Duration
+	}
+
+	nonce := serverNonceBit | gc.nextNonce.Add(1)
+	request := pendingRequest{packetType: packetType, response: make(chan WebsocketPacket, 1)}
+
+	gc.pendingMu.Lock()
+	gc.pending[nonce] = request
+	gc.pendingCount.Add(1)
+	gc.pendingMu.Unlock()
+
+	defer func() {
+		gc.pendingMu.Lock()
+		delete(gc.pending, nonce)
+		gc.pendingCount.Add(-1)
+		gc.pendingMu.Unlock()
+	}()
+
+	packet, err := BuildPacket(packetType, payload, nonce)
+	if err != nil {
+		return nil, err
+	}
+	gc.Send(packet)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-request.response:
+		return codec.ToJSON(gc.codec, res.Payload)
+	case <-timer.C:
+		utils.Log("debug", "casino::gateway", "client ", gc.ID, " did not answer '", packetType, "' within ", timeout)
+		return nil, ErrRequestTimeout
+	case <-gc.Done():
+		return nil, ErrClientDisconnected
+	}
+}
+
+// HandleResponse hands a message to the request of the server it answers.
+// It returns false if the message is not such an answer and has to be processed as usual.
+// Requests are answered before the message reaches the handler goroutine of the client,
+// so handlers can wait for an answer of their own client.
+func (gc *GatewayClient) HandleResponse(message []byte) bool {
+	// Most of the time nothing is waiting, which saves decoding the message twice
+	if gc.pendingCount.Load() == 0 {
+		return false
+	}
+
+	var packet WebsocketPacket
+	if err := gc.codec.Unmarshal(message, &packet); err != nil || packet.Nonce&serverNonceBit == 0 {
+		return false
+	}
+	return gc.resolveRequest(packet)
+}
+
+// resolveRequest delivers the answer to a request of the server
+func (gc *GatewayClient) resolveRequest(packet WebsocketPacket) bool {
+	gc.pendingMu.Lock()
+	request, ok := gc.pending[packet.Nonce]
+	gc.pendingMu.Unlock()
+
+	if !ok || packet.Type != request.packetType+":res" {
+		return false
+	}
+	select {
+	case request.response <- packet:
+	default:
+		// Already answered, a second answer is ignored
+	}
+	return true
+}
+
+// isServerResponse detects answers to requests of the server. The ones that reach
+// the handlers of the client usually arrived after the request timed out.
+func isServerResponse(packet WebsocketPacket) bool {
+	return packet.Nonce&serverNonceBit != 0 && strings.HasSuffix(packet.Type, ":res")
+}
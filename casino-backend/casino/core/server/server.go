@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"jhgambling/backend/core/codec"
+	"jhgambling/backend/core/config"
 	"jhgambling/backend/core/utils"
 	"net/http"
+	"slices"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -22,36 +26,78 @@ type Server struct {
 	mu         sync.Mutex
 	upgrader   websocket.Upgrader
 	httpServer *http.Server
+	closed     bool // Set by Shutdown, keeps a late Start from serving
+	apiLimiter *APILimiter
+
+	allowedOrigins []string
 }
 
-func NewServer(gateway *Gateway) *Server {
+func NewServer(gateway *Gateway, cfg config.ServerConfig) *Server {
 	return &Server{
-		clients: make(map[string]*Client),
-		gateway: gateway,
+		clients:        make(map[string]*Client),
+		gateway:        gateway,
+		apiLimiter:     NewAPILimiter(gateway.ctx.Config.Server.RateLimit),
+		allowedOrigins: cfg.AllowedOrigins,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
-				return true
+				if len(cfg.AllowedOrigins) == 0 {
+					return true
+				}
+				return slices.Contains(cfg.AllowedOrigins, r.Header.Get("Origin"))
 			},
 		},
 	}
 }
 
+// Start serves until the server fails or is shut down, in which case it returns http.ErrServerClosed
 func (s *Server) Start(addr string) error {
-	http.HandleFunc("/ws", s.handleWebSocket)
-	http.HandleFunc("/api", s.handleAPI)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	s.registerAPI(mux)
+	mux.HandleFunc("/auth/jwks.json", s.handleJWKS)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	s.httpServer = &http.Server{Addr: addr, Handler: mux}
+	s.mu.Unlock()
 
-	s.httpServer = &http.Server{Addr: addr}
 	utils.Log("info", "casino::server", "starting server on ", addr)
 	return s.httpServer.ListenAndServe()
 }
 
-func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{"message": "test"}
+// Shutdown stops accepting connections and waits for API requests that are still running.
+// Websocket connections stay open, the gateway closes them once their packets are handled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	utils.Log("info", "casino::server", "no longer accepting connections")
+	return httpServer.Shutdown(ctx)
+}
+
+// handleJWKS publishes the public keys for access tokens, so other services can verify them
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(s.gateway.ctx.Auth.Keys().PublicKeys())
 }
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Clients choose the wire encoding with ?encoding=, JSON if they don't
+	wireCodec, err := codec.Get(r.URL.Query().Get("encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		utils.Log("error", "casino::server", "error upgrading websocket:", err)
@@ -59,8 +105,12 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	if limit := s.gateway.ctx.Config.Server.RateLimit.MaxMessageBytes; limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+
 	// Create a new Gateway client
-	gatewayClient := NewGatewayClient(r.RemoteAddr, s.gateway.ctx)
+	gatewayClient := NewGatewayClient(r.RemoteAddr, wireCodec, s.gateway.ctx)
 
 	// Add client to gateway
 	s.gateway.AddClient(gatewayClient)
@@ -108,6 +158,11 @@ func (s *Server) handleClientToGateway(client *Client, gatewayClient *GatewayCli
 				return // Channel closed
 			}
 
+			// Answers to requests of the server don't have to wait for the handlers of the client
+			if gatewayClient.HandleResponse(msg) {
+				continue
+			}
+
 			// Forward message to Gateway client's incoming channel
 			gatewayClient.IncomingChan <- msg
 
@@ -119,6 +174,11 @@ func (s *Server) handleClientToGateway(client *Client, gatewayClient *GatewayCli
 
 // Handle messages from Gateway to WebSocket client
 func (s *Server) handleGatewayToClient(client *Client, gatewayClient *GatewayClient, conn *websocket.Conn) {
+	messageType := websocket.TextMessage
+	if gatewayClient.Codec().Binary() {
+		messageType = websocket.BinaryMessage
+	}
+
 	for {
 		select {
 		case msg, ok := <-gatewayClient.OutgoingChan:
@@ -127,11 +187,26 @@ func (s *Server) handleGatewayToClient(client *Client, gatewayClient *GatewayCli
 			}
 
 			// Send message to WebSocket
-			err := conn.WriteMessage(websocket.TextMessage, msg)
+			err := conn.WriteMessage(messageType, msg)
 			if err != nil {
 				utils.Log("error", "casino::server", "error writing to websocket:", err)
 				return
 			}
+		case <-gatewayClient.Done():
+			// Flush what is still queued (e.g. the reason for the disconnect), then close
+			// the connection, which ends the read loop and removes the client
+		flush:
+			for {
+				select {
+				case msg := <-gatewayClient.OutgoingChan:
+					conn.WriteMessage(messageType, msg)
+				default:
+					break flush
+				}
+			}
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			conn.Close()
+			return
 		}
 	}
 }
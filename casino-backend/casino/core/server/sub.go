@@ -11,6 +11,40 @@ type DBSubscription struct {
 	ResourceID interface{} `json:"resourceID"`
 }
 
+// Subscribe adds a subscription of the client
+func (gc *GatewayClient) Subscribe(subscription DBSubscription) {
+	gc.subscriptionsMu.Lock()
+	defer gc.subscriptionsMu.Unlock()
+	gc.subscriptions = append(gc.subscriptions, subscription)
+}
+
+// Unsubscribe removes the first matching subscription, or all of them if no table is given
+func (gc *GatewayClient) Unsubscribe(subscription DBSubscription) {
+	gc.subscriptionsMu.Lock()
+	defer gc.subscriptionsMu.Unlock()
+
+	if subscription.TableID == "" {
+		gc.subscriptions = []DBSubscription{}
+		return
+	}
+	for i, sub := range gc.subscriptions {
+		if sub.TableID == subscription.TableID && sub.ResourceID == subscription.ResourceID {
+			gc.subscriptions = append(gc.subscriptions[:i], gc.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetSubscriptions returns a copy of the subscriptions of the client
+func (gc *GatewayClient) GetSubscriptions() []DBSubscription {
+	gc.subscriptionsMu.Lock()
+	defer gc.subscriptionsMu.Unlock()
+
+	subscriptions := make([]DBSubscription, len(gc.subscriptions))
+	copy(subscriptions, gc.subscriptions)
+	return subscriptions
+}
+
 type SubscriptionManager struct {
 	gateway               *Gateway
 	ChangedRecordsChannel chan protocol.SubChangedRecord
@@ -23,26 +57,35 @@ func NewSubscriptionsManager(gateway *Gateway) *SubscriptionManager {
 	}
 }
 
-func (sub *SubscriptionManager) Update() {
-	select {
-	case changedRecord := <-sub.ChangedRecordsChannel:
-		sub.handleChangedRecord(changedRecord)
-	default:
-		break
+// Flush handles all changed records that are still queued
+func (sub *SubscriptionManager) Flush() {
+	for {
+		select {
+		case changedRecord := <-sub.ChangedRecordsChannel:
+			sub.handleChangedRecord(changedRecord)
+		default:
+			return
+		}
 	}
 }
 
 func (sub *SubscriptionManager) handleChangedRecord(rec protocol.SubChangedRecord) {
 	utils.Log("debug", "casino::server", "[sub] op:'", rec.Operation, "' table:'", rec.TableID, "' resource:'", rec.ResourceID, "'")
 
-	for _, client := range sub.gateway.Clients {
-		for _, subscription := range client.Subscriptions {
+	for _, client := range sub.gateway.GetClients() {
+		userID, _, ok := client.GetAuthentication()
+		if !ok {
+			// Subscriptions are paused while the client is logged out
+			continue
+		}
+
+		for _, subscription := range client.GetSubscriptions() {
 			isSubscribed := sub.isSubscribed(subscription, rec)
 			if isSubscribed {
 				// Client is subscribed to this record change, but we
 				// have to check if the user is allowed to view this record at all
-				if sub.canViewRecord(client.authenticatedAs, rec) {
-					client.SendSubscriptionUpdatePacket(rec)
+				if visible, ok := sub.viewRecordAs(userID, rec); ok {
+					client.SendSubscriptionUpdatePacket(visible)
 				}
 			}
 		}
@@ -67,26 +110,39 @@ func (sub *SubscriptionManager) isSubscribed(subscription DBSubscription, record
 	}
 }
 
-func (sub *SubscriptionManager) canViewRecord(userID uint, record protocol.SubChangedRecord) bool {
+// viewRecordAs checks whether the user may view the record and masks
+// all fields the user is not allowed to read
+func (sub *SubscriptionManager) viewRecordAs(userID uint, record protocol.SubChangedRecord) (protocol.SubChangedRecord, bool) {
 	user, err := sub.gateway.ctx.Database.GetUserTable().FindByID(userID)
 	if err != nil {
-		utils.Log("warn", "casino::server", "[sub] canViewRecord() failed to find user with ID:", userID)
-		return false
+		utils.Log("warn", "casino::server", "[sub] viewRecordAs() failed to find user with ID:", userID)
+		return record, false
 	}
 
 	table, err := sub.gateway.ctx.Database.GetTable(record.TableID)
 	if err != nil {
-		utils.Log("warn", "casino::server", "[sub] canViewRecord() failed to find table with ID:", record.TableID)
-		return false
+		utils.Log("warn", "casino::server", "[sub] viewRecordAs() failed to find table with ID:", record.TableID)
+		return record, false
 	}
 
 	userModel, ok := user.(*models.UserModel)
 	if !ok {
-		utils.Log("warn", "casino::server", "[sub] canViewRecord() failed to convert user")
-		return false
+		utils.Log("warn", "casino::server", "[sub] viewRecordAs() failed to convert user")
+		return record, false
+	}
+
+	if !table.CanViewChangedRecord(*userModel, record) {
+		return record, false
+	}
+
+	masked, err := table.GetPolicy().Mask(*userModel, record.Record)
+	if err != nil {
+		utils.Log("warn", "casino::server", "[sub] viewRecordAs() failed to mask record:", err)
+		return record, false
 	}
+	record.Record = masked
 
-	return table.CanViewChangedRecord(*userModel, record)
+	return record, true
 }
 
 func isZero(val interface{}) bool {
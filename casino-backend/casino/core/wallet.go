@@ -0,0 +1,52 @@
+package core
+
+import (
+	"jhgambling/backend/core/utils"
+	"jhgambling/protocol/models"
+)
+
+// CasinoWalletService implements protocol.WalletService on top of the bet table and the ledger.
+// Every game instance gets its own service, so its bets and postings record the instance.
+type CasinoWalletService struct {
+	core         *CasinoCore
+	gameInstance string // "provider/instance"
+}
+
+func NewCasinoWalletService(core *CasinoCore, gameInstance string) *CasinoWalletService {
+	return &CasinoWalletService{
+		core:         core,
+		gameInstance: gameInstance,
+	}
+}
+
+func (w *CasinoWalletService) GetBalance(userID uint) (uint, error) {
+	user, err := w.core.Database.GetUserTable().FindByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	return user.(*models.UserModel).Wallet.NetworthCents, nil
+}
+
+func (w *CasinoWalletService) PlaceBet(userID uint, amountCents uint, roundID string) error {
+	err := w.core.Database.GetBetTable().PlaceBet(userID, amountCents, roundID, w.gameInstance)
+	if err != nil {
+		utils.Log("warn", "casino::wallet", "[", w.gameInstance, "] failed to place bet '", roundID, "' of user ", userID, ": ", err)
+	}
+	return err
+}
+
+func (w *CasinoWalletService) SettleBet(roundID string, payoutCents uint) error {
+	err := w.core.Database.GetBetTable().SettleBet(roundID, payoutCents, w.gameInstance)
+	if err != nil {
+		utils.Log("warn", "casino::wallet", "[", w.gameInstance, "] failed to settle bet '", roundID, "': ", err)
+	}
+	return err
+}
+
+func (w *CasinoWalletService) RefundBet(roundID string) error {
+	err := w.core.Database.GetBetTable().RefundBet(roundID, w.gameInstance)
+	if err != nil {
+		utils.Log("warn", "casino::wallet", "[", w.gameInstance, "] failed to refund bet '", roundID, "': ", err)
+	}
+	return err
+}
@@ -1,10 +1,38 @@
 package main
 
-import "jhgambling/backend/core"
+import (
+	"context"
+	"jhgambling/backend/core"
+	"jhgambling/backend/core/config"
+	"jhgambling/backend/core/utils"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Keeps the protocol types of the app in sync with the packets, see cmd/schemagen
+//go:generate go run ./cmd/schemagen -out ../../casino-app/src/lib/protocol
 
 func main() {
-	casino := core.NewCasino()
+	cfg, err := config.Load()
+	if err != nil {
+		utils.Log("fatal", "casino::config", "invalid configuration:\n", err)
+		os.Exit(1)
+	}
+
+	casino := core.NewCasino(cfg)
+
+	// Ctrl-C or SIGTERM shuts the casino down, a second signal kills it right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	casino.Init()
-	casino.Start()
+	if err := casino.Run(ctx); err != nil {
+		utils.Log("fatal", "casino::core", "stopped with errors: ", err)
+		os.Exit(1)
+	}
 }
@@ -1,6 +1,9 @@
 package main
 
-import "jhgambling/protocol"
+import (
+	"errors"
+	"jhgambling/protocol"
+)
 
 type ExampleProvider struct{}
 
@@ -13,5 +16,27 @@ func (p *ExampleProvider) GetName() string {
 func (p *ExampleProvider) GetInstances() []protocol.GameInstance {
 	return []protocol.GameInstance{}
 }
+func (p *ExampleProvider) GetTickRate() int {
+	return 20
+}
+func (p *ExampleProvider) CreateInstance(config protocol.GameInstanceConfig) (protocol.GameInstance, error) {
+	return nil, errors.New("the example provider does not support instances")
+}
+func (p *ExampleProvider) CloseInstance(instanceID string) error {
+	return errors.New("instance not found")
+}
+
+// Packets of the plugin, clients send them like core packets and get "example/hello:res" back
+func (p *ExampleProvider) GetPacketHandlers() []protocol.PacketHandler {
+	return []protocol.PacketHandler{
+		{
+			Type:        "example/hello",
+			RequireAuth: true,
+			Handle: func(request protocol.PacketRequest) (interface{}, error) {
+				return map[string]interface{}{"greeting": "hello", "userId": request.Client.UserID}, nil
+			},
+		},
+	}
+}
 
 var Provider protocol.GameProvider = &ExampleProvider{}
@@ -1,5 +1,22 @@
 package protocol
 
+import "time"
+
 type CasinoAdapter interface {
 	Table(id string) (Table, error)
+	// Returns the wallet service of a game instance, bets and their postings record the instance
+	Wallets(source GameInstance) WalletService
+
+	//// Client Packets ////
+
+	// Sends a packet from a game instance to a single client
+	SendToClient(source GameInstance, client GameClient, packet GamePacket) error
+	// Sends a packet from a game instance to all clients that joined it
+	BroadcastToInstance(source GameInstance, packet GamePacket) error
+	// Sends a packet from a game instance to every connected client of a user
+	SendToUser(source GameInstance, userID uint, packet GamePacket) error
+	// Sends a packet to a client that joined the game instance and waits for its answer,
+	// e.g. to let the player confirm a bet. A timeout of zero uses the default of the server.
+	// The call blocks, so it should not be made while the instance holds its own locks.
+	RequestFromClient(source GameInstance, client GameClient, packet GamePacket, timeout time.Duration) (GamePacket, error)
 }
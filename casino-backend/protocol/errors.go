@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode tells clients why a request failed. Codes never change once
+// released, clients should branch on them instead of on messages.
+type ErrorCode string
+
+// General
+const (
+	ErrorInternal       ErrorCode = "INTERNAL"        // Details are only logged on the server
+	ErrorInvalidRequest ErrorCode = "INVALID_REQUEST" // The packet could not be decoded or is missing fields
+	ErrorUnauthorized   ErrorCode = "UNAUTHORIZED"    // Not authenticated or missing a permission
+	ErrorRateLimited    ErrorCode = "RATE_LIMITED"    // Retry after the given time
+	ErrorShuttingDown   ErrorCode = "SHUTTING_DOWN"   // The server stops, reconnect once it is back
+)
+
+// Connection
+const (
+	ErrorProtocolVersionUnsupported ErrorCode = "PROTOCOL_VERSION_UNSUPPORTED" // The hello response lists the supported versions
+	ErrorProtocolHelloRequired      ErrorCode = "PROTOCOL_HELLO_REQUIRED"
+	ErrorProtocolUnknownPacket      ErrorCode = "PROTOCOL_UNKNOWN_PACKET"
+	ErrorProtocolNonceReused        ErrorCode = "PROTOCOL_NONCE_REUSED" // The nonce was already used for a different request
+	ErrorProtocolRequestTimeout     ErrorCode = "PROTOCOL_REQUEST_TIMEOUT"
+	ErrorProtocolClientDisconnected ErrorCode = "PROTOCOL_CLIENT_DISCONNECTED"
+)
+
+// Auth
+const (
+	ErrorAuthInvalidToken        ErrorCode = "AUTH_INVALID_TOKEN"
+	ErrorAuthInvalidCredentials  ErrorCode = "AUTH_INVALID_CREDENTIALS" // Sent instead of the two below when login errors are uniform
+	ErrorAuthUserNotFound        ErrorCode = "AUTH_USER_NOT_FOUND"
+	ErrorAuthWrongPassword       ErrorCode = "AUTH_WRONG_PASSWORD"
+	ErrorAuthUserExists          ErrorCode = "AUTH_USER_EXISTS"
+	ErrorAuthInvalidUsername     ErrorCode = "AUTH_INVALID_USERNAME"
+	ErrorAuthPasswordPolicy      ErrorCode = "AUTH_PASSWORD_POLICY"
+	ErrorAuthPasswordUnchanged   ErrorCode = "AUTH_PASSWORD_UNCHANGED"
+	ErrorAuthInvalidRefreshToken ErrorCode = "AUTH_INVALID_REFRESH_TOKEN"
+	ErrorAuthSessionNotFound     ErrorCode = "AUTH_SESSION_NOT_FOUND"
+	ErrorAuthSessionRevoked      ErrorCode = "AUTH_SESSION_REVOKED"
+	ErrorAuthSessionExpired      ErrorCode = "AUTH_SESSION_EXPIRED"
+	ErrorAuthRefreshTokenReused  ErrorCode = "AUTH_REFRESH_TOKEN_REUSED"
+	ErrorAuthInvalidResetCode    ErrorCode = "AUTH_INVALID_RESET_CODE"
+	ErrorAuthLookupDisabled      ErrorCode = "AUTH_LOOKUP_DISABLED"
+	ErrorAuth2FARequired         ErrorCode = "AUTH_2FA_REQUIRED"       // Continue with auth/login_2fa
+	ErrorAuth2FASetupRequired    ErrorCode = "AUTH_2FA_SETUP_REQUIRED" // Continue with auth/2fa/setup
+	ErrorAuth2FAInvalidCode      ErrorCode = "AUTH_2FA_INVALID_CODE"
+	ErrorAuth2FAInvalidChallenge ErrorCode = "AUTH_2FA_INVALID_CHALLENGE"
+	ErrorAuth2FANotSetUp         ErrorCode = "AUTH_2FA_NOT_SET_UP"
+	ErrorAuth2FAAlreadyEnabled   ErrorCode = "AUTH_2FA_ALREADY_ENABLED"
+	ErrorAuth2FAMandatory        ErrorCode = "AUTH_2FA_MANDATORY"
+)
+
+// Database
+const (
+	ErrorDBPermissionDenied   ErrorCode = "DB_PERMISSION_DENIED"
+	ErrorDBTableNotFound      ErrorCode = "DB_TABLE_NOT_FOUND"
+	ErrorDBRecordNotFound     ErrorCode = "DB_RECORD_NOT_FOUND"
+	ErrorDBInvalidData        ErrorCode = "DB_INVALID_DATA"
+	ErrorDBConflict           ErrorCode = "DB_CONFLICT" // A unique value is already taken
+	ErrorDBOperationForbidden ErrorCode = "DB_OPERATION_FORBIDDEN"
+	ErrorDBUnknownOperation   ErrorCode = "DB_UNKNOWN_OPERATION"
+)
+
+// Wallets and bets
+const (
+	ErrorWalletInsufficientFunds ErrorCode = "WALLET_INSUFFICIENT_FUNDS"
+	ErrorWalletInvalidAmount     ErrorCode = "WALLET_INVALID_AMOUNT"
+	ErrorWalletInvalidAccount    ErrorCode = "WALLET_INVALID_ACCOUNT"
+	ErrorWalletBonusReceived     ErrorCode = "WALLET_BONUS_RECEIVED"
+	ErrorBetNotFound             ErrorCode = "BET_NOT_FOUND"
+	ErrorBetConflict             ErrorCode = "BET_CONFLICT"
+	ErrorBetClosed               ErrorCode = "BET_CLOSED"
+	ErrorBetInvalid              ErrorCode = "BET_INVALID"
+)
+
+// Games
+const (
+	ErrorGameProviderNotFound ErrorCode = "GAME_PROVIDER_NOT_FOUND"
+	ErrorGameNotFound         ErrorCode = "GAME_NOT_FOUND"
+	ErrorGameExists           ErrorCode = "GAME_EXISTS"
+	ErrorGameAlreadyJoined    ErrorCode = "GAME_ALREADY_JOINED"
+	ErrorGameNotJoined        ErrorCode = "GAME_NOT_JOINED"
+	ErrorGameRejected         ErrorCode = "GAME_REJECTED" // The game provider refused the request
+)
+
+// Error is an error whose code and message can be shown to clients.
+// The cause is only meant for the server log.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Cause   error
+}
+
+// NewError creates an error from the catalog
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf creates an error from the catalog with a formatted message
+func Errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches errors with the same code, so errors.Is keeps working when the message has more details
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.Code == e.Code
+}
+
+// WithCause returns a copy of the error that carries the internal cause
+func (e *Error) WithCause(cause error) *Error {
+	return &Error{Code: e.Code, Message: e.Message, Cause: cause}
+}
+
+// AsError finds the first error of the catalog in the chain
+func AsError(err error) (*Error, bool) {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded, true
+	}
+	return nil, false
+}
+
+// CodeOf returns the code of an error, errors outside of the catalog are internal
+func CodeOf(err error) ErrorCode {
+	if coded, ok := AsError(err); ok {
+		return coded.Code
+	}
+	return ErrorInternal
+}
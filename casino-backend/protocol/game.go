@@ -1,5 +1,10 @@
 package protocol
 
+// GameProvider and GameInstance have to be safe for concurrent use. The casino calls
+// GetInstances from its game loop while clients create and close instances, and Tick
+// runs on the loop of the instance while HandleClientJoin, HandleClientLeave and
+// HandlePacket run on the goroutines of the clients. The casino only guarantees that
+// CloseInstance is not called during a Tick of the instance and that no Tick follows it.
 type GameProvider interface {
 	// Returns the unique identifier for this game type (e.g. blackjack, poker, etc.)
 	GetID() string
@@ -7,6 +12,16 @@ type GameProvider interface {
 	GetName() string
 	// Get all running game instances
 	GetInstances() []GameInstance
+	// Returns how many times per second GameInstance.Tick() should be called, 0 disables the game loop
+	GetTickRate() int
+
+	//// Instance Lifecycle ////
+
+	// Opens a new game instance, e.g. another slot machine or blackjack table
+	CreateInstance(config GameInstanceConfig) (GameInstance, error)
+	// Shuts down a running game instance. Open rounds should be settled or refunded here,
+	// bets that are still open when the casino shuts down are refunded afterwards.
+	CloseInstance(instanceID string) error
 }
 
 type GameInstance interface {
@@ -28,15 +43,24 @@ type GameInstance interface {
 
 	//// Client Packets ////
 
+	// Called when a gateway client joins this instance
 	HandleClientJoin(client GameClient)
+	// Called when a gateway client leaves this instance or disconnects, but not for the
+	// clients that are still in the instance when it is closed
 	HandleClientLeave(clientID string)
-	HandlePacket(packet GamePacket)
+	// Called for every game packet a joined client sends to this instance
+	HandlePacket(client GameClient, packet GamePacket)
 
 	//// Game Loop ////
 
 	Tick()
 }
 
+type GameInstanceConfig struct {
+	ID       string                 `json:"id"` // Requested instance ID, providers may generate one if it is empty
+	Settings map[string]interface{} `json:"settings"`
+}
+
 type GameUserAssociation struct {
 	UserID string `json:"user_id"`
 	GameID string `json:"game_id"` // ID of the game instance
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BetStatusPlaced   = "placed"
+	BetStatusSettled  = "settled"
+	BetStatusRefunded = "refunded"
+)
+
+// The BetModel represents a bet placed by a player in a game round.
+// The stake is held in escrow until the bet is settled or refunded.
+type BetModel struct {
+	gorm.Model
+
+	RoundID      string `gorm:"uniqueIndex:idx_bet_round,priority:2"` // Only unique within the game instance
+	UserID       uint   `gorm:"index"`
+	WalletID     uint   `gorm:"index"`
+	GameInstance string `gorm:"uniqueIndex:idx_bet_round,priority:1"` // "provider/instance" that placed the bet
+
+	AmountCents uint
+	PayoutCents uint
+	Status      string
+	SettledAt   *time.Time
+}
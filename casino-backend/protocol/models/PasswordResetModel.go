@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The PasswordResetModel is a one-time code an admin hands to a user who lost their password.
+// Only a hash of the code is stored.
+type PasswordResetModel struct {
+	gorm.Model
+
+	UserID    uint `gorm:"index"`
+	CreatedBy uint // Admin who issued the code
+
+	CodeHash  string `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Grants every permission
+const PermissionAll = "*"
+
+// The RoleModel represents a named set of permissions, e.g. "support" or "moderator".
+// Permissions have the form "<table or area>.<operation>" (e.g. "wallets.read"),
+// "<area>.*" grants all operations of an area.
+type RoleModel struct {
+	gorm.Model
+
+	Name        string `gorm:"uniqueIndex"`
+	DisplayName string
+	Permissions []string `gorm:"serializer:json"`
+}
+
+// The UserRoleModel assigns a role to a user
+type UserRoleModel struct {
+	gorm.Model
+
+	UserID uint `gorm:"index"`
+	RoleID uint `gorm:"index"`
+
+	Role RoleModel `gorm:"foreignKey:RoleID"`
+}
+
+// HasPermission returns whether the role grants the permission
+func (r RoleModel) HasPermission(permission string) bool {
+	for _, granted := range r.Permissions {
+		if granted == PermissionAll || granted == permission {
+			return true
+		}
+		if area, ok := strings.CutSuffix(granted, ".*"); ok && strings.HasPrefix(permission, area+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission returns whether any role of the user grants the permission.
+// Users with the IsAdmin flag have every permission.
+func (u UserModel) HasPermission(permission string) bool {
+	if u.IsAdmin {
+		return true
+	}
+	for _, userRole := range u.Roles {
+		if userRole.Role.HasPermission(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermissionsOf returns whether the user holds every permission of the other user,
+// only users with all permissions cover users with the IsAdmin flag
+func (u UserModel) HasPermissionsOf(other UserModel) bool {
+	if u.HasPermission(PermissionAll) {
+		return true
+	}
+	if other.IsAdmin {
+		return false
+	}
+	for _, userRole := range other.Roles {
+		for _, permission := range userRole.Role.Permissions {
+			if !u.HasPermission(permission) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// HasRole returns whether the user has been assigned the role
+func (u UserModel) HasRole(name string) bool {
+	for _, userRole := range u.Roles {
+		if userRole.Role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleNames returns the names of all roles of the user
+func (u UserModel) RoleNames() []string {
+	names := make([]string, 0, len(u.Roles))
+	for _, userRole := range u.Roles {
+		names = append(names, userRole.Role.Name)
+	}
+	return names
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The SessionModel represents a login of a user on one device.
+// Access tokens reference the session, so revoking it invalidates them.
+type SessionModel struct {
+	gorm.Model
+
+	SessionID string `gorm:"uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+
+	// Only the hash of the current refresh token is stored, it changes on every refresh
+	RefreshTokenHash string `json:"-"`
+
+	Addr       string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// IsActive reports whether the session can still be used
+func (s *SessionModel) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The SigningKeyModel stores a key used to sign access tokens.
+// Tokens carry the KeyID in their "kid" header, so old keys keep
+// verifying tokens for a while after a newer key took over.
+type SigningKeyModel struct {
+	gorm.Model
+
+	KeyID     string `gorm:"uniqueIndex"`
+	Algorithm string
+
+	PrivateKey []byte `json:"-"` // Encrypted with the auth secret, empty for HMAC keys
+	PublicKey  []byte // PKIX DER, empty for HMAC keys
+
+	RetiredAt *time.Time // No longer used for signing
+	ExpiresAt *time.Time // No longer accepted for verification
+}
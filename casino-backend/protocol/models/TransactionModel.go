@@ -0,0 +1,26 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// The TransactionModel represents a single posting in the casino ledger.
+// Every transfer of money creates two postings with the same TransferID,
+// one debiting and one crediting an account, so all postings sum up to zero.
+type TransactionModel struct {
+	gorm.Model
+
+	TransferID string `gorm:"index"`
+
+	// Account that is debited or credited, e.g. "wallet:12" or "casino:house"
+	Account  string `gorm:"index"`
+	WalletID uint   `gorm:"index"` // 0 for casino accounts
+
+	AmountCents  int64 // Positive for credits, negative for debits
+	BalanceCents int64 // Balance of the account after this posting
+
+	Reason       string
+	Counterparty string // Account on the other side of the transfer
+	GameInstance string // "provider/instance" if the transfer was made by a game
+	Reference    string // Free reference, e.g. the ID of a game round
+}
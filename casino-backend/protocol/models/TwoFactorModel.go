@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The TwoFactorModel holds the TOTP secret of a user.
+// It is pending until the user confirmed it with a first code.
+type TwoFactorModel struct {
+	gorm.Model
+
+	UserID uint `gorm:"uniqueIndex"`
+
+	Secret       []byte `json:"-"` // Encrypted with the auth secret
+	Enabled      bool
+	EnabledAt    *time.Time
+	LastUsedStep int64 `json:"-"` // Codes of this or earlier time steps can't be used again
+}
+
+// The RecoveryCodeModel is a single-use code that replaces a TOTP code
+// when the user lost their authenticator. Only a hash of the code is stored.
+type RecoveryCodeModel struct {
+	gorm.Model
+
+	UserID   uint   `gorm:"index"`
+	CodeHash string `json:"-"`
+	UsedAt   *time.Time
+}
@@ -16,5 +16,6 @@ type UserModel struct {
 	JoinedAt     time.Time
 	IsAdmin      bool
 
-	Wallet WalletModel `gorm:"foreignKey:UserID"`
+	Wallet WalletModel     `gorm:"foreignKey:UserID"`
+	Roles  []UserRoleModel `gorm:"foreignKey:UserID"`
 }
@@ -0,0 +1,28 @@
+package protocol
+
+import "encoding/json"
+
+// PacketProvider can be implemented by a GameProvider to handle gateway packets
+// outside of game instances, e.g. leaderboards or statistics of the game.
+// The packets are registered when the plugin is loaded.
+type PacketProvider interface {
+	GetPacketHandlers() []PacketHandler
+}
+
+type PacketHandler struct {
+	// Packet type, it has to start with the ID of the provider (e.g. "blackjack/stats")
+	Type string
+	// Rejects clients that haven't authenticated
+	RequireAuth bool
+	// Permission the user needs, implies RequireAuth
+	Permission string
+	// Called for every packet of the type. The result is sent back as the payload of
+	// "<type>:res". Errors created with NewError are sent with their code and message,
+	// any other error is only reported as INTERNAL.
+	Handle func(request PacketRequest) (interface{}, error)
+}
+
+type PacketRequest struct {
+	Client  GameClient // UserID is zero if the client hasn't authenticated
+	Payload json.RawMessage
+}
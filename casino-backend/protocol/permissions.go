@@ -0,0 +1,24 @@
+package protocol
+
+import "jhgambling/protocol/models"
+
+// Table operations used in permissions, e.g. "wallets.read"
+const (
+	OperationCreate = "create"
+	OperationRead   = "read"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Permissions that are not tied to a table operation
+const (
+	PermissionAll           = models.PermissionAll
+	PermissionGamesManage   = "games.manage"   // Create and close game instances
+	PermissionWalletsAdjust = "wallets.adjust" // Correct wallet balances through the ledger
+	PermissionUsersReset    = "users.reset"    // Issue password reset codes
+)
+
+// Permission builds the permission for an operation on a table
+func Permission(tableID string, operation string) string {
+	return tableID + "." + operation
+}
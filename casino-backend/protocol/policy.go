@@ -0,0 +1,342 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"jhgambling/protocol/models"
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// AccessLevel describes who may access a field, from least to most privileged
+type AccessLevel int
+
+const (
+	AccessPublic AccessLevel = iota // Every authenticated user
+	AccessOwner                     // The user owning the record
+	AccessAdmin                     // Admins
+	AccessSystem                    // Only the server itself, never through AsUser operations
+)
+
+func (l AccessLevel) String() string {
+	switch l {
+	case AccessPublic:
+		return "public"
+	case AccessOwner:
+		return "owner"
+	case AccessAdmin:
+		return "admin"
+	case AccessSystem:
+		return "system"
+	default:
+		return fmt.Sprintf("AccessLevel(%d)", int(l))
+	}
+}
+
+// UserAccessLevel returns the access level a user has on a record
+func UserAccessLevel(user models.UserModel, isOwner bool) AccessLevel {
+	if user.HasPermission(PermissionAll) {
+		return AccessAdmin
+	}
+	if isOwner {
+		return AccessOwner
+	}
+	return AccessPublic
+}
+
+// FieldPolicy declares who may read and write a single column
+type FieldPolicy struct {
+	Read  AccessLevel
+	Write AccessLevel
+}
+
+// FieldPolicies maps column names (e.g. "networth_cents") to their policy.
+// Columns without a policy are readable by everyone but only writable by the system.
+// Tables without any field policies don't restrict single fields at all.
+type FieldPolicies map[string]FieldPolicy
+
+var fieldNaming = schema.NamingStrategy{}
+
+// columnName turns both Go field names and column names into the column name
+func columnName(field string) string {
+	return fieldNaming.ColumnName("", field)
+}
+
+func (p FieldPolicies) get(field string) FieldPolicy {
+	if policy, ok := p[columnName(field)]; ok {
+		return policy
+	}
+	return FieldPolicy{Read: AccessPublic, Write: AccessSystem}
+}
+
+// CanWrite returns whether a field may be written with the given access level
+func (p FieldPolicies) CanWrite(field string, level AccessLevel) bool {
+	return level >= p.get(field).Write
+}
+
+// CanRead returns whether a field may be read with the given access level
+func (p FieldPolicies) CanRead(field string, level AccessLevel) bool {
+	return level >= p.get(field).Read
+}
+
+// NormalizeFields converts the keys of a partial update to column names, so
+// clients may use either "displayName", "DisplayName" or "display_name"
+func NormalizeFields(fields map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(fields))
+	for field, value := range fields {
+		normalized[columnName(field)] = value
+	}
+	return normalized
+}
+
+// CheckWrite makes sure every field of a partial update may be written with the given access level
+func (p FieldPolicies) CheckWrite(data map[string]interface{}, level AccessLevel) error {
+	for field := range data {
+		if !p.CanWrite(field, level) {
+			return Errorf(ErrorDBPermissionDenied, "permission denied: field '%s' cannot be written", field)
+		}
+	}
+	return nil
+}
+
+// MaskRead converts a record into a map that only contains the fields readable with the given access level
+func (p FieldPolicies) MaskRead(record interface{}, level AccessLevel) (map[string]interface{}, error) {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(bytes, &fields); err != nil {
+		return nil, err
+	}
+
+	for field := range fields {
+		if !p.CanRead(field, level) {
+			delete(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+var ErrPermissionDenied = NewError(ErrorDBPermissionDenied, "permission denied")
+
+// TablePolicy declares who may perform which operation on a table. It is
+// evaluated by all AsUser operations and for subscription updates.
+// Users with the permission for an operation (e.g. "wallets.read") are
+// treated like admins for that operation.
+type TablePolicy struct {
+	Table string // Set by the table, used to look up permissions
+
+	Create AccessLevel
+	Read   AccessLevel
+	Update AccessLevel
+	Delete AccessLevel
+
+	// Column holding the owner of a record, required for AccessOwner rules (e.g. "user_id")
+	OwnerColumn string
+	// Returns the owner column value of records the user owns, defaults to the user ID
+	OwnerKey func(user models.UserModel) uint
+
+	Fields FieldPolicies
+}
+
+// DefaultTablePolicy is used for tables that don't declare a policy: only admins have access
+func DefaultTablePolicy() *TablePolicy {
+	return &TablePolicy{
+		Create: AccessAdmin,
+		Read:   AccessAdmin,
+		Update: AccessAdmin,
+		Delete: AccessAdmin,
+	}
+}
+
+func (p *TablePolicy) ownerKey(user models.UserModel) uint {
+	if p.OwnerKey != nil {
+		return p.OwnerKey(user)
+	}
+	return user.ID
+}
+
+// IsOwner returns whether the user owns the record. Records can be models or partial field maps.
+func (p *TablePolicy) IsOwner(user models.UserModel, record interface{}) bool {
+	if p.OwnerColumn == "" || record == nil {
+		return false
+	}
+
+	owner, ok := fieldValue(record, p.OwnerColumn)
+	if !ok {
+		return false
+	}
+
+	id, err := ParseID(owner)
+	return err == nil && id != 0 && id == p.ownerKey(user)
+}
+
+// Level returns the access level the user has on a record for an operation
+func (p *TablePolicy) Level(user models.UserModel, operation string, record interface{}) AccessLevel {
+	level := UserAccessLevel(user, p.IsOwner(user, record))
+	if level < AccessAdmin && p.Table != "" && user.HasPermission(Permission(p.Table, operation)) {
+		level = AccessAdmin
+	}
+	return level
+}
+
+func (p *TablePolicy) check(required AccessLevel, operation string, user models.UserModel, record interface{}) error {
+	if p.Level(user, operation, record) >= required {
+		return nil
+	}
+
+	switch required {
+	case AccessOwner:
+		return Errorf(ErrorDBPermissionDenied, "permission denied: you can only %s your own records", operation)
+	case AccessAdmin:
+		return Errorf(ErrorDBPermissionDenied, "permission denied: requires the '%s' permission", Permission(p.Table, operation))
+	default:
+		return Errorf(ErrorDBPermissionDenied, "permission denied: users cannot %s these records", operation)
+	}
+}
+
+// CheckCreate checks whether the user may create the record
+func (p *TablePolicy) CheckCreate(user models.UserModel, data interface{}) error {
+	if err := p.check(p.Create, OperationCreate, user, data); err != nil {
+		return err
+	}
+	return p.checkFields(user, OperationCreate, data, data)
+}
+
+// CheckRead checks whether the user may read the record
+func (p *TablePolicy) CheckRead(user models.UserModel, record interface{}) error {
+	return p.check(p.Read, OperationRead, user, record)
+}
+
+// CheckUpdate checks whether the user may apply the update to the existing record
+func (p *TablePolicy) CheckUpdate(user models.UserModel, existing interface{}, data interface{}) error {
+	if err := p.check(p.Update, OperationUpdate, user, existing); err != nil {
+		return err
+	}
+	return p.checkFields(user, OperationUpdate, existing, data)
+}
+
+// CheckDelete checks whether the user may delete the record
+func (p *TablePolicy) CheckDelete(user models.UserModel, record interface{}) error {
+	return p.check(p.Delete, OperationDelete, user, record)
+}
+
+// CanView returns whether a changed record may be sent to the user
+func (p *TablePolicy) CanView(user models.UserModel, record interface{}) bool {
+	return p.CheckRead(user, record) == nil
+}
+
+// checkFields makes sure all written fields may be written, only partial updates given as maps can be checked
+func (p *TablePolicy) checkFields(user models.UserModel, operation string, record interface{}, data interface{}) error {
+	if len(p.Fields) == 0 {
+		return nil
+	}
+
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return NewError(ErrorDBInvalidData, "invalid data type: expected map[string]interface{}")
+	}
+
+	return p.Fields.CheckWrite(fields, p.Level(user, operation, record))
+}
+
+// Mask removes all fields the user may not read from the record
+func (p *TablePolicy) Mask(user models.UserModel, record interface{}) (interface{}, error) {
+	if len(p.Fields) == 0 || record == nil {
+		return record, nil
+	}
+	return p.Fields.MaskRead(record, p.Level(user, OperationRead, record))
+}
+
+// ScopeQuery restricts a query to the records the user may read
+func (p *TablePolicy) ScopeQuery(user models.UserModel, query *gorm.DB) (*gorm.DB, error) {
+	if p.Level(user, OperationRead, nil) >= p.Read {
+		return query, nil
+	}
+
+	if p.Read == AccessOwner && p.OwnerColumn != "" {
+		return query.Where(fmt.Sprintf("%s = ?", columnName(p.OwnerColumn)), p.ownerKey(user)), nil
+	}
+
+	return nil, p.check(p.Read, OperationRead, user, nil)
+}
+
+// ParseID converts record IDs from JSON (float64), Go integers and strings to uint
+func ParseID(id interface{}) (uint, error) {
+	switch v := id.(type) {
+	case uint:
+		return v, nil
+	case uint64:
+		return uint(v), nil
+	case uint32:
+		return uint(v), nil
+	case int:
+		if v >= 0 {
+			return uint(v), nil
+		}
+	case int64:
+		if v >= 0 {
+			return uint(v), nil
+		}
+	case float64:
+		if v >= 0 && v == float64(uint(v)) {
+			return uint(v), nil
+		}
+	case string:
+		if parsed, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return uint(parsed), nil
+		}
+	}
+	return 0, Errorf(ErrorDBInvalidData, "invalid ID format: %v", id)
+}
+
+// fieldValue looks up a column in a field map or a (pointer to a) struct, including embedded structs
+func fieldValue(record interface{}, column string) (interface{}, bool) {
+	column = columnName(column)
+
+	if fields, ok := record.(map[string]interface{}); ok {
+		for key, value := range fields {
+			if columnName(key) == column {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+
+	v := reflect.ValueOf(record)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	return structFieldValue(v, column)
+}
+
+func structFieldValue(v reflect.Value, column string) (interface{}, bool) {
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			if value, ok := structFieldValue(v.Field(i), column); ok {
+				return value, true
+			}
+			continue
+		}
+		if columnName(field.Name) == column {
+			return v.Field(i).Interface(), true
+		}
+	}
+	return nil, false
+}
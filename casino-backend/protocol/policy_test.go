@@ -0,0 +1,220 @@
+package protocol_test
+
+import (
+	"fmt"
+	"jhgambling/protocol"
+	"jhgambling/protocol/models"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type wallet struct {
+	ID            uint
+	UserID        uint
+	DisplayName   string
+	NetworthCents int64
+	Secret        string
+}
+
+var (
+	owner    = models.UserModel{Model: gorm.Model{ID: 1}}
+	stranger = models.UserModel{Model: gorm.Model{ID: 2}}
+	support  = models.UserModel{Model: gorm.Model{ID: 3}, Roles: []models.UserRoleModel{
+		{Role: models.RoleModel{Permissions: []string{"wallets.read"}}},
+	}}
+	admin = models.UserModel{Model: gorm.Model{ID: 4}, IsAdmin: true}
+)
+
+func walletPolicy() *protocol.TablePolicy {
+	return &protocol.TablePolicy{
+		Table:       "wallets",
+		Create:      protocol.AccessAdmin,
+		Read:        protocol.AccessOwner,
+		Update:      protocol.AccessOwner,
+		Delete:      protocol.AccessSystem,
+		OwnerColumn: "UserID",
+		Fields: protocol.FieldPolicies{
+			"display_name":   {Read: protocol.AccessPublic, Write: protocol.AccessOwner},
+			"networth_cents": {Read: protocol.AccessOwner, Write: protocol.AccessAdmin},
+			"secret":         {Read: protocol.AccessAdmin, Write: protocol.AccessSystem},
+		},
+	}
+}
+
+func ownersWallet() *wallet {
+	return &wallet{ID: 7, UserID: owner.ID, DisplayName: "Main", NetworthCents: 1000, Secret: "hidden"}
+}
+
+func TestTablePolicyOperations(t *testing.T) {
+	policy := walletPolicy()
+	record := ownersWallet()
+
+	tests := []struct {
+		name                                         string
+		user                                         models.UserModel
+		wantCreate, wantRead, wantUpdate, wantDelete bool
+	}{
+		{"stranger", stranger, false, false, false, false},
+		{"owner", owner, false, true, true, false},
+		{"role with the read permission", support, false, true, false, false},
+		{"admin", admin, true, true, true, false},
+	}
+
+	for _, test := range tests {
+		checks := []struct {
+			operation string
+			err       error
+			want      bool
+		}{
+			{"create", policy.CheckCreate(test.user, map[string]interface{}{"display_name": "New"}), test.wantCreate},
+			{"read", policy.CheckRead(test.user, record), test.wantRead},
+			{"update", policy.CheckUpdate(test.user, record, map[string]interface{}{"displayName": "Renamed"}), test.wantUpdate},
+			{"delete", policy.CheckDelete(test.user, record), test.wantDelete},
+		}
+		for _, check := range checks {
+			if check.want && check.err != nil {
+				t.Errorf("%s, %s: %v", test.name, check.operation, check.err)
+			}
+			if !check.want && protocol.CodeOf(check.err) != protocol.ErrorDBPermissionDenied {
+				t.Errorf("%s, %s: got %v, want permission denied", test.name, check.operation, check.err)
+			}
+		}
+		if canView := policy.CanView(test.user, record); canView != test.wantRead {
+			t.Errorf("%s: CanView returned %v, want %v", test.name, canView, test.wantRead)
+		}
+	}
+}
+
+func TestTablePolicyFieldWrites(t *testing.T) {
+	policy := walletPolicy()
+	record := ownersWallet()
+
+	tests := []struct {
+		name     string
+		user     models.UserModel
+		data     interface{}
+		wantCode protocol.ErrorCode // Empty if the update is allowed
+	}{
+		{"owner writes an owner field", owner, map[string]interface{}{"display_name": "Renamed"}, ""},
+		{"field names are normalized", owner, map[string]interface{}{"DisplayName": "Renamed"}, ""},
+		{"owner writes an admin field", owner, map[string]interface{}{"networthCents": 0}, protocol.ErrorDBPermissionDenied},
+		{"admin writes an admin field", admin, map[string]interface{}{"networth_cents": 0}, ""},
+		{"admin writes a system field", admin, map[string]interface{}{"secret": "x"}, protocol.ErrorDBPermissionDenied},
+		{"fields without a policy are system fields", admin, map[string]interface{}{"user_id": 2}, protocol.ErrorDBPermissionDenied},
+		{"one denied field denies the update", owner, map[string]interface{}{"display_name": "Renamed", "secret": "x"}, protocol.ErrorDBPermissionDenied},
+		{"whole records can't be checked", owner, record, protocol.ErrorDBInvalidData},
+	}
+
+	for _, test := range tests {
+		err := policy.CheckUpdate(test.user, record, test.data)
+		if code := protocol.CodeOf(err); (err == nil) != (test.wantCode == "") || (err != nil && code != test.wantCode) {
+			t.Errorf("%s: got %v, want code %q", test.name, err, test.wantCode)
+		}
+	}
+}
+
+func TestTablePolicyMask(t *testing.T) {
+	policy := walletPolicy()
+
+	tests := []struct {
+		name       string
+		user       models.UserModel
+		wantFields []string
+	}{
+		{"stranger", stranger, []string{"DisplayName", "ID", "UserID"}},
+		{"owner", owner, []string{"DisplayName", "ID", "NetworthCents", "UserID"}},
+		{"role with the read permission", support, []string{"DisplayName", "ID", "NetworthCents", "Secret", "UserID"}},
+		{"admin", admin, []string{"DisplayName", "ID", "NetworthCents", "Secret", "UserID"}},
+	}
+
+	for _, test := range tests {
+		masked, err := policy.Mask(test.user, ownersWallet())
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		fields := []string{}
+		for field := range masked.(map[string]interface{}) {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		if !reflect.DeepEqual(fields, test.wantFields) {
+			t.Errorf("%s: got fields %v, want %v", test.name, fields, test.wantFields)
+		}
+	}
+
+	// Tables without field policies return the record as it is
+	record := ownersWallet()
+	policy.Fields = nil
+	if masked, err := policy.Mask(stranger, record); err != nil || masked != record {
+		t.Errorf("without field policies: got (%v, %v), want the record", masked, err)
+	}
+}
+
+func TestTablePolicyScopeQuery(t *testing.T) {
+	db, err := gorm.Open(nil)
+	if err != nil {
+		t.Fatalf("opening gorm: %v", err)
+	}
+
+	adminOnly := protocol.DefaultTablePolicy()
+	adminOnly.Table = "wallets"
+	public := walletPolicy()
+	public.Read = protocol.AccessPublic
+	ownerWithoutColumn := walletPolicy()
+	ownerWithoutColumn.OwnerColumn = ""
+
+	tests := []struct {
+		name      string
+		policy    *protocol.TablePolicy
+		user      models.UserModel
+		wantWhere string // Empty if the query isn't restricted
+		wantErr   bool
+	}{
+		{"owner read, stranger", walletPolicy(), stranger, "user_id = ? [2]", false},
+		{"owner read, owner", walletPolicy(), owner, "user_id = ? [1]", false},
+		{"owner read, role with the read permission", walletPolicy(), support, "", false},
+		{"owner read, admin", walletPolicy(), admin, "", false},
+		{"owner read without owner column", ownerWithoutColumn, owner, "", true},
+		{"public read, stranger", public, stranger, "", false},
+		{"admin read, stranger", adminOnly, stranger, "", true},
+		{"admin read, role with the read permission", adminOnly, support, "", false},
+		{"admin read, admin", adminOnly, admin, "", false},
+	}
+
+	for _, test := range tests {
+		query, err := test.policy.ScopeQuery(test.user, db)
+		if test.wantErr {
+			if protocol.CodeOf(err) != protocol.ErrorDBPermissionDenied {
+				t.Errorf("%s: got %v, want permission denied", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if where := whereOf(query); where != test.wantWhere {
+			t.Errorf("%s: got where %q, want %q", test.name, where, test.wantWhere)
+		}
+	}
+}
+
+// whereOf describes the conditions added to a query, e.g. "user_id = ? [1]"
+func whereOf(query *gorm.DB) string {
+	where, ok := query.Statement.Clauses["WHERE"].Expression.(clause.Where)
+	if !ok {
+		return ""
+	}
+	conditions := ""
+	for _, expr := range where.Exprs {
+		if e, ok := expr.(clause.Expr); ok {
+			conditions += fmt.Sprintf("%s %v", e.SQL, e.Vars)
+		}
+	}
+	return conditions
+}
@@ -8,6 +8,8 @@ type GamePacket struct {
 	Nonce   uint64          `json:"nonce,omitempty"`
 }
 
+// GameClient identifies a gateway client that has joined a game instance
 type GameClient struct {
-	ID string
+	ID     string `json:"id"`
+	UserID uint   `json:"user_id"`
 }
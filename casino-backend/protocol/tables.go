@@ -1,11 +1,16 @@
 package protocol
 
 import (
+	"encoding/json"
+	"errors"
 	"jhgambling/protocol/models"
+	"reflect"
 
 	"gorm.io/gorm"
 )
 
+var ErrRecordNotFound = NewError(ErrorDBRecordNotFound, "record not found")
+
 // Table defines the interface that all table implementations must follow
 type Table interface {
 	// Basic information
@@ -30,6 +35,9 @@ type Table interface {
 	SetDB(db *gorm.DB)
 	GetDB() *gorm.DB
 
+	// Access control
+	GetPolicy() *TablePolicy
+
 	SetSubscriptionChannel(channel *chan SubChangedRecord)
 	PushRecordChange(operation string, id interface{}, data interface{})
 	CanViewChangedRecord(user models.UserModel, record SubChangedRecord) bool
@@ -38,12 +46,19 @@ type Table interface {
 	Repair()
 }
 
+// ViewTable is implemented by tables whose AsUser operations return records
+// as another type than their model, e.g. without sensitive fields
+type ViewTable interface {
+	GetViewType() interface{}
+}
+
 // BaseTable provides a default implementation of the Table interface
 type BaseTable struct {
 	ID                  string
 	DB                  *gorm.DB
 	SubscriptionChannel *chan SubChangedRecord
 	Model               interface{}
+	Policy              *TablePolicy // Falls back to DefaultTablePolicy() if nil
 }
 
 // GetID returns the table identifier
@@ -56,6 +71,40 @@ func (t *BaseTable) GetModelType() interface{} {
 	return t.Model
 }
 
+// GetPolicy returns who may access the records of this table
+func (t *BaseTable) GetPolicy() *TablePolicy {
+	if t.Policy == nil {
+		t.Policy = DefaultTablePolicy()
+	}
+	if t.Policy.Table == "" {
+		t.Policy.Table = t.ID
+	}
+	return t.Policy
+}
+
+// NewRecord returns a new, empty instance of the table model
+func (t *BaseTable) NewRecord() interface{} {
+	return reflect.New(reflect.TypeOf(t.Model).Elem()).Interface()
+}
+
+// DecodeRecord converts data received from a client (usually a field map) into a new model instance
+func (t *BaseTable) DecodeRecord(data interface{}) (interface{}, error) {
+	if reflect.TypeOf(data) == reflect.TypeOf(t.Model) {
+		return data, nil
+	}
+
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	record := t.NewRecord()
+	if err := json.Unmarshal(bytes, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
 // SetDB sets the database connection
 func (t *BaseTable) SetDB(db *gorm.DB) {
 	t.DB = db
@@ -87,25 +136,43 @@ func (t *BaseTable) Create(data interface{}) error {
 
 // FindByID retrieves a record by its ID
 func (t *BaseTable) FindByID(id interface{}) (interface{}, error) {
-	model := t.GetModelType()
+	model := t.NewRecord()
 	result := t.DB.First(model, id)
 	return model, result.Error
 }
 
 // FindAll retrieves multiple records with pagination
 func (t *BaseTable) FindAll(limit, offset int) ([]interface{}, error) {
+	return t.FindAllInQuery(t.DB, limit, offset)
+}
+
+// FindAllInQuery retrieves the records matching a query with pagination
+func (t *BaseTable) FindAllInQuery(query *gorm.DB, limit, offset int) ([]interface{}, error) {
 	if limit <= 0 {
 		limit = 10 // Default limit
 	}
 
-	var results []interface{}
-	model := t.GetModelType()
-	err := t.DB.Model(model).Limit(limit).Offset(offset).Find(&results).Error
-	return results, err
+	records := reflect.New(reflect.SliceOf(reflect.TypeOf(t.Model).Elem()))
+	err := query.Limit(limit).Offset(offset).Find(records.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to []interface{}
+	slice := records.Elem()
+	results := make([]interface{}, slice.Len())
+	for i := range results {
+		results[i] = slice.Index(i).Addr().Interface()
+	}
+	return results, nil
 }
 
 // Update modifies an existing record
 func (t *BaseTable) Update(id interface{}, data interface{}) error {
+	if fields, ok := data.(map[string]interface{}); ok {
+		data = NormalizeFields(fields)
+	}
+
 	// Perform the update
 	err := t.DB.Model(t.GetModelType()).Where("id = ?", id).Updates(data).Error
 	if err != nil {
@@ -113,7 +180,7 @@ func (t *BaseTable) Update(id interface{}, data interface{}) error {
 	}
 
 	// Create a new instance of the model to hold the updated row
-	updated := t.GetModelType()
+	updated := t.NewRecord()
 
 	// Fetch the updated record
 	err = t.DB.First(updated, "id = ?", id).Error
@@ -139,35 +206,101 @@ func (t *BaseTable) Delete(id interface{}) error {
 
 // CreateAsUser creates a new record with user permission check
 func (t *BaseTable) CreateAsUser(user models.UserModel, data interface{}) error {
-	// Add Permission check
-	return t.Create(data)
+	if err := t.GetPolicy().CheckCreate(user, data); err != nil {
+		return err
+	}
+
+	record, err := t.DecodeRecord(data)
+	if err != nil {
+		return NewError(ErrorDBInvalidData, "invalid record data").WithCause(err)
+	}
+	return t.Create(record)
 }
 
 // FindByIDAsUser retrieves a record by ID with user permission check
 func (t *BaseTable) FindByIDAsUser(user models.UserModel, id interface{}) (interface{}, error) {
-	// Add Permission check
-	return t.FindByID(id)
+	record, err := t.FindByID(id)
+	if err != nil {
+		return nil, RecordError(err)
+	}
+
+	policy := t.GetPolicy()
+	if err := policy.CheckRead(user, record); err != nil {
+		return nil, err
+	}
+	return policy.Mask(user, record)
 }
 
 // FindAllAsUser retrieves multiple records with pagination and user permission check
 func (t *BaseTable) FindAllAsUser(user models.UserModel, limit, offset int) ([]interface{}, error) {
-	// Add Permission check
-	return t.FindAll(limit, offset)
+	policy := t.GetPolicy()
+
+	query, err := policy.ScopeQuery(user, t.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := t.FindAllInQuery(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return MaskAll(policy, user, records)
 }
 
 // UpdateAsUser modifies an existing record with user permission check
 func (t *BaseTable) UpdateAsUser(user models.UserModel, id interface{}, data interface{}) error {
-	// Add Permission check
+	existing, err := t.FindByID(id)
+	if err != nil {
+		return RecordError(err)
+	}
+
+	if err := t.GetPolicy().CheckUpdate(user, existing, data); err != nil {
+		return err
+	}
 	return t.Update(id, data)
 }
 
 // DeleteAsUser removes a record with user permission check
 func (t *BaseTable) DeleteAsUser(user models.UserModel, id interface{}) error {
-	// Add Permission check
+	existing, err := t.FindByID(id)
+	if err != nil {
+		return RecordError(err)
+	}
+
+	if err := t.GetPolicy().CheckDelete(user, existing); err != nil {
+		return err
+	}
 	return t.Delete(id)
 }
 
+// RecordError turns a missing record into an error from the catalog, tables that
+// implement their own AsUser operations use it like BaseTable
+func RecordError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRecordNotFound.WithCause(err)
+	}
+	return err
+}
+
+// MaskAll applies the field masks of a policy to a list of records
+func MaskAll(policy *TablePolicy, user models.UserModel, records []interface{}) ([]interface{}, error) {
+	masked := make([]interface{}, len(records))
+	for i, record := range records {
+		m, err := policy.Mask(user, record)
+		if err != nil {
+			return nil, err
+		}
+		masked[i] = m
+	}
+	return masked, nil
+}
+
 func (t *BaseTable) PushRecordChange(operation string, id interface{}, data interface{}) {
+	if t.SubscriptionChannel == nil {
+		// Nobody is listening yet, e.g. while tables are being repaired
+		return
+	}
+
 	*t.SubscriptionChannel <- SubChangedRecord{
 		Operation:  operation,
 		TableID:    t.ID,
@@ -177,6 +310,5 @@ func (t *BaseTable) PushRecordChange(operation string, id interface{}, data inte
 }
 
 func (t *BaseTable) CanViewChangedRecord(user models.UserModel, record SubChangedRecord) bool {
-	// Add Permission check
-	return true
+	return t.GetPolicy().CanView(user, record.Record)
 }
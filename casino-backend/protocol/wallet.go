@@ -0,0 +1,16 @@
+package protocol
+
+// WalletService lets games move money in and out of player wallets.
+// Every round ID identifies exactly one bet of the game instance, repeating a call
+// with the same round ID and arguments has no further effect. Bets of other
+// instances can't be settled or refunded, they are not found.
+type WalletService interface {
+	// Returns the balance of the user's wallet in cents
+	GetBalance(userID uint) (uint, error)
+	// Takes the stake from the user's wallet and holds it until the bet is settled or refunded
+	PlaceBet(userID uint, amountCents uint, roundID string) error
+	// Closes the bet and pays out the given amount to the user, 0 if the bet was lost
+	SettleBet(roundID string, payoutCents uint) error
+	// Closes the bet and returns the stake to the user
+	RefundBet(roundID string) error
+}